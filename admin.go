@@ -0,0 +1,140 @@
+package httpcache
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// KeyLister is implemented by the caches that can enumerate the keys of their
+// entries.
+type KeyLister interface {
+	Keys() []string
+}
+
+// EntryInfo describes a cached entry, as reported by the admin handler.
+//
+// Hits and LastAccess are only known for the entries served by the Transport
+// since it was created, and aren't stored in the cache.
+type EntryInfo struct {
+	Key          string      `json:"key"`
+	Size         int         `json:"size"`
+	Status       int         `json:"status,omitempty"`
+	Age          string      `json:"age,omitempty"`
+	Stored       *time.Time  `json:"stored,omitempty"`
+	Expires      *time.Time  `json:"expires,omitempty"`
+	ETag         string      `json:"etag,omitempty"`
+	LastModified string      `json:"last_modified,omitempty"`
+	Vary         string      `json:"vary,omitempty"`
+	Hits         int64       `json:"hits"`
+	LastAccess   *time.Time  `json:"last_access,omitempty"`
+	Header       http.Header `json:"header,omitempty"`
+}
+
+// AdminHandler returns an http.Handler to inspect and purge the cache of t,
+// meant to be mounted on an internal admin mux, e.g. with
+//
+//	mux.Handle("/cache/", http.StripPrefix("/cache", t.AdminHandler()))
+//
+// Entries are addressed by their key, passed in the key query parameter:
+//
+//	GET /             lists the entries, if the cache implements KeyLister
+//	GET /?key=K       shows the entry stored at K, with its headers
+//	DELETE /?key=K    deletes the entry stored at K
+//	DELETE /?prefix=P deletes the entries whose key starts with P
+//
+// The entries are looked up with Peek if the cache is a Peeker, so that
+// inspecting them doesn't count as an access. Other caches read them with
+// Get, which may.
+func (t *Transport) AdminHandler() http.Handler {
+	return http.HandlerFunc(t.serveAdmin)
+}
+
+func (t *Transport) serveAdmin(w http.ResponseWriter, r *http.Request) {
+	key, hasKey := r.URL.Query()["key"]
+	switch {
+	case r.Method == http.MethodGet && hasKey:
+		info, ok := t.entryInfo(key[0], true)
+		if !ok {
+			http.Error(w, "entry not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, info)
+	case r.Method == http.MethodGet:
+		lister, ok := t.cache().(KeyLister)
+		if !ok {
+			http.Error(w, "cache can't list its keys", http.StatusNotImplemented)
+			return
+		}
+		infos := []EntryInfo{}
+		for _, key := range lister.Keys() {
+			if info, ok := t.entryInfo(key, false); ok {
+				infos = append(infos, info)
+			}
+		}
+		writeJSON(w, infos)
+	case r.Method == http.MethodDelete && hasKey:
+		t.delete(r.Context(), key[0])
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodDelete && r.URL.Query().Has("prefix"):
+		lister, ok := t.cache().(KeyLister)
+		if !ok {
+			http.Error(w, "cache can't list its keys", http.StatusNotImplemented)
+			return
+		}
+		prefix := r.URL.Query().Get("prefix")
+		for _, key := range lister.Keys() {
+			if strings.HasPrefix(key, prefix) {
+				t.delete(r.Context(), key)
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// entryInfo describes the entry stored at key, with its headers if
+// withHeader is true, and returns false if there is none.
+func (t *Transport) entryInfo(key string, withHeader bool) (EntryInfo, bool) {
+	b, ok := peek(t.cache(), key)
+	if !ok {
+		return EntryInfo{}, false
+	}
+	access := t.access(key)
+	info := EntryInfo{Key: key, Size: len(b), Hits: access.hits}
+	if !access.last.IsZero() {
+		info.LastAccess = &access.last
+	}
+	resp, err := readEntry(b, nil)
+	if err != nil {
+		return info, true
+	}
+	resp.Body.Close()
+	info.Status = resp.StatusCode
+	if stored, ok := storedTime(resp.Header); ok {
+		info.Stored = &stored
+	}
+	info.ETag = resp.Header.Get("etag")
+	info.LastModified = resp.Header.Get("last-modified")
+	info.Vary = resp.Header.Get("vary")
+	if withHeader {
+		info.Header = resp.Header.Clone()
+		stripMarks(info.Header)
+	}
+	if date, ok := parseDate(resp.Header); ok {
+		info.Age = t.clock().since(date).String()
+		if lifetime, ok := responseLifetime(resp.Header, ParseCacheControl(resp.Header), date); ok {
+			expires := date.Add(lifetime)
+			info.Expires = &expires
+		}
+	}
+	return info, true
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,94 @@
+package httpcache
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestAdminHandler(t *testing.T) {
+	resetTest()
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	client := tp.Client()
+	for _, path := range []string{"/method", "/method", "/etag"} {
+		resp, err := client.Get(s.server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	admin := httptest.NewServer(tp.AdminHandler())
+	defer admin.Close()
+
+	var infos []EntryInfo
+	getJSON(t, admin.URL, &infos)
+	if len(infos) != 2 {
+		t.Fatalf("got %d entries, want 2", len(infos))
+	}
+	hits := map[string]int64{}
+	for _, info := range infos {
+		if info.Size == 0 || info.Status != http.StatusOK || info.Header != nil || info.Stored == nil {
+			t.Errorf("unexpected entry %+v", info)
+		}
+		if (info.LastAccess != nil) != (info.Hits > 0) {
+			t.Errorf("got last access %v with %d hits", info.LastAccess, info.Hits)
+		}
+		hits[info.Key] = info.Hits
+	}
+	if hits[s.server.URL+"/method"] != 1 || hits[s.server.URL+"/etag"] != 0 {
+		t.Fatalf("got hit counts %v", hits)
+	}
+
+	var info EntryInfo
+	getJSON(t, admin.URL+"?key="+url.QueryEscape(s.server.URL+"/etag"), &info)
+	if got := info.Header.Get("Etag"); got != "124567" || info.ETag != got {
+		t.Fatalf("got Etag %q and %q", got, info.ETag)
+	}
+	for name := range info.Header {
+		if strings.HasPrefix(name, "X-Httpcache-") {
+			t.Fatalf("got internal header %s", name)
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodDelete, admin.URL+"?prefix="+url.QueryEscape(s.server.URL+"/m"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("got status %d for DELETE", resp.StatusCode)
+	}
+	resp, err = http.Get(admin.URL + "?key=" + url.QueryEscape(s.server.URL+"/method"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("got status %d for a deleted entry", resp.StatusCode)
+	}
+}
+
+func getJSON(t *testing.T, url string, v interface{}) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d for %s", resp.StatusCode, url)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatal(err)
+	}
+}
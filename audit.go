@@ -0,0 +1,72 @@
+package httpcache
+
+import "context"
+
+type principalKey struct{}
+
+// WithPrincipal returns a new context based on ctx, carrying principal as the
+// logical principal, e.g. a user or a service, on whose behalf the requests
+// made with it access the cache. The Transport reports it to its Audit hook.
+func WithPrincipal(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// Principal returns the principal carried by ctx, or an empty string if
+// there is none.
+func Principal(ctx context.Context) string {
+	principal, _ := ctx.Value(principalKey{}).(string)
+	return principal
+}
+
+// An AuditOp is an operation on the cached entries recorded by the Audit hook
+// of a Transport.
+type AuditOp string
+
+const (
+	// AuditRead is the read of a cached entry, to serve or revalidate it
+	AuditRead AuditOp = "read"
+	// AuditWrite is the storage of an entry
+	AuditWrite AuditOp = "write"
+	// AuditPurge is the removal of an entry, or its soft purge
+	AuditPurge AuditOp = "purge"
+)
+
+// An AuditRecord records an access to the cached entries, for the
+// deployments with compliance requirements, see Transport.Audit.
+type AuditRecord struct {
+	Op AuditOp
+	// Key is the key of the entry, or the prefix of the keys of the
+	// entries if Prefix is true
+	Key    string
+	Prefix bool
+	// URL is the URL of the entry, if known
+	URL string
+	// Namespace is the tenant the entry belongs to: the cozy instance set
+	// by WithInstance, or the one of the Transport of an InstanceTransport
+	Namespace string
+	// Principal is the principal set by WithPrincipal, if any
+	Principal string
+	RequestID string
+}
+
+// audit records op on the entry at key, or on the entries whose key starts
+// with key if prefix is true, made with the context ctx.
+func (t *Transport) audit(ctx context.Context, op AuditOp, key string, prefix bool) {
+	if t.Audit == nil {
+		return
+	}
+	rec := AuditRecord{
+		Op:        op,
+		Key:       key,
+		Prefix:    prefix,
+		Namespace: Instance(ctx),
+		Principal: Principal(ctx),
+		RequestID: RequestID(ctx),
+	}
+	if !prefix {
+		if r, err := keyRequest(key); err == nil {
+			rec.URL = r.URL.String()
+		}
+	}
+	t.Audit(ctx, rec)
+}
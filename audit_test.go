@@ -0,0 +1,67 @@
+package httpcache
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestAudit(t *testing.T) {
+	resetTest()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=3600")
+		io.WriteString(w, "body")
+	}))
+	defer server.Close()
+
+	var (
+		mu      sync.Mutex
+		records []AuditRecord
+	)
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	tp.Audit = func(ctx context.Context, rec AuditRecord) {
+		mu.Lock()
+		records = append(records, rec)
+		mu.Unlock()
+	}
+	it := NewInstanceTransport(tp, 0, 0)
+	ctx := WithPrincipal(WithInstance(context.Background(), "alice.cozy.example"), "konnector")
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := it.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	req, err := http.NewRequestWithContext(WithPrincipal(context.Background(), "admin"), http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	it.ForInstance("alice.cozy.example").Invalidate(req)
+
+	want := []AuditRecord{
+		{Op: AuditWrite, Principal: "konnector"},
+		{Op: AuditRead, Principal: "konnector"},
+		{Op: AuditPurge, Principal: "admin"},
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(records) < len(want) {
+		t.Fatalf("got records %+v, want %+v", records, want)
+	}
+	for i, w := range want {
+		got := records[i]
+		if got.Op != w.Op || got.Principal != w.Principal || got.Namespace != "alice.cozy.example" || got.URL != server.URL || got.Key == "" {
+			t.Errorf("record %d: got %+v, want %s by %s of %s", i, got, w.Op, w.Principal, server.URL)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package httpcache
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// authorizationKeyParam introduces the hash of the Authorization header of a
+// request in its key, see Transport.KeyByAuthorization.
+const authorizationKeyParam = " authorization="
+
+// key returns the key at which the response to req is cached by t.
+func (t *Transport) key(req *http.Request) string {
+	key := cacheKey(req)
+	authorization := req.Header.Get("Authorization")
+	if !t.KeyByAuthorization || authorization == "" {
+		return key
+	}
+	mac := hmac.New(sha256.New, t.authorizationSalt())
+	mac.Write([]byte(authorization))
+	return key + authorizationKeyParam + hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// authorizationSalt returns the salt of the hashes of the Authorization
+// headers in the keys: AuthorizationSalt, or a random one generated on first
+// use.
+func (t *Transport) authorizationSalt() []byte {
+	if len(t.AuthorizationSalt) > 0 {
+		return t.AuthorizationSalt
+	}
+	st := t.state()
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.authorizationSalt == nil {
+		st.authorizationSalt = make([]byte, 32)
+		rand.Read(st.authorizationSalt)
+	}
+	return st.authorizationSalt
+}
+
+// stripAuthorizationKey returns key without the hash of an Authorization
+// header.
+func stripAuthorizationKey(key string) string {
+	key, _, _ = strings.Cut(key, authorizationKeyParam)
+	return key
+}
@@ -0,0 +1,77 @@
+package httpcache
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestKeyByAuthorization(t *testing.T) {
+	resetTest()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=3600")
+		io.WriteString(w, "data of "+r.Header.Get("Authorization"))
+	}))
+	defer server.Close()
+
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	tp.KeyByAuthorization = true
+	tp.AuthorizationSalt = []byte("salt")
+	get := func(authorization string) (string, string) {
+		req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		resp, err := tp.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(body), resp.Header.Get(XFromCache)
+	}
+	get("Bearer alice")
+	if body, from := get("Bearer alice"); from != CacheHit || body != "data of Bearer alice" {
+		t.Fatalf("got %q from %q, want a hit for the same token", body, from)
+	}
+	if body, from := get("Bearer bob"); from == CacheHit || body != "data of Bearer bob" {
+		t.Fatalf("got %q from %q, want the data of another token fetched", body, from)
+	}
+	if _, from := get(""); from == CacheHit {
+		t.Fatal("response cached for a token served without Authorization")
+	}
+
+	for _, key := range tp.Cache.(KeyLister).Keys() {
+		if strings.Contains(key, "alice") || strings.Contains(key, "bob") {
+			t.Fatalf("token leaked in key %q", key)
+		}
+	}
+	if err := tp.ApplyInvalidation(Invalidation{Prefix: server.URL}); err != nil {
+		t.Fatal(err)
+	}
+	if _, from := get("Bearer alice"); from == CacheHit {
+		t.Fatal("response cached for a token not invalidated by prefix")
+	}
+
+	req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer alice")
+	if _, ok := tp.Cache.Get(tp.Key(req)); !ok || tp.Key(req) == Key(req) {
+		t.Fatalf("response for the token not cached at %q", tp.Key(req))
+	}
+	tp.Delete(req)
+	if _, from := get("Bearer alice"); from == CacheHit {
+		t.Fatal("response cached for a token not deleted")
+	}
+}
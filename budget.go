@@ -0,0 +1,142 @@
+package httpcache
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// An OriginBudget caps the requests that the Transport sends to the servers
+// on its own behalf: the revalidations made by Transport.Revalidate and the
+// Scheduler, the prefetches and the warming, independently of the requests
+// of its clients, including their revalidations.
+// The requests over budget wait for their turn, or until their context is
+// done. An OriginBudget may be shared by several Transports.
+type OriginBudget struct {
+	// MaxConcurrent and MaxConcurrentPerHost, if set, are the maximum
+	// numbers of requests in flight at once, in total and to each host
+	MaxConcurrent        int
+	MaxConcurrentPerHost int
+	// Rate and RatePerHost, if set, are the maximum numbers of requests
+	// started per second, in total and to each host. The requests are
+	// evenly spaced, without bursts.
+	Rate, RatePerHost float64
+
+	mu       sync.Mutex
+	changed  chan struct{} // closed when a request ends
+	inFlight int
+	hosts    map[string]*hostBudget
+	next     time.Time // when the next request may start
+}
+
+// hostBudget is the budget used by the requests to a host.
+type hostBudget struct {
+	inFlight int
+	next     time.Time
+}
+
+type backgroundKey struct{}
+
+// withBackground returns a new context based on ctx, for the requests sent
+// by the Transport on its own behalf, under its OriginBudget.
+func withBackground(ctx context.Context) context.Context {
+	return context.WithValue(ctx, backgroundKey{}, true)
+}
+
+// isBackground reports whether ctx is the one of a request sent by the
+// Transport on its own behalf.
+func isBackground(ctx context.Context) bool {
+	return ctx.Value(backgroundKey{}) != nil || ctx.Value(prefetchKey{}) != nil
+}
+
+// acquire waits until a request to host fits in b, or ctx is done, and
+// returns the function to call when the request ends.
+func (b *OriginBudget) acquire(ctx context.Context, host string) (release func(), err error) {
+	for {
+		b.mu.Lock()
+		if b.hosts == nil {
+			b.hosts = make(map[string]*hostBudget)
+			b.changed = make(chan struct{})
+		}
+		h, ok := b.hosts[host]
+		if !ok {
+			h = &hostBudget{}
+			b.hosts[host] = h
+		}
+		if (b.MaxConcurrent > 0 && b.inFlight >= b.MaxConcurrent) ||
+			(b.MaxConcurrentPerHost > 0 && h.inFlight >= b.MaxConcurrentPerHost) {
+			changed := b.changed
+			b.mu.Unlock()
+			select {
+			case <-changed:
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		now := time.Now()
+		start := now
+		if b.next.After(start) {
+			start = b.next
+		}
+		if h.next.After(start) {
+			start = h.next
+		}
+		if b.Rate > 0 {
+			b.next = start.Add(time.Duration(float64(time.Second) / b.Rate))
+		}
+		if b.RatePerHost > 0 {
+			h.next = start.Add(time.Duration(float64(time.Second) / b.RatePerHost))
+		}
+		b.inFlight++
+		h.inFlight++
+		b.mu.Unlock()
+
+		release = func() {
+			b.mu.Lock()
+			b.inFlight--
+			h.inFlight--
+			if h.inFlight == 0 && !h.next.After(time.Now()) {
+				delete(b.hosts, host)
+			}
+			close(b.changed)
+			b.changed = make(chan struct{})
+			b.mu.Unlock()
+		}
+		if wait := start.Sub(now); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				release()
+				return nil, ctx.Err()
+			}
+		}
+		return release, nil
+	}
+}
+
+// budgetBody is the body of a response to a request sent under an
+// OriginBudget, which releases the budget used by the request once it is
+// read or closed.
+type budgetBody struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+func (b *budgetBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil {
+		b.once.Do(b.release)
+	}
+	return n, err
+}
+
+func (b *budgetBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
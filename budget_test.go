@@ -0,0 +1,176 @@
+package httpcache
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestOriginBudget(t *testing.T) {
+	resetTest()
+	var (
+		mu                 sync.Mutex
+		inFlight, observed int
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > observed {
+			observed = inFlight
+		}
+		mu.Unlock()
+		time.Sleep(50 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		w.Header().Set("Cache-Control", "max-age=3600")
+		io.WriteString(w, "body")
+	}))
+	defer server.Close()
+
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	tp.OriginBudget = &OriginBudget{MaxConcurrentPerHost: 2}
+	var urls []string
+	for i := 0; i < 8; i++ {
+		urls = append(urls, server.URL+"/"+strconv.Itoa(i))
+	}
+	for _, result := range tp.Warm(context.Background(), urls, len(urls)) {
+		if result.Err != nil {
+			t.Fatal(result.Err)
+		}
+	}
+	if observed > 2 {
+		t.Fatalf("got %d concurrent warming requests, want at most 2", observed)
+	}
+
+	// The requests of the clients aren't limited
+	observed = 0
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, err := tp.Client().Get(server.URL + "/client/" + strconv.Itoa(i))
+			if err == nil {
+				resp.Body.Close()
+			}
+		}(i)
+	}
+	wg.Wait()
+	if observed <= 2 {
+		t.Fatalf("got %d concurrent client requests, want them unlimited", observed)
+	}
+}
+
+func TestOriginBudgetRate(t *testing.T) {
+	b := &OriginBudget{Rate: 50}
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		release, err := b.acquire(context.Background(), "example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		release()
+	}
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Fatalf("5 requests started in %s, want them spaced by 20ms", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	b = &OriginBudget{MaxConcurrent: 1}
+	release, _ := b.acquire(context.Background(), "example.com")
+	defer release()
+	if _, err := b.acquire(ctx, "example.com"); err != context.Canceled {
+		t.Fatalf("got error %v, want context.Canceled", err)
+	}
+}
+
+func TestOriginBudgetBody(t *testing.T) {
+	resetTest()
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "head")
+		w.(http.Flusher).Flush()
+		<-done
+		io.WriteString(w, "tail")
+	}))
+	defer server.Close()
+
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	b := &OriginBudget{MaxConcurrent: 1}
+	tp.OriginBudget = b
+	inFlight := func() int {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		return b.inFlight
+	}
+	req, _ := http.NewRequestWithContext(withBackground(context.Background()), http.MethodGet, server.URL, nil)
+	resp, err := tp.send(http.DefaultTransport, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := inFlight(); n != 1 {
+		t.Fatalf("got %d requests in flight while reading the body, want 1", n)
+	}
+	close(done)
+	if _, err := ioutil.ReadAll(resp.Body); err != nil {
+		t.Fatal(err)
+	}
+	if n := inFlight(); n != 0 {
+		t.Fatalf("got %d requests in flight after reading the body, want 0", n)
+	}
+	resp.Body.Close()
+	if n := inFlight(); n != 0 {
+		t.Fatalf("got %d requests in flight after closing the body, want 0", n)
+	}
+}
+
+func TestOriginBudgetRevalidations(t *testing.T) {
+	resetTest()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		io.WriteString(w, "body")
+	}))
+	defer server.Close()
+
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	b := &OriginBudget{MaxConcurrent: 1}
+	tp.OriginBudget = b
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	get := func() {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+		resp, err := tp.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	get()
+	get()
+	get()
+	// Revalidate goes through the budget, and releases it on 304
+	for i := 0; i < 2; i++ {
+		if _, err := tp.Revalidate(ctx, server.URL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.inFlight != 0 {
+		t.Fatalf("got %d requests in flight after the revalidations, want 0", b.inFlight)
+	}
+}
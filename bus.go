@@ -0,0 +1,196 @@
+package httpcache
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// An Invalidation selects cached responses to purge. Exactly one of URL,
+// Prefix and Tag is set.
+type Invalidation struct {
+	// URL selects the responses for the resource at URL
+	URL string `json:"url,omitempty"`
+	// Prefix selects the responses whose key starts with Prefix
+	Prefix string `json:"prefix,omitempty"`
+	// Tag selects the responses tagged with Tag, see PurgeTag
+	Tag string `json:"tag,omitempty"`
+	// Soft marks the responses as stale instead of deleting them, see
+	// SoftPurge
+	Soft bool `json:"soft,omitempty"`
+}
+
+// An InvalidationBus broadcasts invalidations between the processes sharing
+// or mirroring a cache, so that all their in-memory caches drop the entries
+// promptly.
+type InvalidationBus interface {
+	// Publish sends inv to the subscribers of the bus, including those of
+	// the publishing process.
+	Publish(inv Invalidation) error
+	// Subscribe calls handler with the invalidations published on the bus,
+	// until stop is called.
+	Subscribe(handler func(Invalidation)) (stop func(), err error)
+}
+
+// ListenInvalidations subscribes t to its InvalidationBus, and applies the
+// invalidations published on it to its cache, until stop is called. Those
+// published by t itself are applied again, which is harmless.
+func (t *Transport) ListenInvalidations() (stop func(), err error) {
+	if t.InvalidationBus == nil {
+		return nil, errors.New("httpcache: no InvalidationBus")
+	}
+	return t.InvalidationBus.Subscribe(func(inv Invalidation) {
+		if err := t.applyInvalidation(context.Background(), inv); err != nil {
+			t.logError(context.Background(), "invalid invalidation", err)
+		}
+	})
+}
+
+// ApplyInvalidation applies inv to the cache of t, and broadcasts it on the
+// InvalidationBus of t, if any. It lets external systems, such as webhooks,
+// feed invalidations into the Transport without knowing the cache internals.
+func (t *Transport) ApplyInvalidation(inv Invalidation) error {
+	return t.invalidate(context.Background(), inv)
+}
+
+// invalidate is like ApplyInvalidation, on behalf of the principal of ctx.
+func (t *Transport) invalidate(ctx context.Context, inv Invalidation) error {
+	if err := t.applyInvalidation(ctx, inv); err != nil {
+		return err
+	}
+	t.publish(inv)
+	return nil
+}
+
+// ConsumeInvalidations applies the invalidations received from ch, e.g.
+// decoded from a message queue, until ch is closed or ctx is done. Those that
+// fail are logged. If every instance consumes the same source, there is no
+// need for an InvalidationBus.
+func (t *Transport) ConsumeInvalidations(ctx context.Context, ch <-chan Invalidation) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case inv, ok := <-ch:
+			if !ok {
+				return
+			}
+			if err := t.invalidate(ctx, inv); err != nil {
+				t.logError(ctx, "applying invalidation", err)
+			}
+		}
+	}
+}
+
+// publish broadcasts inv on the InvalidationBus of t, if any.
+func (t *Transport) publish(inv Invalidation) {
+	if t.InvalidationBus == nil {
+		return
+	}
+	if err := t.InvalidationBus.Publish(inv); err != nil {
+		t.logError(context.Background(), "publishing invalidation", err)
+	}
+}
+
+// applyInvalidation applies inv to the cache of t only, on behalf of the
+// principal of ctx.
+func (t *Transport) applyInvalidation(ctx context.Context, inv Invalidation) error {
+	var keys []string
+	switch {
+	case inv.URL != "":
+		u, err := url.Parse(inv.URL)
+		if err != nil {
+			return err
+		}
+		for _, r := range resourceRequests(u) {
+			if inv.Soft {
+				t.softPurge(ctx, cacheKey(r), r)
+			} else {
+				t.delete(ctx, cacheKey(r))
+			}
+		}
+		return nil
+	case inv.Prefix != "" && !inv.Soft:
+		_, err := t.purgePrefix(ctx, inv.Prefix)
+		return err
+	case inv.Prefix != "":
+		for _, c := range t.purgedCaches() {
+			lister, ok := c.(KeyLister)
+			if !ok {
+				return ErrCannotPurge
+			}
+			for _, key := range lister.Keys() {
+				if strings.HasPrefix(key, inv.Prefix) {
+					keys = append(keys, key)
+				}
+			}
+		}
+	case inv.Tag != "":
+		keys = t.taggedKeys(inv.Tag)
+	default:
+		return errors.New("httpcache: empty invalidation")
+	}
+	for _, key := range keys {
+		if !inv.Soft {
+			t.delete(ctx, key)
+		} else if r, err := keyRequest(key); err == nil {
+			t.softPurge(ctx, key, r)
+		}
+	}
+	return nil
+}
+
+// logError logs an error that can't be returned, with the request ID of ctx
+// if any, if t has a Logger.
+func (t *Transport) logError(ctx context.Context, msg string, err error) {
+	if t.Logger == nil {
+		return
+	}
+	attrs := []slog.Attr{slog.String("error", err.Error())}
+	if id := RequestID(ctx); id != "" {
+		attrs = append(attrs, slog.String("request_id", id))
+	}
+	t.Logger.LogAttrs(ctx, slog.LevelError, "httpcache: "+msg, attrs...)
+}
+
+// LocalBus is an InvalidationBus connecting the Transports of a single
+// process, e.g. those wrapping an in-memory cache in front of a shared one.
+type LocalBus struct {
+	mu       sync.Mutex
+	handlers map[int]func(Invalidation)
+	next     int
+}
+
+// Publish calls the handlers subscribed to b with inv.
+func (b *LocalBus) Publish(inv Invalidation) error {
+	b.mu.Lock()
+	handlers := make([]func(Invalidation), 0, len(b.handlers))
+	for _, handler := range b.handlers {
+		handlers = append(handlers, handler)
+	}
+	b.mu.Unlock()
+	for _, handler := range handlers {
+		handler(inv)
+	}
+	return nil
+}
+
+// Subscribe registers handler until stop is called.
+func (b *LocalBus) Subscribe(handler func(Invalidation)) (stop func(), err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.handlers == nil {
+		b.handlers = make(map[int]func(Invalidation))
+	}
+	id := b.next
+	b.next++
+	b.handlers[id] = handler
+	return func() {
+		b.mu.Lock()
+		delete(b.handlers, id)
+		b.mu.Unlock()
+	}, nil
+}
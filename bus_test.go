@@ -0,0 +1,77 @@
+package httpcache
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestInvalidationBus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Header().Set("Surrogate-Key", "all")
+	}))
+	defer server.Close()
+
+	bus := &LocalBus{}
+	var transports []*Transport
+	for i := 0; i < 2; i++ {
+		tp := NewMemoryCacheTransport(defaultMaxEntries)
+		tp.InvalidationBus = bus
+		stop, err := tp.ListenInvalidations()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer stop()
+		for _, path := range []string{"/a", "/b", "/c/1", "/c/2"} {
+			resp, err := tp.Client().Get(server.URL + path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		transports = append(transports, tp)
+	}
+	a, b := transports[0], transports[1]
+	count := func(tp *Transport) int { return len(tp.Cache.(KeyLister).Keys()) }
+
+	if err := a.InvalidateURL(server.URL + "/a"); err != nil {
+		t.Fatal(err)
+	}
+	if count(a) != 3 || count(b) != 3 {
+		t.Fatalf("got %d and %d entries after invalidating a URL, want 3", count(a), count(b))
+	}
+	if _, err := b.PurgePrefix(server.URL + "/c/"); err != nil {
+		t.Fatal(err)
+	}
+	if count(a) != 1 || count(b) != 1 {
+		t.Fatalf("got %d and %d entries after purging a prefix, want 1", count(a), count(b))
+	}
+	a.PurgeTag("all")
+	if count(a) != 0 || count(b) != 0 {
+		t.Fatalf("got %d and %d entries after purging a tag, want 0", count(a), count(b))
+	}
+}
+
+func TestConsumeInvalidations(t *testing.T) {
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	for _, key := range []string{"http://example.com/a", "HEAD http://example.com/a", "http://example.com/b/1", "http://example.com/c"} {
+		tp.Cache.Set(key, []byte("HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n"))
+	}
+	if err := tp.ApplyInvalidation(Invalidation{}); err == nil {
+		t.Fatal("no error for an empty invalidation")
+	}
+
+	ch := make(chan Invalidation, 2)
+	ch <- Invalidation{URL: "http://example.com/a"}
+	ch <- Invalidation{Prefix: "http://example.com/b/"}
+	close(ch)
+	tp.ConsumeInvalidations(context.Background(), ch)
+	if keys := tp.Cache.(KeyLister).Keys(); len(keys) != 1 || keys[0] != "http://example.com/c" {
+		t.Fatalf("got keys %q after consuming invalidations", keys)
+	}
+}
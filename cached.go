@@ -0,0 +1,30 @@
+package httpcache
+
+import "net/http"
+
+// Cached returns the response cached for req if it can be served without
+// contacting the server, according to the freshness rules of t and the
+// Cache-Control directives of req, and false otherwise. It lets applications
+// probe the cache without sending a request with an only-if-cached directive
+// and telling the resulting 504 Gateway Timeout from a real one.
+//
+// The response is marked as it would be by RoundTrip, but the lookup isn't
+// counted in the statistics of t nor reported to its hooks.
+func (t *Transport) Cached(req *http.Request) (*http.Response, bool) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead || req.Header.Get("range") != "" {
+		return nil, false
+	}
+	key := t.key(req)
+	cachedResp, err := t.cachedResponse(key, req)
+	if err != nil || cachedResp == nil {
+		return nil, false
+	}
+	info := t.freshness(req, key, t.policy(req.URL), cachedResp.Header)
+	if info.freshness != fresh {
+		cachedResp.Body.Close()
+		return nil, false
+	}
+	stripMarks(cachedResp.Header)
+	t.markFresh(cachedResp, &info)
+	return cachedResp, true
+}
@@ -0,0 +1,45 @@
+package httpcache
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+func TestCached(t *testing.T) {
+	resetTest()
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	lookup := func(path string) (*http.Response, bool) {
+		req, err := http.NewRequest(http.MethodGet, s.server.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tp.Cached(req)
+	}
+	if _, ok := lookup("/method"); ok {
+		t.Fatal("response found in an empty cache")
+	}
+	for _, path := range []string{"/method", "/etag"} {
+		resp, err := tp.Client().Get(s.server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	resp, ok := lookup("/method")
+	if !ok {
+		t.Fatal("fresh response not found")
+	}
+	if got := resp.Header.Get(XFromCache); got != CacheHit {
+		t.Fatalf("got X-From-Cache %q, want %q", got, CacheHit)
+	}
+	if _, ok := lookup("/etag"); ok {
+		t.Fatal("response needing revalidation found")
+	}
+	if stats := tp.Stats(); stats.Hits != 0 {
+		t.Fatalf("lookups counted as %d hits", stats.Hits)
+	}
+}
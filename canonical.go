@@ -0,0 +1,216 @@
+package httpcache
+
+import (
+	"net"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+// canonicalURL returns the canonical form of u used in the cache keys, so
+// that the equivalent URLs share one entry, per RFC 3986, section 6.2.2: the
+// scheme and host are lower-cased, the international domain names are
+// converted to punycode, the default port is removed, the percent-encodings
+// are normalized, and the dot-segments of the path are resolved.
+func canonicalURL(u *url.URL) string {
+	if u.Opaque != "" || u.Host == "" {
+		return u.String()
+	}
+	c := *u
+	c.Scheme = strings.ToLower(u.Scheme)
+	c.Host = canonicalHost(c.Scheme, u)
+	if escaped := removeDotSegments(normalizeEscapes(u.EscapedPath())); escaped != u.EscapedPath() {
+		if path, err := url.PathUnescape(escaped); err == nil {
+			c.Path, c.RawPath = path, escaped
+		}
+	}
+	c.RawQuery = normalizeEscapes(u.RawQuery)
+	return c.String()
+}
+
+// canonicalHost returns the host of u, lower-cased and in punycode, with its
+// port unless it is the default one of scheme.
+func canonicalHost(scheme string, u *url.URL) string {
+	host := strings.ToLower(u.Hostname())
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	} else if net.ParseIP(host) == nil {
+		labels := strings.Split(host, ".")
+		for i, label := range labels {
+			if !isASCII(label) {
+				labels[i] = "xn--" + punycode(label)
+			}
+		}
+		host = strings.Join(labels, ".")
+	}
+	port := u.Port()
+	if port == "" || (scheme == "http" && port == "80") || (scheme == "https" && port == "443") {
+		return host
+	}
+	return host + ":" + port
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeEscapes returns s with the hexadecimal digits of its
+// percent-encodings in upper case, and the unreserved characters decoded.
+func normalizeEscapes(s string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+	const upperhex = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' || i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2]) {
+			b.WriteByte(s[i])
+			continue
+		}
+		c := unhex(s[i+1])<<4 | unhex(s[i+2])
+		if isUnreserved(c) {
+			b.WriteByte(c)
+		} else {
+			b.WriteByte('%')
+			b.WriteByte(upperhex[c>>4])
+			b.WriteByte(upperhex[c&15])
+		}
+		i += 2
+	}
+	return b.String()
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
+
+// isUnreserved reports whether c is an unreserved character of RFC 3986,
+// that percent-encoding doesn't change the meaning of.
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+// removeDotSegments resolves the "." and ".." segments of the absolute path
+// p, per RFC 3986, section 5.2.4.
+func removeDotSegments(p string) string {
+	if !strings.HasPrefix(p, "/") || !strings.Contains(p, ".") {
+		return p
+	}
+	segments := strings.Split(p[1:], "/")
+	out := make([]string, 0, len(segments))
+	for i, segment := range segments {
+		last := i == len(segments)-1
+		switch segment {
+		case ".":
+		case "..":
+			if len(out) > 0 {
+				out = out[:len(out)-1]
+			}
+		default:
+			out = append(out, segment)
+			continue
+		}
+		if last {
+			out = append(out, "")
+		}
+	}
+	return "/" + strings.Join(out, "/")
+}
+
+// punycode returns the punycode encoding of the domain name label s, per
+// RFC 3492, without the ACE prefix.
+func punycode(s string) string {
+	const (
+		base, tmin, tmax = 36, 1, 26
+		skew, damp       = 38, 700
+	)
+	adapt := func(delta, points int, first bool) int {
+		if first {
+			delta /= damp
+		} else {
+			delta /= 2
+		}
+		delta += delta / points
+		k := 0
+		for delta > (base-tmin)*tmax/2 {
+			delta /= base - tmin
+			k += base
+		}
+		return k + (base-tmin+1)*delta/(delta+skew)
+	}
+	digit := func(d int) byte {
+		if d < 26 {
+			return byte('a' + d)
+		}
+		return byte('0' + d - 26)
+	}
+
+	runes := []rune(s)
+	var out []byte
+	for _, r := range runes {
+		if r < utf8.RuneSelf {
+			out = append(out, byte(r))
+		}
+	}
+	basic := len(out)
+	if basic > 0 {
+		out = append(out, '-')
+	}
+	n, delta, bias := utf8.RuneSelf, 0, 72
+	for h := basic; h < len(runes); {
+		m := int(utf8.MaxRune) + 1
+		for _, r := range runes {
+			if int(r) >= n && int(r) < m {
+				m = int(r)
+			}
+		}
+		delta += (m - n) * (h + 1)
+		n = m
+		for _, r := range runes {
+			if int(r) < n {
+				delta++
+			}
+			if int(r) != n {
+				continue
+			}
+			q := delta
+			for k := base; ; k += base {
+				t := k - bias
+				if t < tmin {
+					t = tmin
+				} else if t > tmax {
+					t = tmax
+				}
+				if q < t {
+					break
+				}
+				out = append(out, digit(t+(q-t)%(base-t)))
+				q = (q - t) / (base - t)
+			}
+			out = append(out, digit(q))
+			bias = adapt(delta, h+1, h == basic)
+			delta = 0
+			h++
+		}
+		delta++
+		n++
+	}
+	return string(out)
+}
@@ -0,0 +1,46 @@
+package httpcache
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestCanonicalURL(t *testing.T) {
+	for rawurl, want := range map[string]string{
+		"http://example.com/a":               "http://example.com/a",
+		"HTTP://Example.COM/a":               "http://example.com/a",
+		"http://example.com:80/a":            "http://example.com/a",
+		"https://example.com:443/a":          "https://example.com/a",
+		"http://example.com:8080/a":          "http://example.com:8080/a",
+		"http://example.com/%7euser/%2fa%2F": "http://example.com/~user/%2Fa%2F",
+		"http://example.com/a?q=%7e%3d":      "http://example.com/a?q=~%3D",
+		"http://example.com/a/./b/../c":      "http://example.com/a/c",
+		"http://example.com/a/b/..":          "http://example.com/a/",
+		"http://example.com/../a":            "http://example.com/a",
+		"http://example.com/a/%2E%2E/b":      "http://example.com/b",
+		"http://bücher.example/a":            "http://xn--bcher-kva.example/a",
+		"http://xn--bcher-kva.example/a":     "http://xn--bcher-kva.example/a",
+		"http://[::1]:80/a":                  "http://[::1]/a",
+		"http://127.0.0.1:8080":              "http://127.0.0.1:8080",
+	} {
+		u, err := url.Parse(rawurl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := canonicalURL(u); got != want {
+			t.Errorf("canonicalURL(%q) = %q, want %q", rawurl, got, want)
+		}
+	}
+}
+
+func TestPunycode(t *testing.T) {
+	for label, want := range map[string]string{
+		"bücher":  "bcher-kva",
+		"münchen": "mnchen-3ya",
+		"日本語":     "wgv71a119e",
+	} {
+		if got := punycode(label); got != want {
+			t.Errorf("punycode(%q) = %q, want %q", label, got, want)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package httpcache
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"iter"
+	"net/http"
+)
+
+// A Change is the state of a resource synchronized by Changes.
+type Change struct {
+	URL string
+	// Changed reports whether the resource changed since the last run: it
+	// wasn't cached, or the server sent a new response instead of
+	// validating the cached one. The resources cached without validators
+	// are always reported as changed.
+	Changed bool
+	// Response is the current response for the resource, whose body must
+	// be closed, and read to the end for a changed resource to be stored
+	Response *http.Response
+	// Err is the error that prevented requesting the resource, if any
+	Err error
+}
+
+// Changes returns an iterator requesting each of urls in turn through t,
+// revalidating with the stored validators the responses cached by the
+// previous runs even if they are fresh, and yielding whether they changed,
+// for periodic synchronization jobs such as konnectors or feed readers. The
+// iteration stops when ctx is done.
+func (t *Transport) Changes(ctx context.Context, urls []string) iter.Seq[Change] {
+	return func(yield func(Change) bool) {
+		for _, u := range urls {
+			if ctx.Err() != nil {
+				return
+			}
+			if !yield(t.change(ctx, u)) {
+				return
+			}
+		}
+	}
+}
+
+// Revalidate requests rawurl through t, with a conditional request if a
+// response is cached for it even if it is fresh, or else a full one, and
+// updates the cache with the response. It reports whether the resource
+// changed, see Change, e.g. to refresh the cache when notified by a webhook.
+func (t *Transport) Revalidate(ctx context.Context, rawurl string) (changed bool, err error) {
+	changed, _, err = t.revalidate(ctx, rawurl)
+	return changed, err
+}
+
+// revalidate is like Revalidate, and also returns the response, whose body
+// has been read and closed.
+func (t *Transport) revalidate(ctx context.Context, rawurl string) (changed bool, resp *http.Response, err error) {
+	c := t.change(ctx, rawurl)
+	if c.Err != nil {
+		return false, nil, c.Err
+	}
+	defer c.Response.Body.Close()
+	if _, err := io.Copy(ioutil.Discard, c.Response.Body); err != nil {
+		return false, nil, err
+	}
+	return c.Changed, c.Response, nil
+}
+
+// change requests u through t, forcing the revalidation of its cached
+// response, and tells whether it changed.
+func (t *Transport) change(ctx context.Context, u string) Change {
+	found, validated := false, false
+	ctx = WithClientTrace(ctx, &ClientTrace{
+		GotCacheLookup: func(ok bool) { found = ok },
+		RevalidationDone: func(modified bool, err error) {
+			validated = err == nil && !modified
+		},
+	})
+	req, err := http.NewRequestWithContext(withBackground(ctx), http.MethodGet, u, nil)
+	if err != nil {
+		return Change{URL: u, Err: err}
+	}
+	req.Header.Set("Cache-Control", "max-age=0")
+	resp, err := t.RoundTrip(req)
+	if err != nil {
+		return Change{URL: u, Err: err}
+	}
+	return Change{URL: u, Changed: !found || !validated, Response: resp}
+}
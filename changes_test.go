@@ -0,0 +1,91 @@
+package httpcache
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestChanges(t *testing.T) {
+	resetTest()
+	versions := map[string]string{"/a": "1", "/b": "1"}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		etag := `"` + versions[r.URL.Path] + `"`
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		io.WriteString(w, versions[r.URL.Path])
+	}))
+	defer server.Close()
+
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	urls := []string{server.URL + "/a", server.URL + "/b"}
+	changed := func() map[string]bool {
+		got := map[string]bool{}
+		for change := range tp.Changes(context.Background(), urls) {
+			if change.Err != nil {
+				t.Fatal(change.Err)
+			}
+			io.Copy(ioutil.Discard, change.Response.Body)
+			change.Response.Body.Close()
+			got[change.URL[len(server.URL):]] = change.Changed
+		}
+		return got
+	}
+	if got := changed(); !got["/a"] || !got["/b"] {
+		t.Fatalf("got changes %v on the first run, want all", got)
+	}
+	versions["/b"] = "2"
+	if got := changed(); got["/a"] || !got["/b"] {
+		t.Fatalf("got changes %v, want /b only", got)
+	}
+	if got := changed(); got["/a"] || got["/b"] {
+		t.Fatalf("got changes %v, want none", got)
+	}
+}
+
+func TestRevalidate(t *testing.T) {
+	resetTest()
+	version := "1"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		etag := `"` + version + `"`
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		io.WriteString(w, version)
+	}))
+	defer server.Close()
+
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	for i, want := range []bool{true, false} {
+		changed, err := tp.Revalidate(context.Background(), server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if changed != want {
+			t.Fatalf("got changed %v on run %d, want %v", changed, i, want)
+		}
+	}
+	version = "2"
+	if changed, err := tp.Revalidate(context.Background(), server.URL); err != nil || !changed {
+		t.Fatalf("got changed %v, %v, want the new version", changed, err)
+	}
+	resp, err := tp.Client().Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.Header.Get(XFromCache) != CacheHit || string(body) != "2" {
+		t.Fatalf("got %q from %q, want the new version cached", body, resp.Header.Get(XFromCache))
+	}
+}
@@ -0,0 +1,90 @@
+package httpcache
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// CheckOptions configures CheckConsistency.
+type CheckOptions struct {
+	// DryRun only reports the problems found, without removing anything
+	DryRun bool
+	// MaxStale is how long after expiring the responses are still useful,
+	// as for GC
+	MaxStale time.Duration
+	// MaxEntrySize is the maximum size of an entry, in bytes. Zero means
+	// no limit.
+	MaxEntrySize int
+}
+
+// CheckReport lists the keys of the problematic entries found by
+// CheckConsistency.
+type CheckReport struct {
+	// Scanned is the number of entries checked
+	Scanned int
+	// Unparseable are the entries that aren't valid HTTP responses
+	Unparseable []string
+	// Orphaned are the entries whose key doesn't map back to a request, so
+	// that no request can ever be served from them
+	Orphaned []string
+	// Useless are the entries that can't be of any use anymore
+	Useless []string
+	// Oversized are the entries larger than MaxEntrySize
+	Oversized []string
+}
+
+// Problems returns the number of problematic entries found.
+func (r CheckReport) Problems() int {
+	return len(r.Unparseable) + len(r.Orphaned) + len(r.Useless) + len(r.Oversized)
+}
+
+// String summarizes the report.
+func (r CheckReport) String() string {
+	return fmt.Sprintf("%d entries scanned: %d unparseable, %d orphaned, %d useless, %d oversized",
+		r.Scanned, len(r.Unparseable), len(r.Orphaned), len(r.Useless), len(r.Oversized))
+}
+
+// CheckConsistency scans the entries of c, which must implement KeyLister,
+// and removes those that are unparseable, orphaned, useless or too large,
+// unless opts.DryRun is set. It returns the problems found.
+func CheckConsistency(c Cache, opts CheckOptions) (CheckReport, error) {
+	var report CheckReport
+	lister, ok := c.(KeyLister)
+	if !ok {
+		return report, fmt.Errorf("httpcache: checking consistency requires a KeyLister")
+	}
+	for _, key := range lister.Keys() {
+		b, ok := c.Get(key)
+		if !ok {
+			continue
+		}
+		report.Scanned++
+		var list *[]string
+		if orphaned(key) {
+			list = &report.Orphaned
+		} else if resp, err := parseEntry(key, b); err != nil {
+			list = &report.Unparseable
+		} else if uselessResponse(resp, opts.MaxStale) {
+			list = &report.Useless
+		} else if opts.MaxEntrySize > 0 && len(b) > opts.MaxEntrySize {
+			list = &report.Oversized
+		} else {
+			continue
+		}
+		*list = append(*list, key)
+		if !opts.DryRun {
+			c.Delete(key)
+		}
+	}
+	return report, nil
+}
+
+// orphaned reports whether key isn't the key of a cacheable request.
+func orphaned(key string) bool {
+	req, err := keyRequest(key)
+	if err != nil {
+		return true
+	}
+	return !req.URL.IsAbs() || (req.Method != http.MethodGet && req.Method != http.MethodHead)
+}
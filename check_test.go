@@ -0,0 +1,44 @@
+package httpcache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckConsistency(t *testing.T) {
+	resetTest()
+	c := NewMemoryCache(0)
+	date := time.Now().UTC().Format(http.TimeFormat)
+	entry := func(headers, body string) []byte {
+		return []byte("HTTP/1.1 200 OK\r\nDate: " + date + "\r\n" + headers + "Content-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body)
+	}
+	c.Set("http://example.com/ok", entry("Cache-Control: max-age=3600\r\n", "ok"))
+	c.Set("http://example.com/garbage", []byte("garbage"))
+	c.Set("not a key", entry("", ""))
+	c.Set("http://example.com/expired", entry("Cache-Control: max-age=60\r\n", ""))
+	c.Set("http://example.com/big", entry("", strings.Repeat("x", 1000)))
+	clock = &fakeClock{elapsed: time.Hour}
+
+	opts := CheckOptions{DryRun: true, MaxEntrySize: 500}
+	report, err := CheckConsistency(c, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := report.String(), "5 entries scanned: 1 unparseable, 1 orphaned, 1 useless, 1 oversized"; got != want {
+		t.Fatalf("got report %q, want %q", got, want)
+	}
+	if len(c.Keys()) != 5 {
+		t.Fatal("entries removed during a dry run")
+	}
+
+	opts.DryRun = false
+	if report, _ = CheckConsistency(c, opts); report.Problems() != 4 {
+		t.Fatalf("got %d problems, want 4", report.Problems())
+	}
+	if keys := c.Keys(); len(keys) != 1 || keys[0] != "http://example.com/ok" {
+		t.Fatalf("got keys %q after repairing", keys)
+	}
+}
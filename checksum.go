@@ -0,0 +1,178 @@
+package httpcache
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"time"
+)
+
+// checksumMagic prefixes the entries stored by a ChecksumCache, followed by
+// the CRC-32C of the entry.
+var checksumMagic = []byte("hc\x00\x01")
+
+// checksumSize is the size of the prefix of the entries of a ChecksumCache:
+// checksumMagic and the CRC-32C.
+const checksumSize = 4 + 4
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// ChecksumCache is a Cache storing a checksum with each entry of the
+// underlying Cache, and verifying it on reads, to guard against torn writes
+// on disk backends and bit flips in long-lived stores. Corrupt entries are
+// deleted and reported as missing, as are the entries stored before the
+// cache was wrapped. The optional interfaces of Cache are forwarded to the
+// underlying Cache when it implements them.
+type ChecksumCache struct {
+	// Cache is the underlying cache
+	Cache Cache
+	// OnCorrupt, if set, is called with the key of each corrupt entry found
+	OnCorrupt func(key string)
+}
+
+// NewChecksumCache returns a ChecksumCache storing the entries in c.
+func NewChecksumCache(c Cache) *ChecksumCache {
+	return &ChecksumCache{Cache: c}
+}
+
+// Get returns the response stored at key if present and intact.
+func (c *ChecksumCache) Get(key string) (resp []byte, ok bool) {
+	b, ok := c.Cache.Get(key)
+	if !ok {
+		return nil, false
+	}
+	return c.verify(key, b)
+}
+
+// GetContext returns the response stored at key if present and intact,
+// reading it with GetContext if the underlying cache is a ContextGetter.
+func (c *ChecksumCache) GetContext(ctx context.Context, key string) (resp []byte, ok bool, err error) {
+	cg, isGetter := c.Cache.(ContextGetter)
+	if !isGetter {
+		resp, ok = c.Get(key)
+		return resp, ok, nil
+	}
+	b, ok, err := cg.GetContext(ctx, key)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	resp, ok = c.verify(key, b)
+	return resp, ok, nil
+}
+
+// Peek returns the response stored at key if present and intact, without
+// perturbing the underlying cache if it is a Peeker. Corrupt entries are
+// reported as missing, but left in place.
+func (c *ChecksumCache) Peek(key string) (resp []byte, ok bool) {
+	b, ok := peek(c.Cache, key)
+	if !ok {
+		return nil, false
+	}
+	return unchecksummed(b)
+}
+
+// verify returns the response b stored at key without its checksum if it is
+// intact, or else deletes it and reports it as corrupt.
+func (c *ChecksumCache) verify(key string, b []byte) ([]byte, bool) {
+	resp, ok := unchecksummed(b)
+	if !ok {
+		c.Cache.Delete(key)
+		if c.OnCorrupt != nil {
+			c.OnCorrupt(key)
+		}
+	}
+	return resp, ok
+}
+
+// Set saves resp with its checksum at key.
+func (c *ChecksumCache) Set(key string, resp []byte) {
+	c.Cache.Set(key, checksummed(resp))
+}
+
+// SetWithTTL saves resp with its checksum at key, passing ttl on to the
+// underlying cache if it supports it.
+func (c *ChecksumCache) SetWithTTL(key string, resp []byte, ttl time.Duration) {
+	if tc, ok := c.Cache.(TTLCache); ok {
+		tc.SetWithTTL(key, checksummed(resp), ttl)
+	} else {
+		c.Cache.Set(key, checksummed(resp))
+	}
+}
+
+// Delete removes the response stored at key.
+func (c *ChecksumCache) Delete(key string) {
+	c.Cache.Delete(key)
+}
+
+// SetContext saves resp with its checksum at key, with SetContext if the
+// underlying cache is a ContextCache.
+func (c *ChecksumCache) SetContext(ctx context.Context, key string, resp []byte, ttl time.Duration) error {
+	if cc, ok := c.Cache.(ContextCache); ok {
+		return cc.SetContext(ctx, key, checksummed(resp), ttl)
+	}
+	c.SetWithTTL(key, resp, ttl)
+	return nil
+}
+
+// Keys returns the keys of the underlying cache, or none if it isn't a
+// KeyLister.
+func (c *ChecksumCache) Keys() []string {
+	if lister, ok := c.Cache.(KeyLister); ok {
+		return lister.Keys()
+	}
+	return nil
+}
+
+// PurgeMatching removes the entries whose key matches f from the underlying
+// cache, if it is a Purger or a KeyLister, and returns how many were removed.
+func (c *ChecksumCache) PurgeMatching(f func(key string) bool) int {
+	if purger, ok := c.Cache.(Purger); ok {
+		return purger.PurgeMatching(f)
+	}
+	n := 0
+	for _, key := range c.Keys() {
+		if f(key) {
+			c.Cache.Delete(key)
+			n++
+		}
+	}
+	return n
+}
+
+// NotifyEvictions makes the underlying cache call f with the entries leaving
+// it, if it is an EvictionNotifier.
+func (c *ChecksumCache) NotifyEvictions(f func(Eviction)) {
+	notifier, ok := c.Cache.(EvictionNotifier)
+	if !ok {
+		return
+	}
+	if f == nil {
+		notifier.NotifyEvictions(nil)
+		return
+	}
+	notifier.NotifyEvictions(func(ev Eviction) {
+		if ev.Size >= checksumSize {
+			ev.Size -= checksumSize
+		}
+		f(ev)
+	})
+}
+
+// checksummed returns resp prefixed with its checksum.
+func checksummed(resp []byte) []byte {
+	b := make([]byte, checksumSize, checksumSize+len(resp))
+	copy(b, checksumMagic)
+	binary.BigEndian.PutUint32(b[len(checksumMagic):], crc32.Checksum(resp, castagnoli))
+	return append(b, resp...)
+}
+
+// unchecksummed returns the response b stored with its checksum, and whether
+// it is intact.
+func unchecksummed(b []byte) ([]byte, bool) {
+	if len(b) < checksumSize || !bytes.Equal(b[:len(checksumMagic)], checksumMagic) ||
+		binary.BigEndian.Uint32(b[len(checksumMagic):checksumSize]) != crc32.Checksum(b[checksumSize:], castagnoli) {
+		return nil, false
+	}
+	return b[checksumSize:], true
+}
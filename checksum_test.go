@@ -0,0 +1,68 @@
+package httpcache
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestChecksumCache(t *testing.T) {
+	underlying := NewMemoryCache(0)
+	c := NewChecksumCache(underlying)
+	var corrupt []string
+	c.OnCorrupt = func(key string) { corrupt = append(corrupt, key) }
+
+	c.Set("intact", []byte("some bytes"))
+	if b, ok := c.Get("intact"); !ok || !bytes.Equal(b, []byte("some bytes")) {
+		t.Fatalf("got %q, %v for an intact entry", b, ok)
+	}
+
+	c.Set("flipped", []byte("some bytes"))
+	b, _ := underlying.Get("flipped")
+	b = append([]byte(nil), b...)
+	b[len(b)-1] ^= 1
+	underlying.Set("flipped", b)
+	underlying.Set("legacy", []byte("some bytes"))
+	for _, key := range []string{"flipped", "legacy"} {
+		if _, ok := c.Get(key); ok {
+			t.Fatalf("corrupt entry %s reported as present", key)
+		}
+		if _, ok := underlying.Get(key); ok {
+			t.Fatalf("corrupt entry %s not deleted", key)
+		}
+	}
+	if len(corrupt) != 2 {
+		t.Fatalf("got corrupt entries %q", corrupt)
+	}
+}
+
+func TestChecksumCacheForwarding(t *testing.T) {
+	underlying := NewMemoryCache(1)
+	c := NewChecksumCache(underlying)
+	var evictions []Eviction
+	c.NotifyEvictions(func(ev Eviction) { evictions = append(evictions, ev) })
+
+	if err := c.SetContext(context.Background(), "a", []byte("some bytes"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if b, ok := c.Peek("a"); !ok || !bytes.Equal(b, []byte("some bytes")) {
+		t.Fatalf("peeked %q, %v", b, ok)
+	}
+	if b, ok, err := c.GetContext(context.Background(), "a"); err != nil || !ok || !bytes.Equal(b, []byte("some bytes")) {
+		t.Fatalf("got %q, %v, %v", b, ok, err)
+	}
+	if keys := c.Keys(); len(keys) != 1 || keys[0] != "a" {
+		t.Fatalf("got keys %q", keys)
+	}
+	c.Set("b", []byte("other bytes"))
+	if len(evictions) != 1 || evictions[0].Key != "a" || evictions[0].Size != len("some bytes") {
+		t.Fatalf("got evictions %+v", evictions)
+	}
+	if n := c.PurgeMatching(func(key string) bool { return key == "b" }); n != 1 {
+		t.Fatalf("purged %d entries, want 1", n)
+	}
+	if _, ok := c.Get("b"); ok {
+		t.Fatal("purged entry still present")
+	}
+}
@@ -0,0 +1,207 @@
+// Command httpcachectl inspects and manages the entries of an httpcache
+// backend, for operators debugging a production cache.
+//
+// Usage:
+//
+//	httpcachectl (-disk PATH | -redis URL) COMMAND [ARGS]
+//
+// The commands are:
+//
+//	list [PREFIX]   print the keys of the entries, optionally under PREFIX
+//	inspect KEY     print the status line and headers of the entry at KEY
+//	export KEY      write the entry at KEY, as stored, to stdout
+//	purge KEY...    delete the entries at the given keys
+//	purge -prefix P delete the entries whose key starts with P
+//	warm URL...     fetch the given URLs through the cache to populate it
+//	check [-dry-run] [-max-size N]
+//	                remove the unparseable, orphaned, useless or oversized
+//	                entries, reporting them
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/cozy/httpcache"
+	"github.com/cozy/httpcache/diskcache"
+	"github.com/cozy/httpcache/redis"
+	redigo "github.com/garyburd/redigo/redis"
+)
+
+func main() {
+	disk := flag.String("disk", "", "path of a disk cache")
+	redisURL := flag.String("redis", "", "URL of a redis cache, e.g. redis://localhost:6379/0")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: httpcachectl (-disk PATH | -redis URL) list|inspect|export|purge|warm|check [ARGS]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cache, err := openCache(*disk, *redisURL)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "httpcachectl:", err)
+		os.Exit(2)
+	}
+	if err := run(cache, flag.Arg(0), flag.Args()[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "httpcachectl:", err)
+		os.Exit(1)
+	}
+}
+
+// openCache opens the backend configured on the command line.
+func openCache(disk, redisURL string) (httpcache.Cache, error) {
+	switch {
+	case disk != "" && redisURL != "":
+		return nil, errors.New("-disk and -redis are mutually exclusive")
+	case disk != "":
+		return diskcache.New(disk), nil
+	case redisURL != "":
+		conn, err := redigo.DialURL(redisURL)
+		if err != nil {
+			return nil, err
+		}
+		return redis.NewWithClient(conn), nil
+	}
+	return nil, errors.New("one of -disk or -redis is required")
+}
+
+func run(cache httpcache.Cache, cmd string, args []string) error {
+	switch cmd {
+	case "list":
+		prefix := ""
+		if len(args) > 0 {
+			prefix = args[0]
+		}
+		keys, err := listKeys(cache, prefix)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			fmt.Println(key)
+		}
+	case "inspect":
+		if len(args) != 1 {
+			return errors.New("usage: inspect KEY")
+		}
+		b, ok := cache.Get(args[0])
+		if !ok {
+			return fmt.Errorf("no entry at %s", args[0])
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(b)), nil)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		fmt.Printf("%s %s\n", resp.Proto, resp.Status)
+		resp.Header.Write(os.Stdout)
+		fmt.Printf("\n(%d bytes stored)\n", len(b))
+	case "export":
+		if len(args) != 1 {
+			return errors.New("usage: export KEY")
+		}
+		b, ok := cache.Get(args[0])
+		if !ok {
+			return fmt.Errorf("no entry at %s", args[0])
+		}
+		_, err := os.Stdout.Write(b)
+		return err
+	case "purge":
+		fs := flag.NewFlagSet("purge", flag.ContinueOnError)
+		prefix := fs.String("prefix", "", "delete the entries whose key starts with this prefix")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		keys := fs.Args()
+		if *prefix != "" {
+			var err error
+			if keys, err = listKeys(cache, *prefix); err != nil {
+				return err
+			}
+		}
+		for _, key := range keys {
+			cache.Delete(key)
+		}
+		fmt.Fprintf(os.Stderr, "purged %d entries\n", len(keys))
+	case "warm":
+		client := httpcache.NewTransport(cache).Client()
+		for _, url := range args {
+			if err := warm(client, url); err != nil {
+				return err
+			}
+		}
+	case "check":
+		fs := flag.NewFlagSet("check", flag.ContinueOnError)
+		dryRun := fs.Bool("dry-run", false, "only report the problematic entries")
+		maxSize := fs.Int("max-size", 0, "maximum size of an entry, in bytes")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		report, err := httpcache.CheckConsistency(cache, httpcache.CheckOptions{DryRun: *dryRun, MaxEntrySize: *maxSize})
+		if err != nil {
+			return err
+		}
+		for _, list := range []struct {
+			problem string
+			keys    []string
+		}{
+			{"unparseable", report.Unparseable},
+			{"orphaned", report.Orphaned},
+			{"useless", report.Useless},
+			{"oversized", report.Oversized},
+		} {
+			for _, key := range list.keys {
+				fmt.Printf("%s\t%s\n", list.problem, key)
+			}
+		}
+		fmt.Fprintln(os.Stderr, report)
+	default:
+		return fmt.Errorf("unknown command %q", cmd)
+	}
+	return nil
+}
+
+// listKeys returns the keys of the entries of cache that start with prefix.
+func listKeys(cache httpcache.Cache, prefix string) ([]string, error) {
+	lister, ok := cache.(httpcache.KeyLister)
+	if !ok {
+		return nil, errors.New("this backend can't list its keys")
+	}
+	var keys []string
+	for _, key := range lister.Keys() {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// warm fetches url through client, reading the whole body so that the
+// response gets stored, and reports how it was served.
+func warm(client *http.Client, url string) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if _, err := io.Copy(ioutil.Discard, resp.Body); err != nil {
+		return err
+	}
+	from := resp.Header.Get(httpcache.XFromCache)
+	if from == "" {
+		from = "origin"
+	}
+	fmt.Printf("%s %s (%s)\n", url, resp.Status, from)
+	return nil
+}
@@ -0,0 +1,174 @@
+package httpcache
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// A flight is a request sent to the origin server by a Proxy, whose response
+// is shared with the identical requests received in the meantime.
+type flight struct {
+	ready  chan struct{} // closed once resp, err and shared are set
+	header http.Header   // of the request sent
+	resp   *http.Response
+	err    error
+	shared bool // whether resp can be served to the other requests
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	body    []byte // body of resp read so far
+	done    bool   // whether the body has been read to the end
+	bodyErr error
+}
+
+func newFlight() *flight {
+	f := &flight{ready: make(chan struct{})}
+	f.cond = sync.NewCond(&f.mu)
+	return f
+}
+
+// fill reads body, the one of f.resp, for the readers of f. It closes body
+// and calls end before the readers reach its end, so that the response is
+// cached by then.
+func (f *flight) fill(body io.ReadCloser, end func()) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := body.Read(buf)
+		if err != nil {
+			body.Close()
+			end()
+		}
+		f.mu.Lock()
+		f.body = append(f.body, buf[:n]...)
+		if err != nil {
+			f.done = true
+			if err != io.EOF {
+				f.bodyErr = err
+			}
+		}
+		f.cond.Broadcast()
+		f.mu.Unlock()
+		if err != nil {
+			break
+		}
+	}
+}
+
+// response returns a copy of f.resp, whose body is streamed as it is
+// received.
+func (f *flight) response() *http.Response {
+	resp := *f.resp
+	resp.Header = f.resp.Header.Clone()
+	resp.Body = &flightReader{f: f}
+	return &resp
+}
+
+// flightReader reads the body of the response of a flight.
+type flightReader struct {
+	f   *flight
+	off int
+}
+
+func (r *flightReader) Read(p []byte) (int, error) {
+	f := r.f
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for r.off == len(f.body) && !f.done {
+		f.cond.Wait()
+	}
+	if r.off < len(f.body) {
+		n := copy(p, f.body[r.off:])
+		r.off += n
+		return n, nil
+	}
+	if f.bodyErr != nil {
+		return 0, f.bodyErr
+	}
+	return 0, io.EOF
+}
+
+func (r *flightReader) Close() error {
+	return nil
+}
+
+// collapsible reports whether req can share the response of an identical
+// request, in a shared cache if shared is true, where the responses to the
+// requests with cookies are likely personalized.
+func collapsible(req *http.Request, shared bool) bool {
+	return req.Method == http.MethodGet && req.Header.Get("Range") == "" && req.Header.Get("Authorization") == "" &&
+		!(shared && req.Header.Get("Cookie") != "")
+}
+
+// varyMatches reports whether resp, the response to a request with the
+// headers sent, can be served to req according to its Vary header.
+func varyMatches(resp *http.Response, sent http.Header, req *http.Request) bool {
+	for _, name := range varyNames(resp.Header) {
+		if name == "*" || strings.Join(sent.Values(name), ", ") != strings.Join(req.Header.Values(name), ", ") {
+			return false
+		}
+	}
+	return true
+}
+
+// roundTrip sends req through p.Transport, unless an identical request is
+// already in flight, in which case it waits for its response and shares
+// it, if it can be cached. The body of the shared response is streamed to
+// all the requests as it is received.
+func (p *Proxy) roundTrip(req *http.Request) (*http.Response, error) {
+	if !collapsible(req, p.Transport.Shared) {
+		return p.Transport.RoundTrip(req)
+	}
+	key := cacheKey(req)
+	p.mu.Lock()
+	if f, ok := p.flights[key]; ok {
+		p.mu.Unlock()
+		select {
+		case <-f.ready:
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+		if f.err != nil {
+			return nil, f.err
+		}
+		if !f.shared || !varyMatches(f.resp, f.header, req) {
+			return p.Transport.RoundTrip(req)
+		}
+		return f.response(), nil
+	}
+	f := newFlight()
+	f.header = req.Header.Clone()
+	if p.flights == nil {
+		p.flights = make(map[string]*flight)
+	}
+	p.flights[key] = f
+	p.mu.Unlock()
+
+	// The response is shared, so the request must not be canceled with the
+	// one of the client
+	resp, err := p.Transport.RoundTrip(req.WithContext(context.WithoutCancel(req.Context())))
+	f.err = err
+	if err == nil {
+		f.shared = resp.Header.Get(p.Transport.markerHeader()) != "" ||
+			p.Transport.policy(req.URL).cannotStoreReason(req, resp, p.Transport.now()) == ""
+	}
+	if !f.shared {
+		p.land(key)
+		close(f.ready)
+		return resp, err
+	}
+	f.resp = resp
+	close(f.ready)
+	go f.fill(resp.Body, func() { p.land(key) })
+	return f.response(), nil
+}
+
+// land forgets the flight of the requests at key, once its response is
+// cached or can't be shared.
+func (p *Proxy) land(key string) {
+	p.mu.Lock()
+	delete(p.flights, key)
+	p.mu.Unlock()
+}
@@ -0,0 +1,149 @@
+// Package conditional builds and evaluates the conditional headers of HTTP
+// requests, as specified by RFC 9110, section 13. The httpcache Transport
+// uses it to revalidate its cached responses, and servers can use it to
+// answer such requests with the same logic.
+package conditional
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ParseETags parses a list of entity tags, such as the value of an
+// If-None-Match or If-Match header. The wildcard is returned as "*". It
+// returns nil if the list is malformed.
+func ParseETags(value string) []string {
+	value = strings.TrimSpace(value)
+	if value == "*" {
+		return []string{"*"}
+	}
+	var etags []string
+	for value != "" {
+		etag, rest, ok := scanETag(value)
+		if !ok {
+			return nil
+		}
+		etags = append(etags, etag)
+		rest = strings.TrimLeft(rest, " \t")
+		if rest != "" && rest[0] != ',' {
+			return nil
+		}
+		value = strings.TrimLeft(rest, " \t,")
+	}
+	return etags
+}
+
+// scanETag returns the entity tag at the start of s, and what follows it.
+func scanETag(s string) (etag, rest string, ok bool) {
+	start := 0
+	if strings.HasPrefix(s, "W/") {
+		start = 2
+	}
+	if len(s) < start+2 || s[start] != '"' {
+		return "", "", false
+	}
+	end := strings.IndexByte(s[start+1:], '"')
+	if end < 0 {
+		return "", "", false
+	}
+	end += start + 2
+	return s[:end], s[end:], true
+}
+
+// isWeak reports whether etag has the weakness indicator.
+func isWeak(etag string) bool {
+	return strings.HasPrefix(etag, "W/")
+}
+
+// WeakMatch reports whether the entity tags a and b match with the weak
+// comparison: they are equal, ignoring their weakness indicators.
+func WeakMatch(a, b string) bool {
+	return a != "" && strings.TrimPrefix(a, "W/") == strings.TrimPrefix(b, "W/")
+}
+
+// StrongMatch reports whether the entity tags a and b match with the strong
+// comparison: they are equal, and not weak.
+func StrongMatch(a, b string) bool {
+	return a != "" && a == b && !isWeak(a)
+}
+
+// NoneMatch reports whether the condition of an If-None-Match header with
+// the value ifNoneMatch is true for a representation with the entity tag
+// etag, which may be empty: none of its entity tags match etag with the weak
+// comparison.
+func NoneMatch(ifNoneMatch, etag string) bool {
+	for _, candidate := range ParseETags(ifNoneMatch) {
+		if candidate == "*" && etag != "" || WeakMatch(candidate, etag) {
+			return false
+		}
+	}
+	return true
+}
+
+// ModifiedSince reports whether the condition of an If-Modified-Since header
+// with the value ifModifiedSince is true for a representation last modified
+// at lastModified. It is true for invalid dates, and unknown modification
+// times.
+func ModifiedSince(ifModifiedSince string, lastModified time.Time) bool {
+	since, err := http.ParseTime(ifModifiedSince)
+	if err != nil || lastModified.IsZero() {
+		return true
+	}
+	return lastModified.Truncate(time.Second).After(since)
+}
+
+// IfRange reports whether the range requested with an If-Range header with
+// the value ifRange applies to a representation with the entity tag etag and
+// last modified at lastModified: the entity tag matches with the strong
+// comparison, or the date is exactly the modification time.
+func IfRange(ifRange, etag string, lastModified time.Time) bool {
+	ifRange = strings.TrimSpace(ifRange)
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, "W/") {
+		return StrongMatch(ifRange, etag)
+	}
+	date, err := http.ParseTime(ifRange)
+	return err == nil && !lastModified.IsZero() && lastModified.Truncate(time.Second).Equal(date)
+}
+
+// NotModified reports whether req can be answered with 304 Not Modified for
+// a representation with the headers h, holding its ETag and Last-Modified
+// validators. If-None-Match takes precedence over If-Modified-Since, which
+// only applies to GET and HEAD requests.
+func NotModified(req *http.Request, h http.Header) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+	if ifNoneMatch := req.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		return !NoneMatch(ifNoneMatch, h.Get("ETag"))
+	}
+	ifModifiedSince := req.Header.Get("If-Modified-Since")
+	if ifModifiedSince == "" {
+		return false
+	}
+	lastModified, err := http.ParseTime(h.Get("Last-Modified"))
+	if err != nil {
+		return false
+	}
+	return !ModifiedSince(ifModifiedSince, lastModified)
+}
+
+// AddValidators sets in reqHeader the conditional headers validating a
+// cached response with the headers cached: If-None-Match with its ETag, and
+// If-Modified-Since with its Last-Modified. It reports whether the response
+// has any validator.
+func AddValidators(reqHeader, cached http.Header) bool {
+	added := false
+	if etag := cached.Get("ETag"); etag != "" {
+		reqHeader.Set("If-None-Match", etag)
+		added = true
+	}
+	if lastModified := cached.Get("Last-Modified"); lastModified != "" {
+		reqHeader.Set("If-Modified-Since", lastModified)
+		added = true
+	}
+	return added
+}
@@ -0,0 +1,88 @@
+package conditional
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseETags(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{`"a"`, []string{`"a"`}},
+		{` "a", W/"b,c" ,"d"`, []string{`"a"`, `W/"b,c"`, `"d"`}},
+		{`*`, []string{"*"}},
+		{`a`, nil},
+		{`"a" "b"`, nil},
+		{``, nil},
+	}
+	for _, test := range tests {
+		got := ParseETags(test.value)
+		if len(got) != len(test.want) {
+			t.Fatalf("%q: got %q, want %q", test.value, got, test.want)
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Fatalf("%q: got %q, want %q", test.value, got, test.want)
+			}
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	if !WeakMatch(`W/"1"`, `"1"`) || WeakMatch(`"1"`, `"2"`) {
+		t.Fatal("weak comparison failed")
+	}
+	if StrongMatch(`W/"1"`, `W/"1"`) || !StrongMatch(`"1"`, `"1"`) {
+		t.Fatal("strong comparison failed")
+	}
+	if NoneMatch(`"0", W/"1"`, `"1"`) || !NoneMatch(`"0"`, `"1"`) || NoneMatch(`*`, `"1"`) || !NoneMatch(`*`, "") {
+		t.Fatal("If-None-Match evaluation failed")
+	}
+}
+
+func TestDates(t *testing.T) {
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 500, time.UTC)
+	date := modified.Format(http.TimeFormat)
+	if ModifiedSince(date, modified) || !ModifiedSince(date, modified.Add(time.Second)) || !ModifiedSince("invalid", modified) {
+		t.Fatal("If-Modified-Since evaluation failed")
+	}
+	if !IfRange(date, `"1"`, modified) || IfRange(date, `"1"`, modified.Add(time.Second)) {
+		t.Fatal("If-Range date evaluation failed")
+	}
+	if !IfRange(`"1"`, `"1"`, time.Time{}) || IfRange(`W/"1"`, `W/"1"`, time.Time{}) {
+		t.Fatal("If-Range entity tag evaluation failed")
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	h := http.Header{"Etag": {`"1"`}, "Last-Modified": {"Wed, 01 May 2024 12:00:00 GMT"}}
+	tests := []struct {
+		method string
+		header http.Header
+		want   bool
+	}{
+		{http.MethodGet, http.Header{"If-None-Match": {`W/"1"`}}, true},
+		{http.MethodGet, http.Header{"If-None-Match": {`"2"`}, "If-Modified-Since": {"Wed, 01 May 2024 12:00:00 GMT"}}, false},
+		{http.MethodHead, http.Header{"If-Modified-Since": {"Wed, 01 May 2024 12:00:00 GMT"}}, true},
+		{http.MethodGet, http.Header{"If-Modified-Since": {"Tue, 30 Apr 2024 12:00:00 GMT"}}, false},
+		{http.MethodPost, http.Header{"If-None-Match": {`"1"`}}, false},
+		{http.MethodGet, http.Header{}, false},
+	}
+	for _, test := range tests {
+		req := &http.Request{Method: test.method, Header: test.header}
+		if got := NotModified(req, h); got != test.want {
+			t.Errorf("%s %v: got %v, want %v", test.method, test.header, got, test.want)
+		}
+	}
+
+	reqHeader := http.Header{}
+	if !AddValidators(reqHeader, h) || reqHeader.Get("If-None-Match") != `"1"` || reqHeader.Get("If-Modified-Since") != h.Get("Last-Modified") {
+		t.Fatalf("got validators %v", reqHeader)
+	}
+	if AddValidators(http.Header{}, http.Header{}) {
+		t.Fatal("validators added without any")
+	}
+}
@@ -7,18 +7,44 @@ import (
 	"bytes"
 	"crypto/md5"
 	"encoding/hex"
+	"encoding/json"
 	"github.com/peterbourgon/diskv"
 	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
+// metaSuffix is appended to the filename of an entry to name the file holding
+// its metadata. It can't collide with a filename, which is hex encoded.
+const metaSuffix = ".meta"
+
 // Cache is an implementation of httpcache.Cache that supplements the in-memory map with persistent storage
 type Cache struct {
 	d *diskv.Diskv
+
+	// SecureDelete, if true, overwrites the files of the entries with zeros
+	// before they are removed or replaced, for the environments with
+	// data-at-rest deletion requirements. It doesn't reach the copies the
+	// filesystem or the device may keep, e.g. on journaling filesystems and
+	// SSDs.
+	SecureDelete bool
+}
+
+// meta is the metadata stored alongside an entry.
+type meta struct {
+	Key     string    `json:"key,omitempty"`
+	Expires time.Time `json:"expires,omitempty"`
 }
 
 // Get returns the response corresponding to key if present
 func (c *Cache) Get(key string) (resp []byte, ok bool) {
 	key = keyToFilename(key)
+	if c.expired(key, time.Now()) {
+		c.erase(key)
+		return []byte{}, false
+	}
 	resp, err := c.d.Read(key)
 	if err != nil {
 		return []byte{}, false
@@ -28,14 +54,155 @@ func (c *Cache) Get(key string) (resp []byte, ok bool) {
 
 // Set saves a response to the cache as key
 func (c *Cache) Set(key string, resp []byte) {
-	key = keyToFilename(key)
-	c.d.WriteStream(key, bytes.NewReader(resp), true)
+	c.write(key, resp, meta{Key: key})
+}
+
+// SetWithTTL saves a response to the cache as key, and expires it after ttl
+// if it is not zero
+func (c *Cache) SetWithTTL(key string, resp []byte, ttl time.Duration) {
+	m := meta{Key: key}
+	if ttl > 0 {
+		m.Expires = time.Now().Add(ttl)
+	}
+	c.write(key, resp, m)
+}
+
+// write saves resp under the filename of key, with its metadata m.
+func (c *Cache) write(key string, resp []byte, m meta) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return
+	}
+	filename := keyToFilename(key)
+	if c.SecureDelete {
+		c.overwrite(filename)
+	}
+	c.d.WriteStream(filename, bytes.NewReader(resp), true)
+	c.d.WriteStream(filename+metaSuffix, bytes.NewReader(b), true)
 }
 
 // Delete removes the response with key from the cache
 func (c *Cache) Delete(key string) {
-	key = keyToFilename(key)
+	c.erase(keyToFilename(key))
+}
+
+// RemoveExpired removes at most max expired responses from the cache, or all
+// of them if max is zero, and returns how many were removed
+func (c *Cache) RemoveExpired(max int) int {
+	cancel := make(chan struct{})
+	defer close(cancel)
+	now := time.Now()
+	n := 0
+	for name := range c.d.Keys(cancel) {
+		if !strings.HasSuffix(name, metaSuffix) {
+			continue
+		}
+		key := strings.TrimSuffix(name, metaSuffix)
+		if c.expired(key, now) {
+			c.erase(key)
+			n++
+			if max != 0 && n >= max {
+				break
+			}
+		}
+	}
+	return n
+}
+
+// Keys returns the keys of the responses in the cache that haven't expired.
+// Responses stored before keys were recorded alongside them are not listed.
+func (c *Cache) Keys() []string {
+	cancel := make(chan struct{})
+	defer close(cancel)
+	now := time.Now()
+	var keys []string
+	for name := range c.d.Keys(cancel) {
+		if !strings.HasSuffix(name, metaSuffix) {
+			continue
+		}
+		m, ok := c.meta(strings.TrimSuffix(name, metaSuffix))
+		if !ok || m.Key == "" || (!m.Expires.IsZero() && !now.Before(m.Expires)) {
+			continue
+		}
+		keys = append(keys, m.Key)
+	}
+	return keys
+}
+
+// PurgeMatching removes the responses whose key matches f, walking the
+// cache directory once, and returns how many were removed. Responses stored
+// before keys were recorded alongside them are not considered.
+func (c *Cache) PurgeMatching(f func(key string) bool) int {
+	cancel := make(chan struct{})
+	defer close(cancel)
+	var matching []string
+	for name := range c.d.Keys(cancel) {
+		if !strings.HasSuffix(name, metaSuffix) {
+			continue
+		}
+		key := strings.TrimSuffix(name, metaSuffix)
+		if m, ok := c.meta(key); ok && m.Key != "" && f(m.Key) {
+			matching = append(matching, key)
+		}
+	}
+	for _, key := range matching {
+		c.erase(key)
+	}
+	return len(matching)
+}
+
+// meta returns the metadata of the entry stored under filename key.
+func (c *Cache) meta(key string) (m meta, ok bool) {
+	b, err := c.d.Read(key + metaSuffix)
+	if err != nil {
+		return m, false
+	}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return m, false
+	}
+	return m, true
+}
+
+// expired reports whether the entry stored under filename key has expired.
+func (c *Cache) expired(key string, now time.Time) bool {
+	m, ok := c.meta(key)
+	if !ok {
+		return false
+	}
+	return !m.Expires.IsZero() && !now.Before(m.Expires)
+}
+
+func (c *Cache) erase(key string) {
+	if c.SecureDelete {
+		c.overwrite(key)
+		c.overwrite(key + metaSuffix)
+	}
 	c.d.Erase(key)
+	c.d.Erase(key + metaSuffix)
+}
+
+// overwrite fills the file stored under filename key with zeros, and syncs it
+// to the disk.
+func (c *Cache) overwrite(key string) {
+	f, err := os.OpenFile(filepath.Join(c.d.BasePath, filepath.Join(c.d.Transform(key)...), key), os.O_WRONLY, 0)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return
+	}
+	zeros := make([]byte, 32*1024)
+	for remaining := info.Size(); remaining > 0; remaining -= int64(len(zeros)) {
+		if remaining < int64(len(zeros)) {
+			zeros = zeros[:remaining]
+		}
+		if _, err := f.Write(zeros); err != nil {
+			return
+		}
+	}
+	f.Sync()
 }
 
 func keyToFilename(key string) string {
@@ -57,5 +224,5 @@ func New(basePath string) *Cache {
 // NewWithDiskv returns a new Cache using the provided Diskv as underlying
 // storage.
 func NewWithDiskv(d *diskv.Diskv) *Cache {
-	return &Cache{d}
+	return &Cache{d: d}
 }
@@ -4,7 +4,11 @@ import (
 	"bytes"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestDiskCache(t *testing.T) {
@@ -40,3 +44,105 @@ func TestDiskCache(t *testing.T) {
 		t.Fatal("deleted key still present")
 	}
 }
+
+func TestDiskCacheExpiry(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "httpcache")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	cache := New(tempDir)
+	cache.SetWithTTL("expiring", []byte("some bytes"), time.Second)
+	cache.SetWithTTL("lasting", []byte("some bytes"), time.Hour)
+	if _, ok := cache.Get("expiring"); !ok {
+		t.Fatal("entry expired too early")
+	}
+
+	backdate(cache, "expiring")
+	if n := cache.RemoveExpired(0); n != 1 {
+		t.Fatalf("removed %d entries, want 1", n)
+	}
+	if _, ok := cache.Get("expiring"); ok {
+		t.Fatal("expired entry still present")
+	}
+	if _, ok := cache.Get("lasting"); !ok {
+		t.Fatal("could not retrieve an entry that isn't expired")
+	}
+}
+
+// backdate makes the entry at key expire a second ago.
+func backdate(c *Cache, key string) {
+	resp, _ := c.d.Read(keyToFilename(key))
+	c.write(key, resp, meta{Key: key, Expires: time.Now().Add(-time.Second)})
+}
+
+func TestDiskCacheKeys(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "httpcache")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	cache := New(tempDir)
+	cache.Set("http://example.com/a", []byte("a"))
+	cache.SetWithTTL("http://example.com/b", []byte("b"), time.Hour)
+	cache.SetWithTTL("http://example.com/c", []byte("c"), time.Second)
+	backdate(cache, "http://example.com/c")
+
+	keys := cache.Keys()
+	sort.Strings(keys)
+	if len(keys) != 2 || keys[0] != "http://example.com/a" || keys[1] != "http://example.com/b" {
+		t.Fatalf("got keys %q", keys)
+	}
+}
+
+func TestDiskCachePurgeMatching(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "httpcache")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	cache := New(tempDir)
+	cache.Set("http://example.com/a/1", []byte("1"))
+	cache.Set("http://example.com/a/2", []byte("2"))
+	cache.Set("http://example.com/b", []byte("3"))
+	if n := cache.PurgeMatching(func(key string) bool { return strings.HasPrefix(key, "http://example.com/a/") }); n != 2 {
+		t.Fatalf("purged %d entries, want 2", n)
+	}
+	if keys := cache.Keys(); len(keys) != 1 || keys[0] != "http://example.com/b" {
+		t.Fatalf("got keys %q", keys)
+	}
+}
+
+func TestDiskCacheSecureDelete(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "httpcache")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	cache := New(tempDir)
+	cache.SecureDelete = true
+	val := []byte("some secret bytes")
+	cache.Set("key", val)
+
+	// The file stays readable through f once unlinked
+	f, err := os.Open(filepath.Join(tempDir, keyToFilename("key")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	cache.Delete("key")
+	if _, ok := cache.Get("key"); ok {
+		t.Fatal("deleted key still present")
+	}
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, make([]byte, len(val))) {
+		t.Fatalf("got %q in the deleted file, want zeros", b)
+	}
+}
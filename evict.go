@@ -0,0 +1,232 @@
+package httpcache
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cozy/httpcache/lru"
+)
+
+// An EvictionReason tells why an entry left a cache.
+type EvictionReason string
+
+const (
+	// EvictLRU means the entry was the least recently used one of a full
+	// cache
+	EvictLRU EvictionReason = "lru"
+	// EvictTTL means the entry expired, or was idle for too long
+	EvictTTL EvictionReason = "ttl"
+	// EvictQuota means the entry was evicted to keep an instance within its
+	// quotas, see InstanceTransport
+	EvictQuota EvictionReason = "quota"
+	// EvictPurge means the entry was deleted explicitly, by a purge, an
+	// invalidation or a response that can't be stored
+	EvictPurge EvictionReason = "purge"
+)
+
+// An Eviction reports an entry that left a cache.
+type Eviction struct {
+	Key    string
+	Reason EvictionReason
+	// Size is the size in bytes of the entry
+	Size int
+}
+
+// An EvictionNotifier is a Cache that reports the entries leaving it, see
+// Transport.SubscribeEvictions.
+type EvictionNotifier interface {
+	Cache
+	// NotifyEvictions makes the cache call f with the entries leaving it,
+	// replacing the previous function, or stop reporting them if f is nil.
+	// f may be called with the cache locked, so it mustn't use the cache.
+	//
+	// The Transports using the cache, such as the clones of a Transport,
+	// register a single function with it, that reports the entries leaving
+	// it to all of them: use Transport.SubscribeEvictions to be notified
+	// too. The cache must be comparable, e.g. a pointer, to be shared.
+	NotifyEvictions(f func(Eviction))
+}
+
+// lruReasons maps the reasons of the removals from an lru.Cache to those of
+// the evictions.
+var lruReasons = map[lru.Reason]EvictionReason{
+	lru.Evicted: EvictLRU,
+	lru.Expired: EvictTTL,
+	lru.Removed: EvictPurge,
+}
+
+// NotifyEvictions makes c call f with the entries leaving it.
+func (c *MemoryCache) NotifyEvictions(f func(Eviction)) {
+	if f == nil {
+		c.items.SetOnRemoved(nil)
+		return
+	}
+	c.items.SetOnRemoved(func(key lru.Key, value lru.Value, reason lru.Reason) {
+		f(Eviction{Key: string(key), Reason: lruReasons[reason], Size: len(value)})
+	})
+}
+
+// SubscribeEvictions calls f with the entries leaving the cache of t, why and
+// their size, until stop is called, so that the operators can tell why the
+// hit rate drops, e.g. after a deploy. It requires the Cache of t to be an
+// EvictionNotifier. f is called synchronously by the cache, so it must be
+// quick and mustn't use the cache.
+func (t *Transport) SubscribeEvictions(f func(Eviction)) (stop func(), err error) {
+	if evictionNotifier(t.cache()) == nil {
+		return nil, errors.New("httpcache: cache can't report its evictions")
+	}
+	t.watchEvictions()
+	st := t.state()
+	st.evictionMu.Lock()
+	id := st.nextEvictionSub
+	st.nextEvictionSub++
+	st.updateEvictionSubs(func(subs []evictionSub) []evictionSub {
+		return append(subs, evictionSub{id: id, f: f})
+	})
+	st.evictionMu.Unlock()
+	return func() {
+		st.evictionMu.Lock()
+		st.updateEvictionSubs(func(subs []evictionSub) []evictionSub {
+			kept := subs[:0]
+			for _, sub := range subs {
+				if sub.id != id {
+					kept = append(kept, sub)
+				}
+			}
+			return kept
+		})
+		st.evictionMu.Unlock()
+	}, nil
+}
+
+// An evictionSub is a subscription to the evictions of a cache.
+type evictionSub struct {
+	id int
+	f  func(Eviction)
+}
+
+// updateEvictionSubs replaces the subscriptions to the evictions with those
+// returned by f from a copy of the current ones. st.evictionMu must be held.
+func (st *transportState) updateEvictionSubs(f func(subs []evictionSub) []evictionSub) {
+	var subs []evictionSub
+	if current := st.evictionSubs.Load(); current != nil {
+		subs = append(subs, *current...)
+	}
+	subs = f(subs)
+	st.evictionSubs.Store(&subs)
+}
+
+// evictionNotifier returns c if it is an EvictionNotifier that can be
+// shared by the Transports, and nil otherwise.
+func evictionNotifier(c Cache) EvictionNotifier {
+	notifier, ok := c.(EvictionNotifier)
+	if !ok || !reflect.TypeOf(c).Comparable() {
+		return nil
+	}
+	return notifier
+}
+
+// watchEvictions registers t to its cache, if it is an EvictionNotifier, and
+// unregisters it from the cache it replaced if any. The cache reports its
+// evictions with its lock held, and evicted takes st.mu: it must not be
+// called with st.mu held.
+func (t *Transport) watchEvictions() {
+	notifier := evictionNotifier(t.cache())
+	st := t.state()
+	st.evictionMu.Lock()
+	defer st.evictionMu.Unlock()
+	if st.watched == notifier {
+		return
+	}
+	if st.watched != nil {
+		evictionWatches.remove(st.watched, t)
+	}
+	st.watched = notifier
+	if notifier != nil {
+		evictionWatches.add(notifier, t)
+	}
+}
+
+// evictionWatches fans out the evictions reported by the caches to the
+// Transports using them, as a cache has a single NotifyEvictions function.
+var evictionWatches = evictionRegistry{byCache: make(map[EvictionNotifier]*evictionWatch)}
+
+type evictionRegistry struct {
+	mu      sync.Mutex
+	byCache map[EvictionNotifier]*evictionWatch
+}
+
+// An evictionWatch holds the Transports registered to a cache.
+type evictionWatch struct {
+	transports atomic.Pointer[[]*Transport] // copied on write, under mu
+}
+
+// add registers t to c, registering the registry to c first if needed.
+func (r *evictionRegistry) add(c EvictionNotifier, t *Transport) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	w := r.byCache[c]
+	if w == nil {
+		w = &evictionWatch{}
+		r.byCache[c] = w
+		c.NotifyEvictions(w.evicted)
+	}
+	var transports []*Transport
+	if current := w.transports.Load(); current != nil {
+		transports = append(transports, *current...)
+	}
+	transports = append(transports, t)
+	w.transports.Store(&transports)
+}
+
+// remove unregisters t from c, and the registry from c if t was the last
+// Transport registered.
+func (r *evictionRegistry) remove(c EvictionNotifier, t *Transport) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	w := r.byCache[c]
+	if w == nil {
+		return
+	}
+	var transports []*Transport
+	for _, t2 := range *w.transports.Load() {
+		if t2 != t {
+			transports = append(transports, t2)
+		}
+	}
+	if len(transports) == 0 {
+		delete(r.byCache, c)
+		c.NotifyEvictions(nil)
+	}
+	w.transports.Store(&transports)
+}
+
+// evicted reports ev to the Transports registered to the cache of w.
+func (w *evictionWatch) evicted(ev Eviction) {
+	transports := w.transports.Load()
+	if transports == nil {
+		return
+	}
+	for _, t := range *transports {
+		t.evicted(ev)
+	}
+}
+
+// evicted forgets how the entry of ev was served and its tags, and reports ev
+// to the subscribers of t.
+func (t *Transport) evicted(ev Eviction) {
+	st := t.state()
+	st.mu.Lock()
+	delete(st.access, ev.Key)
+	st.unindexTags(ev.Key)
+	st.mu.Unlock()
+	subs := st.evictionSubs.Load()
+	if subs == nil {
+		return
+	}
+	for _, sub := range *subs {
+		sub.f(ev)
+	}
+}
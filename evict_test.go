@@ -0,0 +1,170 @@
+package httpcache
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestSubscribeEvictions(t *testing.T) {
+	resetTest()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=3600")
+		io.WriteString(w, r.URL.Path)
+	}))
+	defer server.Close()
+
+	tp := NewMemoryCacheTransport(1)
+	var mu sync.Mutex
+	var evictions []Eviction
+	stop, err := tp.SubscribeEvictions(func(ev Eviction) {
+		mu.Lock()
+		evictions = append(evictions, ev)
+		mu.Unlock()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(path string) {
+		resp, err := tp.Client().Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	get("/a")
+	get("/b")
+	if err := tp.InvalidateURL(server.URL + "/b"); err != nil {
+		t.Fatal(err)
+	}
+	stop()
+	get("/c")
+	get("/d")
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(evictions) != 2 {
+		t.Fatalf("got evictions %v, want 2", evictions)
+	}
+	if ev := evictions[0]; ev.Key != server.URL+"/a" || ev.Reason != EvictLRU || ev.Size == 0 {
+		t.Errorf("got %+v, want /a evicted as least recently used", ev)
+	}
+	if ev := evictions[1]; ev.Key != server.URL+"/b" || ev.Reason != EvictPurge {
+		t.Errorf("got %+v, want /b purged", ev)
+	}
+
+	if _, err := NewTransport(listingCache{NewMemoryCache(0)}).SubscribeEvictions(func(Eviction) {}); err == nil {
+		t.Error("subscribed to a cache that can't report its evictions")
+	}
+}
+
+func TestSubscribeEvictionsConcurrently(t *testing.T) {
+	resetTest()
+	c := NewMemoryCache(10)
+	tp := NewTransport(c)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			c.Set(strconv.Itoa(i), []byte("x"))
+		}
+	}()
+	for i := 0; i < 1000; i++ {
+		stop, err := tp.SubscribeEvictions(func(Eviction) {})
+		if err != nil {
+			t.Fatal(err)
+		}
+		stop()
+	}
+	close(done)
+	wg.Wait()
+}
+
+func TestEvictionsForgotten(t *testing.T) {
+	resetTest()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Header().Set("Surrogate-Key", r.URL.Path)
+		io.WriteString(w, r.URL.Path)
+	}))
+	defer server.Close()
+
+	tp := NewMemoryCacheTransport(1)
+	for _, path := range []string{"/a", "/a", "/b", "/b"} {
+		resp, err := tp.Client().Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	if keys := tp.HotKeys(0); len(keys) != 1 || keys[0].Key != server.URL+"/b" {
+		t.Fatalf("got hot keys %+v, want only the cached one", keys)
+	}
+	if keys := tp.taggedKeys("/a"); len(keys) != 0 {
+		t.Fatalf("got keys %q tagged with the evicted response", keys)
+	}
+	if keys := tp.taggedKeys("/b"); len(keys) != 1 {
+		t.Fatalf("got keys %q tagged with the cached response, want 1", keys)
+	}
+}
+
+func TestEvictionsShared(t *testing.T) {
+	resetTest()
+	subscribe := func(tp *Transport) func() []string {
+		var mu sync.Mutex
+		var keys []string
+		if _, err := tp.SubscribeEvictions(func(ev Eviction) {
+			mu.Lock()
+			keys = append(keys, ev.Key)
+			mu.Unlock()
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return func() []string {
+			mu.Lock()
+			defer mu.Unlock()
+			return append([]string(nil), keys...)
+		}
+	}
+
+	c := NewMemoryCache(1)
+	tp := NewTransport(c)
+	evicted := subscribe(tp)
+	// The clone shares the cache, and is notified without stealing the
+	// evictions of tp
+	evictedByClone := subscribe(tp.Clone())
+	c.Set("a", []byte("x"))
+	c.Set("b", []byte("x"))
+	if keys := evicted(); len(keys) != 1 || keys[0] != "a" {
+		t.Fatalf("got evictions %q, want a", keys)
+	}
+	if keys := evictedByClone(); len(keys) != 1 || keys[0] != "a" {
+		t.Fatalf("got evictions %q for the clone, want a", keys)
+	}
+
+	// The subscriptions follow the new cache
+	c2 := NewMemoryCache(1)
+	tp.SetCache(c2)
+	c2.Set("c", []byte("x"))
+	c2.Set("d", []byte("x"))
+	c.Set("e", []byte("x"))
+	if keys := evicted(); len(keys) != 2 || keys[1] != "c" {
+		t.Fatalf("got evictions %q after replacing the cache, want a and c", keys)
+	}
+	if keys := evictedByClone(); len(keys) != 2 || keys[1] != "b" {
+		t.Fatalf("got evictions %q for the clone, want a and b", keys)
+	}
+}
@@ -0,0 +1,83 @@
+package httpcache
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// A Decision explains how a Transport would handle a request, see Explain.
+type Decision struct {
+	// Key is the cache key of the request
+	Key string `json:"key"`
+	// Action is what the Transport would do: "hit" to serve the cached
+	// response, "revalidate" to validate it with the server first, "miss"
+	// to fetch a new response, or "bypass" not to use the cache at all
+	Action string `json:"action"`
+	// Found reports whether a response is cached for the request
+	Found bool `json:"found"`
+	// Freshness is "fresh", "stale" or "transparent" for a cached response
+	Freshness string `json:"freshness,omitempty"`
+	// Age is the current age of the cached response, and Lifetime its
+	// freshness lifetime, both zero if unknown
+	Age      time.Duration `json:"age,omitempty"`
+	Lifetime time.Duration `json:"lifetime,omitempty"`
+	// Reason explains the decision, as in the logs of the Transport
+	Reason string `json:"reason"`
+	// ETag and LastModified are the validators of the cached response
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	// Vary is the Vary header of the cached response
+	Vary string `json:"vary,omitempty"`
+}
+
+// Explain returns how t would handle req, from the lookup of its key to the
+// freshness of the cached response, without contacting the server nor
+// updating the statistics of t, e.g. for debugging endpoints.
+func (t *Transport) Explain(req *http.Request) Decision {
+	d := Decision{Key: t.key(req)}
+	if req.Method != http.MethodGet && req.Method != http.MethodHead || req.Header.Get("range") != "" {
+		d.Action = "bypass"
+		d.Reason = bypassReason(req)
+		return d
+	}
+	cachedResp, err := t.cachedResponse(d.Key, req.WithContext(withPeek(req.Context())))
+	if err != nil || cachedResp == nil {
+		d.Action = "miss"
+		d.Reason = "no cached response"
+		if err != nil {
+			d.Reason = "unreadable cached response: " + err.Error()
+		}
+		return d
+	}
+	cachedResp.Body.Close()
+	d.Found = true
+	d.ETag = cachedResp.Header.Get("etag")
+	d.LastModified = cachedResp.Header.Get("last-modified")
+	d.Vary = cachedResp.Header.Get("vary")
+
+	policy := t.policy(req.URL)
+	info := t.freshness(req, d.Key, policy, cachedResp.Header)
+	d.Freshness = freshnessName(info.freshness)
+	d.Age = info.age
+	d.Lifetime = info.lifetime
+	d.Reason = info.reason
+	switch {
+	case info.freshness == fresh:
+		d.Action = "hit"
+	case info.freshness == transparent:
+		d.Action = "bypass"
+	case onlyIfCached(req):
+		d.Action = "miss"
+		d.Reason += ", only-if-cached request directive"
+	case d.ETag != "" || d.LastModified != "":
+		d.Action = "revalidate"
+	default:
+		d.Action = "miss"
+		d.Reason += ", no validators"
+	}
+	if info.freshness == stale && policy.StaleIfSlow > 0 && !policy.mustRevalidate(cachedResp.Header) && !onlyIfCached(req) {
+		d.Reason += fmt.Sprintf(", stale response served if the server takes more than %s", policy.StaleIfSlow)
+	}
+	return d
+}
@@ -0,0 +1,113 @@
+package httpcache
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestExplain(t *testing.T) {
+	resetTest()
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	for _, path := range []string{"/method", "/etag"} {
+		resp, err := tp.Client().Get(s.server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	tests := []struct {
+		method, path, action string
+		found                bool
+	}{
+		{http.MethodGet, "/method", "hit", true},
+		{http.MethodGet, "/etag", "revalidate", true},
+		{http.MethodGet, "/nostore", "miss", false},
+		{http.MethodPost, "/method", "bypass", false},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest(test.method, s.server.URL+test.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := tp.Explain(req)
+		if d.Action != test.action || d.Found != test.found || d.Reason == "" {
+			t.Errorf("%s %s: got %+v, want action %q", test.method, test.path, d, test.action)
+		}
+	}
+	if stats := tp.Stats(); stats.Hits != 0 {
+		t.Fatalf("explanations counted as %d hits", stats.Hits)
+	}
+}
+
+// TestExplainAgrees checks that Explain, Cached and RoundTrip take the same
+// decision for the stale responses served without revalidation.
+func TestExplainAgrees(t *testing.T) {
+	resetTest()
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Cache-Control", "max-age=0")
+		w.Header().Set("ETag", `"1"`)
+		io.WriteString(w, "body")
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name  string
+		setup func(tp *Transport)
+		// fetches is the number of requests priming the cache
+		fetches int
+		ctx     func() (context.Context, context.CancelFunc)
+	}{
+		{"offline", func(tp *Transport) { tp.Offline = true }, 1, nil},
+		{"revalidation interval", func(tp *Transport) { tp.RevalidationInterval = time.Hour }, 2, nil},
+		{"tight deadline", func(tp *Transport) { tp.TightDeadline = time.Hour }, 1, func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.Background(), time.Minute)
+		}},
+	}
+	for _, test := range tests {
+		tp := NewMemoryCacheTransport(defaultMaxEntries)
+		test.setup(tp)
+		for i := 0; i < test.fetches; i++ {
+			resp, err := tp.Client().Get(server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		ctx, cancel := context.Background(), context.CancelFunc(func() {})
+		if test.ctx != nil {
+			ctx, cancel = test.ctx()
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := tp.Explain(req); d.Action != "hit" {
+			t.Errorf("%s: explained as %+v, want a hit", test.name, d)
+		}
+		if resp, ok := tp.Cached(req); !ok {
+			t.Errorf("%s: response not served by Cached", test.name)
+		} else {
+			resp.Body.Close()
+		}
+		requests.Store(0)
+		resp, err := tp.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if n := requests.Load(); n != 0 {
+			t.Errorf("%s: got %d requests, want the response served by RoundTrip", test.name, n)
+		}
+		cancel()
+	}
+}
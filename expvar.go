@@ -0,0 +1,81 @@
+package httpcache
+
+import (
+	"expvar"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/cozy/httpcache/lru"
+)
+
+// expvarCounters counts the decisions of a Transport for PublishExpvar.
+type expvarCounters struct {
+	hits, misses, revalidations, bypasses, stores int64
+}
+
+// PublishExpvar publishes live statistics of t as an expvar variable named
+// name: the number of hits, misses, revalidations, bypasses and stores, the
+// hit ratio, the savings and background refreshes reported by Stats, the ten
+// hottest keys reported by HotKeys, and the entries and bytes of the
+// cache if it reports them like MemoryCache does. It wraps the hooks of t,
+// and must be called once, before t is used.
+func (t *Transport) PublishExpvar(name string) {
+	c := &expvarCounters{}
+	t.OnHit = chainHooks(t.OnHit, counterHook(&c.hits))
+	t.OnMiss = chainHooks(t.OnMiss, counterHook(&c.misses))
+	t.OnRevalidate = chainHooks(t.OnRevalidate, counterHook(&c.revalidations))
+	t.OnBypass = chainHooks(t.OnBypass, counterHook(&c.bypasses))
+	t.OnStore = chainHooks(t.OnStore, counterHook(&c.stores))
+
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		hits := atomic.LoadInt64(&c.hits)
+		misses := atomic.LoadInt64(&c.misses)
+		revalidations := atomic.LoadInt64(&c.revalidations)
+		stats := t.Stats()
+		vars := map[string]interface{}{
+			"hits":           hits,
+			"misses":         misses,
+			"revalidations":  revalidations,
+			"bypasses":       atomic.LoadInt64(&c.bypasses),
+			"stores":         atomic.LoadInt64(&c.stores),
+			"hit_ratio":      ratio(hits+revalidations, hits+revalidations+misses),
+			"bytes_saved":    stats.BytesSaved,
+			"requests_saved": stats.RequestsSaved,
+			"bytes_fetched":  stats.BytesFetched,
+			"refreshes":      stats.Refreshes,
+			"cache_errors":   stats.CacheErrors,
+			"hot_keys":       t.HotKeys(10),
+		}
+		if s, ok := t.cache().(interface{ Stats() lru.Stats }); ok {
+			stats := s.Stats()
+			vars["entries"] = stats.Entries
+			vars["bytes"] = stats.Bytes
+		}
+		return vars
+	}))
+}
+
+// chainHooks returns a hook calling first and then second.
+func chainHooks(first, second func(*http.Request, Event)) func(*http.Request, Event) {
+	if first == nil {
+		return second
+	}
+	return func(req *http.Request, ev Event) {
+		first(req, ev)
+		second(req, ev)
+	}
+}
+
+func counterHook(n *int64) func(*http.Request, Event) {
+	return func(*http.Request, Event) {
+		atomic.AddInt64(n, 1)
+	}
+}
+
+// ratio returns n/total, or 0 if total is 0.
+func ratio(n, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) / float64(total)
+}
@@ -0,0 +1,78 @@
+package httpcache
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPublishExpvar(t *testing.T) {
+	resetTest()
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	tp.PublishExpvar("httpcache_test")
+	client := tp.Client()
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(s.server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	var vars struct {
+		Hits     int64   `json:"hits"`
+		Misses   int64   `json:"misses"`
+		HitRatio float64 `json:"hit_ratio"`
+		Entries  int64   `json:"entries"`
+	}
+	if err := json.Unmarshal([]byte(expvar.Get("httpcache_test").String()), &vars); err != nil {
+		t.Fatal(err)
+	}
+	if vars.Hits != 1 || vars.Misses != 1 || vars.HitRatio != 0.5 || vars.Entries != 1 {
+		t.Fatalf("unexpected published statistics: %+v", vars)
+	}
+}
+
+func TestPublishExpvarRefreshes(t *testing.T) {
+	resetTest()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		io.WriteString(w, "body")
+	}))
+	defer server.Close()
+
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	tp.PublishExpvar("httpcache_test_refreshes")
+	resp, err := tp.Client().Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	for i := 0; i < 2; i++ {
+		if _, err := tp.Revalidate(context.Background(), server.URL); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var vars struct {
+		Refreshes int64 `json:"refreshes"`
+	}
+	if err := json.Unmarshal([]byte(expvar.Get("httpcache_test_refreshes").String()), &vars); err != nil {
+		t.Fatal(err)
+	}
+	if vars.Refreshes != 2 {
+		t.Fatalf("got %d refreshes, want 2", vars.Refreshes)
+	}
+}
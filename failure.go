@@ -0,0 +1,100 @@
+package httpcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// ErrHostUnreachable is returned for the requests to a host that recently
+// failed to resolve or accept connections, see Transport.ConnectFailureTTL.
+var ErrHostUnreachable = errors.New("httpcache: host recently unreachable")
+
+// hostFailure is a connection failure remembered for a host.
+type hostFailure struct {
+	err   error
+	until time.Time
+}
+
+// send sends req to the server through transport, with the Via header of t
+// and capturing its early hints, unless its host recently failed to resolve
+// or accept connections. The requests sent by t on its own behalf wait for
+// their turn in its OriginBudget, and count against it until their response
+// bodies are read or closed.
+func (t *Transport) send(transport http.RoundTripper, req *http.Request) (*http.Response, error) {
+	if !isBackground(req.Context()) {
+		return t.sendRequest(transport, req)
+	}
+	t.countRefresh(req)
+	if t.OriginBudget == nil {
+		return t.sendRequest(transport, req)
+	}
+	release, err := t.OriginBudget.acquire(req.Context(), req.URL.Host)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.sendRequest(transport, req)
+	if err != nil || resp.Body == nil {
+		release()
+		return resp, err
+	}
+	// The request is in flight until its body is read
+	resp.Body = &budgetBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+// sendRequest sends req with transport, as described by send, outside of the
+// OriginBudget of t.
+func (t *Transport) sendRequest(transport http.RoundTripper, req *http.Request) (*http.Response, error) {
+	req = t.captureEarlyHints(req)
+	if t.Via != "" {
+		req = req.Clone(req.Context())
+		addVia(req.Header, req.ProtoMajor, req.ProtoMinor, t.Via)
+	}
+	if t.ConnectFailureTTL <= 0 {
+		return transport.RoundTrip(req)
+	}
+	st := t.state()
+	host := req.URL.Host
+	st.mu.Lock()
+	failure, ok := st.hostFailures[host]
+	if ok && t.now().After(failure.until) {
+		delete(st.hostFailures, host)
+		ok = false
+	}
+	st.mu.Unlock()
+	if ok {
+		return nil, fmt.Errorf("%w: %s: %v", ErrHostUnreachable, host, failure.err)
+	}
+
+	resp, err := transport.RoundTrip(req)
+	if err != nil && isConnectFailure(err) {
+		st.mu.Lock()
+		if st.hostFailures == nil {
+			st.hostFailures = make(map[string]hostFailure)
+		}
+		st.hostFailures[host] = hostFailure{err: err, until: t.now().Add(t.ConnectFailureTTL)}
+		st.mu.Unlock()
+	}
+	return resp, err
+}
+
+// isConnectFailure reports whether err comes from a failure to resolve or
+// connect to a host, or is a remembered one.
+func isConnectFailure(err error) bool {
+	if errors.Is(err, ErrHostUnreachable) {
+		return true
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
@@ -0,0 +1,57 @@
+package httpcache
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// countingTransport counts the requests sent through it.
+type countingTransport struct {
+	http.RoundTripper
+	n int
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.n++
+	return t.RoundTripper.RoundTrip(req)
+}
+
+func TestConnectFailureTTL(t *testing.T) {
+	resetTest()
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	tp.ConnectFailureTTL = time.Minute
+	client := tp.Client()
+	url := s.server.URL + "/etag"
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	failing := &countingTransport{RoundTripper: failingTransport{}}
+	tp.Transport = failing
+	for i := 0; i < 3; i++ {
+		if _, err := client.Get("http://unreachable.example/"); err == nil {
+			t.Fatal("no error for an unreachable host")
+		} else if i > 0 && !errors.Is(err, ErrHostUnreachable) {
+			t.Fatalf("got error %v, want ErrHostUnreachable", err)
+		}
+	}
+	if failing.n != 1 {
+		t.Fatalf("unreachable host tried %d times, want 1", failing.n)
+	}
+
+	resp, err = client.Get(url)
+	if err != nil {
+		t.Fatalf("stale response not served on connection failure: %v", err)
+	}
+	resp.Body.Close()
+	if resp.Header.Get(XFromCache) != CacheStale {
+		t.Fatalf("got X-From-Cache %q, want %q", resp.Header.Get(XFromCache), CacheStale)
+	}
+}
@@ -0,0 +1,45 @@
+package httpcache
+
+import (
+	"net/http"
+	"time"
+)
+
+// A FreshnessState tells how a cached response can be used for a request.
+type FreshnessState int
+
+const (
+	// Stale responses must be revalidated with the server before use
+	Stale FreshnessState = stale
+	// Fresh responses can be served without contacting the server
+	Fresh FreshnessState = fresh
+	// Transparent responses must not be used, the request bypassing the
+	// cache
+	Transparent FreshnessState = transparent
+)
+
+// String returns the name of s.
+func (s FreshnessState) String() string {
+	return freshnessName(int(s))
+}
+
+// Freshness returns the state of a cached response with the headers
+// respHeaders for a request with the headers reqHeaders, following the rules
+// of a private cache, and the time left before the response becomes stale,
+// negative once it is.
+func Freshness(respHeaders, reqHeaders http.Header) (FreshnessState, time.Duration) {
+	info := evaluateFreshness(respHeaders, reqHeaders)
+	if info.freshness == transparent {
+		return Transparent, 0
+	}
+	return FreshnessState(info.freshness), info.lifetime - info.age
+}
+
+// IsCacheable reports whether resp, the response to req, can be stored by a
+// private cache.
+func IsCacheable(req *http.Request, resp *http.Response) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead || req.Header.Get("range") != "" {
+		return false
+	}
+	return Policy{}.cannotStoreReason(req, resp, time.Now()) == ""
+}
@@ -0,0 +1,57 @@
+package httpcache
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestFreshness(t *testing.T) {
+	resetTest()
+	clock = &fakeClock{elapsed: 10 * time.Second}
+	now := time.Now().UTC().Format(http.TimeFormat)
+	tests := []struct {
+		resp, req string
+		state     FreshnessState
+		remaining time.Duration
+	}{
+		{"max-age=60", "", Fresh, 50 * time.Second},
+		{"max-age=0", "", Stale, -10 * time.Second},
+		{"no-cache", "", Stale, 0},
+		{"max-age=5", "max-stale", Fresh, -5 * time.Second},
+		{"max-age=60", "no-cache", Transparent, 0},
+	}
+	for _, test := range tests {
+		respHeaders := http.Header{"Date": {now}, "Cache-Control": {test.resp}}
+		reqHeaders := http.Header{"Cache-Control": {test.req}}
+		state, remaining := Freshness(respHeaders, reqHeaders)
+		if state != test.state || remaining != test.remaining {
+			t.Errorf("%q, %q: got %s with %s left, want %s with %s left",
+				test.resp, test.req, state, remaining, test.state, test.remaining)
+		}
+	}
+}
+
+func TestIsCacheable(t *testing.T) {
+	tests := []struct {
+		method, cacheControl string
+		status               int
+		want                 bool
+	}{
+		{http.MethodGet, "max-age=60", http.StatusOK, true},
+		{http.MethodHead, "", http.StatusOK, true},
+		{http.MethodPost, "max-age=60", http.StatusOK, false},
+		{http.MethodGet, "no-store", http.StatusOK, false},
+		{http.MethodGet, "max-age=60", http.StatusInternalServerError, false},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest(test.method, "http://example.com/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp := &http.Response{StatusCode: test.status, Header: http.Header{"Cache-Control": {test.cacheControl}}}
+		if got := IsCacheable(req, resp); got != test.want {
+			t.Errorf("%s %q %d: got %v, want %v", test.method, test.cacheControl, test.status, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package httpcache
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// GC removes the entries of a cache that can't be of any use anymore, for
+// the backends that don't expire them by themselves. An entry is collected
+// once it has no validators to be revalidated with, and its freshness
+// lifetime plus the staleness allowed by MaxStale or its stale-if-error
+// directive has passed. Entries without an explicit lifetime are kept.
+//
+// GC implements Expirer, so that it can be run periodically by StartJanitor.
+type GC struct {
+	// MaxStale is how long after expiring the responses are still useful
+	// to the clients, e.g. to those sending max-stale request directives
+	MaxStale time.Duration
+
+	cache Cache
+	keys  KeyLister
+}
+
+// NewGC returns a GC for c, which must implement KeyLister.
+func NewGC(c Cache, maxStale time.Duration) (*GC, error) {
+	keys, ok := c.(KeyLister)
+	if !ok {
+		return nil, errors.New("httpcache: garbage collection requires a KeyLister")
+	}
+	return &GC{MaxStale: maxStale, cache: c, keys: keys}, nil
+}
+
+// RemoveExpired removes at most max entries that can't be of any use
+// anymore, or all of them if max is zero, and returns how many were removed.
+func (gc *GC) RemoveExpired(max int) int {
+	n := 0
+	for _, key := range gc.keys.Keys() {
+		b, ok := peek(gc.cache, key)
+		if !ok || !gc.useless(key, b) {
+			continue
+		}
+		gc.cache.Delete(key)
+		n++
+		if max != 0 && n >= max {
+			break
+		}
+	}
+	return n
+}
+
+// useless reports whether the response b stored at key can't be of any use
+// anymore. Entries that can't be parsed are useless.
+func (gc *GC) useless(key string, b []byte) bool {
+	resp, err := parseEntry(key, b)
+	if err != nil {
+		return true
+	}
+	return uselessResponse(resp, gc.MaxStale)
+}
+
+// parseEntry parses the response b stored at key.
+func parseEntry(key string, b []byte) (*http.Response, error) {
+	req, err := keyRequest(key)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := readEntry(b, req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return resp, err
+}
+
+// uselessResponse reports whether the cached response resp can't be of any
+// use anymore, even to clients accepting maxStale.
+func uselessResponse(resp *http.Response, maxStale time.Duration) bool {
+	if resp.Header.Get("etag") != "" || resp.Header.Get("last-modified") != "" {
+		return false
+	}
+	date, ok := parseDate(resp.Header)
+	if !ok {
+		return false
+	}
+	cc := ParseCacheControl(resp.Header)
+	lifetime, ok := responseLifetime(resp.Header, cc, date)
+	if !ok {
+		return false
+	}
+	grace := maxStale
+	if staleIfError, err := parseDuration(cc["stale-if-error"]); err == nil && staleIfError > grace {
+		grace = staleIfError
+	}
+	return clock.since(date) > lifetime+grace
+}
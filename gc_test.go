@@ -0,0 +1,59 @@
+package httpcache
+
+import (
+	"net/http"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestGC(t *testing.T) {
+	resetTest()
+	c := NewMemoryCache(0)
+	date := time.Now().UTC().Format(http.TimeFormat)
+	entries := map[string]string{
+		"http://example.com/expired":        "Cache-Control: max-age=60",
+		"http://example.com/fresh":          "Cache-Control: max-age=3600",
+		"http://example.com/stale-if-error": "Cache-Control: max-age=60, stale-if-error=3600",
+		"http://example.com/etag":           "Cache-Control: max-age=60\r\nEtag: \"1\"",
+		"http://example.com/no-lifetime":    "X-Foo: bar",
+	}
+	for key, headers := range entries {
+		c.Set(key, []byte("HTTP/1.1 200 OK\r\nDate: "+date+"\r\n"+headers+"\r\nContent-Length: 0\r\n\r\n"))
+	}
+	c.Set("http://example.com/garbage", []byte("garbage"))
+
+	if _, err := NewGC(struct{ Cache }{c}, 0); err == nil {
+		t.Fatal("no error for a cache that can't list its keys")
+	}
+	gc, err := NewGC(c, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock = &fakeClock{elapsed: 10 * time.Minute}
+	if n := gc.RemoveExpired(0); n != 2 {
+		t.Fatalf("collected %d entries, want 2", n)
+	}
+	keys := c.Keys()
+	sort.Strings(keys)
+	want := []string{
+		"http://example.com/etag",
+		"http://example.com/fresh",
+		"http://example.com/no-lifetime",
+		"http://example.com/stale-if-error",
+	}
+	if len(keys) != len(want) {
+		t.Fatalf("got keys %q, want %q", keys, want)
+	}
+	for i := range want {
+		if keys[i] != want[i] {
+			t.Fatalf("got keys %q, want %q", keys, want)
+		}
+	}
+
+	gc.MaxStale = 2 * time.Hour
+	clock = &fakeClock{elapsed: 90 * time.Minute}
+	if n := gc.RemoveExpired(0); n != 0 {
+		t.Fatalf("collected %d entries within MaxStale", n)
+	}
+}
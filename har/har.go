@@ -0,0 +1,218 @@
+// Package har exports the entries of an httpcache.Cache as a HAR (HTTP
+// Archive) file, so that cached interactions can be inspected in browser
+// devtools and attached to bug reports, and imports the HAR files recorded by
+// browsers into the cache of a Transport.
+//
+// The cache only stores responses: the request of each exported entry is
+// reconstructed from its key, and has no headers.
+package har
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/cozy/httpcache"
+)
+
+// Log is the root of a HAR file.
+type Log struct {
+	Version string  `json:"version"`
+	Creator Creator `json:"creator"`
+	Entries []Entry `json:"entries"`
+}
+
+// Creator identifies the application that created a HAR file.
+type Creator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// Entry is an HTTP interaction.
+type Entry struct {
+	StartedDateTime time.Time `json:"startedDateTime"`
+	Time            float64   `json:"time"`
+	Request         Request   `json:"request"`
+	Response        Response  `json:"response"`
+	Cache           struct{}  `json:"cache"`
+	Timings         Timings   `json:"timings"`
+}
+
+// Request is the request of an Entry.
+type Request struct {
+	Method      string   `json:"method"`
+	URL         string   `json:"url"`
+	HTTPVersion string   `json:"httpVersion"`
+	Cookies     []Cookie `json:"cookies"`
+	Headers     []Header `json:"headers"`
+	QueryString []Header `json:"queryString"`
+	HeadersSize int      `json:"headersSize"`
+	BodySize    int      `json:"bodySize"`
+}
+
+// Response is the response of an Entry.
+type Response struct {
+	Status      int      `json:"status"`
+	StatusText  string   `json:"statusText"`
+	HTTPVersion string   `json:"httpVersion"`
+	Cookies     []Cookie `json:"cookies"`
+	Headers     []Header `json:"headers"`
+	Content     Content  `json:"content"`
+	RedirectURL string   `json:"redirectURL"`
+	HeadersSize int      `json:"headersSize"`
+	BodySize    int      `json:"bodySize"`
+}
+
+// Header is a name/value pair, used for headers and query parameters.
+type Header struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Cookie is a cookie set by a response.
+type Cookie struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Content is the body of a Response. Text is base64 encoded when Encoding is
+// "base64", i.e. when the body isn't valid UTF-8.
+type Content struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// Timings holds the durations of the phases of an Entry, in milliseconds.
+// Cached entries don't record them, so they are all zero.
+type Timings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// Export writes the entries of c stored at keys to w as a HAR file. If keys is
+// nil, all the entries are exported, which requires c to implement
+// httpcache.KeyLister. Keys that are missing or can't be parsed are skipped.
+func Export(w io.Writer, c httpcache.Cache, keys []string) error {
+	if keys == nil {
+		lister, ok := c.(httpcache.KeyLister)
+		if !ok {
+			return errors.New("har: cache can't list its keys")
+		}
+		keys = lister.Keys()
+	}
+	log := Log{
+		Version: "1.2",
+		Creator: Creator{Name: "httpcache", Version: "1"},
+		Entries: []Entry{},
+	}
+	for _, key := range keys {
+		b, ok := c.Get(key)
+		if !ok {
+			continue
+		}
+		entry, err := NewEntry(key, b)
+		if err != nil {
+			continue
+		}
+		log.Entries = append(log.Entries, entry)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		Log Log `json:"log"`
+	}{log})
+}
+
+// markPrefix starts the names of the headers with which the cache marks the
+// responses it stores, which aren't part of the responses.
+const markPrefix = "X-Httpcache-"
+
+// NewEntry returns the HAR entry for the response b stored at key.
+func NewEntry(key string, b []byte) (Entry, error) {
+	method, rawurl := http.MethodGet, key
+	if i := strings.IndexByte(key, ' '); i >= 0 {
+		method, rawurl = key[:i], key[i+1:]
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return Entry{}, err
+	}
+	req := &http.Request{Method: method, URL: u}
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(b)), req)
+	if err != nil {
+		return Entry{}, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return Entry{}, err
+	}
+	for name := range resp.Header {
+		if strings.HasPrefix(name, markPrefix) {
+			resp.Header.Del(name)
+		}
+	}
+
+	entry := Entry{
+		Request: Request{
+			Method:      method,
+			URL:         rawurl,
+			HTTPVersion: resp.Proto,
+			Cookies:     []Cookie{},
+			Headers:     []Header{},
+			QueryString: pairs(u.Query()),
+			HeadersSize: -1,
+			BodySize:    0,
+		},
+		Response: Response{
+			Status:      resp.StatusCode,
+			StatusText:  http.StatusText(resp.StatusCode),
+			HTTPVersion: resp.Proto,
+			Cookies:     []Cookie{},
+			Headers:     pairs(resp.Header),
+			Content: Content{
+				Size:     len(body),
+				MimeType: resp.Header.Get("Content-Type"),
+			},
+			RedirectURL: resp.Header.Get("Location"),
+			HeadersSize: -1,
+			BodySize:    len(body),
+		},
+	}
+	if date, err := http.ParseTime(resp.Header.Get("Date")); err == nil {
+		entry.StartedDateTime = date
+	}
+	for _, cookie := range resp.Cookies() {
+		entry.Response.Cookies = append(entry.Response.Cookies, Cookie{Name: cookie.Name, Value: cookie.Value})
+	}
+	if utf8.Valid(body) {
+		entry.Response.Content.Text = string(body)
+	} else {
+		entry.Response.Content.Text = base64.StdEncoding.EncodeToString(body)
+		entry.Response.Content.Encoding = "base64"
+	}
+	return entry, nil
+}
+
+// pairs flattens the multi-valued map m into a list of name/value pairs.
+func pairs(m map[string][]string) []Header {
+	list := []Header{}
+	for name, values := range m {
+		for _, value := range values {
+			list = append(list, Header{Name: name, Value: value})
+		}
+	}
+	return list
+}
@@ -0,0 +1,97 @@
+package har
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cozy/httpcache"
+)
+
+func TestExport(t *testing.T) {
+	c := httpcache.NewMemoryCache(0)
+	c.Set("http://example.com/a?q=1", []byte("HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nDate: Mon, 02 Jan 2006 15:04:05 GMT\r\nX-Httpcache-Stored: 1136214245\r\nContent-Length: 5\r\n\r\nhello"))
+	c.Set("HEAD http://example.com/b", []byte("HTTP/1.1 404 Not Found\r\nContent-Length: 0\r\n\r\n"))
+	c.Set("http://example.com/bin", []byte("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\n\xff\xfe"))
+
+	var buf bytes.Buffer
+	if err := Export(&buf, c, nil); err != nil {
+		t.Fatal(err)
+	}
+	var har struct {
+		Log Log `json:"log"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &har); err != nil {
+		t.Fatal(err)
+	}
+	entries := map[string]Entry{}
+	for _, entry := range har.Log.Entries {
+		entries[entry.Request.Method+" "+entry.Request.URL] = entry
+	}
+	if len(entries) != 3 {
+		t.Fatalf("got %d entries, want 3", len(entries))
+	}
+
+	a := entries["GET http://example.com/a?q=1"]
+	if a.Response.Status != 200 || a.Response.Content.Text != "hello" || a.Response.Content.MimeType != "text/plain" {
+		t.Errorf("unexpected entry %+v", a)
+	}
+	for _, h := range a.Response.Headers {
+		if strings.HasPrefix(h.Name, "X-Httpcache-") {
+			t.Errorf("got internal header %s", h.Name)
+		}
+	}
+	if len(a.Request.QueryString) != 1 || a.Request.QueryString[0] != (Header{Name: "q", Value: "1"}) {
+		t.Errorf("got query string %+v", a.Request.QueryString)
+	}
+	if a.StartedDateTime.Year() != 2006 {
+		t.Errorf("got start time %v", a.StartedDateTime)
+	}
+	if b := entries["HEAD http://example.com/b"]; b.Response.Status != 404 {
+		t.Errorf("unexpected entry %+v", b)
+	}
+	if bin := entries["GET http://example.com/bin"]; bin.Response.Content.Encoding != "base64" || bin.Response.Content.Text != "//4=" {
+		t.Errorf("unexpected content %+v", bin.Response.Content)
+	}
+}
+
+func TestImport(t *testing.T) {
+	c := httpcache.NewMemoryCache(0)
+	var buf bytes.Buffer
+	c.Set("http://example.com/a", []byte("HTTP/1.1 200 OK\r\nCache-Control: max-age=3600\r\nContent-Length: 5\r\n\r\nhello"))
+	c.Set("http://example.com/b", []byte("HTTP/1.1 200 OK\r\nCache-Control: no-store\r\nContent-Length: 5\r\n\r\nworld"))
+	c.Set("http://example.com/missing", []byte("HTTP/1.1 404 Not Found\r\nCache-Control: max-age=3600\r\nContent-Length: 0\r\n\r\n"))
+	if err := Export(&buf, c, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tp := httpcache.NewMemoryCacheTransport(0)
+	n, err := Import(httpcache.WithLifetime(context.Background(), time.Hour), tp, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("got %d responses stored, want 2", n)
+	}
+	resp, ok := tp.Cached(httptest.NewRequest(http.MethodGet, "http://example.com/a", nil))
+	if !ok {
+		t.Fatal("imported response not cached")
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	if string(body) != "hello" {
+		t.Fatalf("got %q, want the imported body", body)
+	}
+	resp, ok = tp.Cached(httptest.NewRequest(http.MethodGet, "http://example.com/missing", nil))
+	if !ok || resp.StatusCode != http.StatusNotFound {
+		t.Fatal("imported 404 response not cached")
+	}
+	if _, ok := tp.Cache.Get("http://example.com/b"); ok {
+		t.Fatal("response refused by the storage policy imported")
+	}
+}
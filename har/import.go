@@ -0,0 +1,124 @@
+package har
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/cozy/httpcache"
+)
+
+// Import stores the responses of the HAR file read from r in the cache of t,
+// as if t had fetched them, so that they go through its storage policy, e.g.
+// to seed a test environment with the content recorded in a browser. It
+// returns how many responses were stored.
+//
+// Only the GET requests with a final response are imported, skipping the
+// informational and 304 Not Modified ones. The error responses, such as 404
+// Not Found, are stored if the storage policy of t allows it, as when
+// fetched. The responses keep their recorded headers, so they may already be
+// stale: requests made with ctx set by httpcache.WithLifetime are given a
+// lifetime instead. The responses already cached for the same requests are
+// replaced.
+func Import(ctx context.Context, t *httpcache.Transport, r io.Reader) (int, error) {
+	var har struct {
+		Log Log `json:"log"`
+	}
+	if err := json.NewDecoder(r).Decode(&har); err != nil {
+		return 0, err
+	}
+	stored := 0
+	t = t.Clone()
+	onStore := t.OnStore
+	t.OnStore = func(req *http.Request, ev httpcache.Event) {
+		stored++
+		if onStore != nil {
+			onStore(req, ev)
+		}
+	}
+	t.Middleware = nil
+	t.OriginBudget = nil
+	t.Prefetch = nil
+	for _, entry := range har.Log.Entries {
+		if entry.Request.Method != http.MethodGet || entry.Response.Status < 200 || entry.Response.Status == http.StatusNotModified {
+			continue
+		}
+		req, resp, err := entry.exchange(ctx)
+		if err != nil {
+			continue
+		}
+		t.Transport = roundTripperFunc(func(outReq *http.Request) (*http.Response, error) {
+			resp.Request = outReq
+			return resp, nil
+		})
+		got, err := t.RoundTrip(req)
+		if err != nil {
+			return stored, err
+		}
+		io.Copy(ioutil.Discard, got.Body)
+		got.Body.Close()
+	}
+	return stored, nil
+}
+
+// exchange returns the request and response of e, the request bypassing the
+// responses already cached.
+func (e Entry) exchange(ctx context.Context) (*http.Request, *http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, e.Request.Method, e.Request.URL, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, h := range e.Request.Headers {
+		name := strings.ToLower(h.Name)
+		if strings.HasPrefix(name, ":") || strings.HasPrefix(name, "if-") || name == "range" || name == "cache-control" {
+			continue
+		}
+		req.Header.Add(h.Name, h.Value)
+	}
+	req.Header.Set("Cache-Control", "no-cache")
+
+	body := []byte(e.Response.Content.Text)
+	if e.Response.Content.Encoding == "base64" {
+		if body, err = base64.StdEncoding.DecodeString(e.Response.Content.Text); err != nil {
+			return nil, nil, err
+		}
+	}
+	header := make(http.Header)
+	for _, h := range e.Response.Headers {
+		if strings.HasPrefix(h.Name, ":") {
+			continue
+		}
+		header.Add(h.Name, h.Value)
+	}
+	// The content is recorded decoded
+	header.Del("Content-Encoding")
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	if header.Get("Date") == "" && !e.StartedDateTime.IsZero() {
+		header.Set("Date", e.StartedDateTime.UTC().Format(http.TimeFormat))
+	}
+	resp := &http.Response{
+		Status:        strconv.Itoa(e.Response.Status) + " " + http.StatusText(e.Response.Status),
+		StatusCode:    e.Response.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+	}
+	return req, resp, nil
+}
+
+// roundTripperFunc is an http.RoundTripper calling itself.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+// RoundTrip calls f(req).
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
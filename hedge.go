@@ -0,0 +1,54 @@
+package httpcache
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// hedgeKey marks the context of the refresh requests of hedge, which aren't
+// hedged again.
+type hedgeKey struct{}
+
+// hedgeResult is the outcome of a refresh request of hedge.
+type hedgeResult struct {
+	resp *http.Response
+	err  error
+}
+
+// hedge refreshes the stale response cachedResp, cached for req at key, in
+// the background, and returns the response of the refresh if it arrives
+// within budget, or else cachedResp, while the refresh completes and updates
+// the cache. See Policy.StaleIfSlow.
+func (t *Transport) hedge(req *http.Request, key string, cachedResp *http.Response, budget time.Duration) (*http.Response, error) {
+	ctx := detach(req.Context())
+	refresh := req.Clone(context.WithValue(ctx, hedgeKey{}, true))
+	done := make(chan hedgeResult, 1)
+	go func() {
+		resp, err := t.RoundTrip(refresh)
+		done <- hedgeResult{resp, err}
+	}()
+
+	timer := time.NewTimer(budget)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		cachedResp.Body.Close()
+		return r.resp, r.err
+	case <-timer.C:
+	}
+	go func() {
+		// Reading the whole body stores the response
+		if r := <-done; r.err == nil {
+			io.Copy(ioutil.Discard, r.resp.Body)
+			r.resp.Body.Close()
+		}
+	}()
+	t.logDecision(req, key, "stale while slow", "no response after "+budget.String())
+	t.countServed(req, key, cachedResp, false)
+	t.mark(cachedResp, CacheStale)
+	t.transform(req, cachedResp)
+	return cachedResp, nil
+}
@@ -0,0 +1,68 @@
+package httpcache
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStaleIfSlow(t *testing.T) {
+	resetTest()
+	var version, slow int32 = 1, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&slow) != 0 {
+			time.Sleep(200 * time.Millisecond)
+		}
+		w.Header().Set("Cache-Control", "max-age=0")
+		io.WriteString(w, "v"+strconv.Itoa(int(atomic.LoadInt32(&version))))
+	}))
+	defer server.Close()
+
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	tp.StaleIfSlow = 50 * time.Millisecond
+	get := func() (string, string) {
+		resp, err := tp.Client().Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return string(body), resp.Header.Get(XFromCache)
+	}
+	get()
+
+	// A slow server is raced by the stale response
+	atomic.StoreInt32(&slow, 1)
+	atomic.StoreInt32(&version, 2)
+	start := time.Now()
+	if body, from := get(); body != "v1" || from != CacheStale || time.Since(start) > 150*time.Millisecond {
+		t.Fatalf("got %q from %q after %s, want the stale response", body, from, time.Since(start))
+	}
+	// The refresh completes in the background
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		b, _ := tp.Cache.Get(server.URL)
+		if resp, err := readEntry(b, nil); err == nil {
+			body, _ := ioutil.ReadAll(resp.Body)
+			if string(body) == "v2" {
+				break
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("cache not refreshed in the background")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// A fast server wins the race
+	atomic.StoreInt32(&slow, 0)
+	atomic.StoreInt32(&version, 3)
+	if body, from := get(); body != "v3" || from != "" {
+		t.Fatalf("got %q from %q, want the response of the server", body, from)
+	}
+}
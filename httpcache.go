@@ -1,23 +1,33 @@
 // Package httpcache provides a http.RoundTripper implementation that works as a
 // mostly RFC-compliant cache for http responses.
 //
-// It is only suitable for use as a 'private' cache (i.e. for a web-browser or an API-client
-// and not for a shared proxy).
+// By default, it works as a 'private' cache (i.e. for a web-browser or an API-client).
+// With the Shared field set, as NewProxy does, it works as a shared cache for a proxy:
+// private responses aren't stored, s-maxage is used and the responses are only served
+// to the requests with the same values of the headers named by Vary.
 //
 package httpcache
 
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"log/slog"
+	"math/rand"
 	"net/http"
 	"net/http/httputil"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/cozy/httpcache/conditional"
 	"github.com/cozy/httpcache/lru"
 )
 
@@ -30,6 +40,26 @@ const (
 // XFromCache is the header added to responses that are returned from the cache
 const XFromCache = "X-From-Cache"
 
+// Values of the XFromCache header, telling how a response was served from the
+// cache.
+const (
+	// CacheHit marks a fresh response served without contacting the server
+	CacheHit = "hit"
+	// CacheRevalidated marks a stale response that the server validated with
+	// 304 Not Modified
+	CacheRevalidated = "revalidated"
+	// CacheStale marks a stale response served without validation, because
+	// the request allowed it with max-stale, or the policy with MaxStale
+	CacheStale = "stale"
+	// CacheGrace marks a server error served from the cache during the
+	// grace period of the policy, see Policy.ServerErrorGrace
+	CacheGrace = "grace"
+)
+
+// XHttpcacheDebug is the header describing the decision taken for a response,
+// added when the Transport is in debug mode
+const XHttpcacheDebug = "X-Httpcache-Debug"
+
 var cacheableResponseCodes = map[int]struct{}{
 	http.StatusOK:                   {}, // 200
 	http.StatusNonAuthoritativeInfo: {}, // 203
@@ -51,15 +81,96 @@ type Cache interface {
 	Delete(key string)
 }
 
-// cacheKey returns the cache key for req.
+// A TTLCache is a Cache that can expire entries by itself. When the Cache of a
+// Transport implements it, responses are stored with a TTL hint: the duration
+// after which the entry is of no more use and can be treated as missing. A
+// zero TTL means the entry should be kept until evicted.
+type TTLCache interface {
+	Cache
+	// SetWithTTL stores the []byte representation of a response against a
+	// key, to be expired after ttl
+	SetWithTTL(key string, responseBytes []byte, ttl time.Duration)
+}
+
+// A ContextCache is a Cache whose writes can be canceled, so that a Transport
+// doesn't wait for an unresponsive backend once the request is canceled or
+// its StoreTimeout is exceeded. The Transport stores the responses with
+// SetContext when its Cache implements it.
+type ContextCache interface {
+	Cache
+	// SetContext stores the []byte representation of a response against a
+	// key, to be expired after ttl if not zero, unless ctx is done first
+	SetContext(ctx context.Context, key string, responseBytes []byte, ttl time.Duration) error
+}
+
+// A ContextGetter is a Cache whose reads can be canceled, and tell the
+// missing entries from the errors of the backend. The Transport reads the
+// responses with GetContext when its Cache implements it, and falls back to
+// the server on errors.
+type ContextGetter interface {
+	Cache
+	// GetContext returns the []byte representation of a cached response
+	// and a bool set to true if it is present, unless ctx is done first or
+	// the backend fails
+	GetContext(ctx context.Context, key string) (responseBytes []byte, ok bool, err error)
+}
+
+// A Peeker is a Cache that can look entries up without perturbing its
+// eviction order nor its statistics. The Transport uses Peek instead of Get
+// when its Cache implements it for the lookups of the monitoring and
+// debugging tools, see Transport.Peek.
+type Peeker interface {
+	Cache
+	// Peek returns the []byte representation of a cached response and a
+	// bool set to true if it is present, like Get, as if it never happened
+	Peek(key string) (responseBytes []byte, ok bool)
+}
+
+// peek returns the entry stored at key in c, without perturbing c if it is
+// a Peeker.
+func peek(c Cache, key string) ([]byte, bool) {
+	if p, ok := c.(Peeker); ok {
+		return p.Peek(key)
+	}
+	return c.Get(key)
+}
+
+// cacheKey returns the cache key for req, made of its canonical URL so that
+// the equivalent URLs map to one entry.
 func cacheKey(req *http.Request) string {
 	if req.Method == http.MethodGet {
-		return req.URL.String()
+		return canonicalURL(req.URL)
 	} else {
-		return req.Method + " " + req.URL.String()
+		return req.Method + " " + canonicalURL(req.URL)
 	}
 }
 
+// Key returns the key at which the response to req is cached by default. The
+// Transports with KeyByAuthorization set, or those of an InstanceTransport,
+// use other keys: see Transport.Key.
+func Key(req *http.Request) string {
+	return cacheKey(req)
+}
+
+// Delete removes from c the response cached for req at its default key, see
+// Key and Transport.Delete.
+func Delete(c Cache, req *http.Request) {
+	c.Delete(cacheKey(req))
+}
+
+// Key returns the key at which t caches the response to req, according to
+// KeyByAuthorization. For the Transports of an InstanceTransport, it is the
+// key in the partition of the instance.
+func (t *Transport) Key(req *http.Request) string {
+	return t.key(req)
+}
+
+// Delete removes the response cached by t for req, at the key returned by
+// t.Key, and forgets how it was served.
+func (t *Transport) Delete(req *http.Request) {
+	t.delete(req.Context(), t.key(req))
+}
+
 // CachedResponse returns the cached http.Response for req if present, and nil
 // otherwise.
 func CachedResponse(c Cache, req *http.Request) (resp *http.Response, err error) {
@@ -67,41 +178,299 @@ func CachedResponse(c Cache, req *http.Request) (resp *http.Response, err error)
 	if !ok {
 		return
 	}
-	b := bytes.NewBuffer(cachedVal)
-	return http.ReadResponse(bufio.NewReader(b), req)
+	resp, err = readEntry(cachedVal, req)
+	if err != nil {
+		return nil, err
+	}
+	stripMarks(resp.Header)
+	return resp, nil
+}
+
+// cachedResponse returns the response to req cached by t at key if present,
+// and nil otherwise. The entries bound to another key are deleted as
+// corrupt.
+func (t *Transport) cachedResponse(key string, req *http.Request) (*http.Response, error) {
+	cachedVal, ok, err := t.lookup(req.Context(), key)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, nil
+	}
+	resp, err := readEntry(cachedVal, req)
+	if err != nil {
+		return nil, err
+	}
+	if bound := resp.Header.Get(xKey); bound != "" && bound != key {
+		resp.Body.Close()
+		t.logError(req.Context(), "corrupt entry", fmt.Errorf("%w: entry of %q found at %q", ErrKeyMismatch, bound, key))
+		t.delete(req.Context(), key)
+		return nil, nil
+	}
+	if t.policy(req.URL).Shared && !variedMatches(resp.Header, req) {
+		// The response is the variant for other values of the request
+		// headers named by its Vary header
+		resp.Body.Close()
+		return nil, nil
+	}
+	t.audit(req.Context(), AuditRead, key, false)
+	return resp, nil
+}
+
+// xKey is the header binding a cached response to the key of the request it
+// answers, to detect the collisions of keys and the entries misplaced by a
+// backend. It is only stored in the cache, never served.
+const xKey = "X-Httpcache-Key"
+
+// ErrKeyMismatch is logged when the response cached at a key was stored for
+// another one.
+var ErrKeyMismatch = errors.New("httpcache: cached response bound to another key")
+
+// withKey returns marks with the mark binding a response to key.
+func withKey(marks http.Header, key string) http.Header {
+	if marks == nil {
+		marks = http.Header{}
+	}
+	marks.Set(xKey, key)
+	return marks
+}
+
+// xUncompressed is the header marking the cached responses that were
+// transparently decompressed by the underlying transport. It is only stored
+// in the cache, never served.
+const xUncompressed = "X-Httpcache-Uncompressed"
+
+// The limits of the header section of the cached entries, checked before
+// parsing them so that a corrupted or crafted entry can't make
+// http.ReadResponse use unbounded memory.
+const (
+	maxEntryStatusLine  = 1 << 10
+	maxEntryHeaders     = 1000
+	maxEntryHeaderBytes = http.DefaultMaxHeaderBytes
+)
+
+// ErrEntryLimits is returned when a cached entry exceeds the limits of the
+// header section of the entries.
+var ErrEntryLimits = errors.New("httpcache: cached entry exceeds the header limits")
+
+// checkEntryLimits checks that the header section of the entry b is within
+// the limits, without parsing it.
+func checkEntryLimits(b []byte) error {
+	line, rest, _ := bytes.Cut(b, []byte("\n"))
+	if len(line) > maxEntryStatusLine {
+		return fmt.Errorf("%w: status line of %d bytes", ErrEntryLimits, len(line))
+	}
+	headers, size := 0, 0
+	for len(rest) > 0 {
+		line, rest, _ = bytes.Cut(rest, []byte("\n"))
+		if len(bytes.TrimSuffix(line, []byte("\r"))) == 0 {
+			return nil
+		}
+		headers++
+		size += len(line) + 1
+		if headers > maxEntryHeaders {
+			return fmt.Errorf("%w: more than %d header lines", ErrEntryLimits, maxEntryHeaders)
+		}
+		if size > maxEntryHeaderBytes {
+			return fmt.Errorf("%w: headers of more than %d bytes", ErrEntryLimits, maxEntryHeaderBytes)
+		}
+	}
+	return nil
+}
+
+// readEntry parses the response cached in b for req, restoring what its
+// HTTP/1.1 serialization doesn't carry. The entries exceeding the header
+// limits are rejected with ErrEntryLimits.
+func readEntry(b []byte, req *http.Request) (*http.Response, error) {
+	if err := checkEntryLimits(b); err != nil {
+		return nil, err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(b)), req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Header.Get(xUncompressed) != "" {
+		resp.Header.Del(xUncompressed)
+		resp.Uncompressed = true
+	}
+	if resp.ProtoMajor >= 2 {
+		// The chunked framing is only used to store the body of unknown
+		// length, HTTP/2 has no transfer coding
+		resp.TransferEncoding = nil
+	}
+	return resp, nil
+}
+
+// EntryMeta holds the metadata of a cached response, see
+// CachedResponseWithMeta.
+type EntryMeta struct {
+	// Stored is when the response was stored or last revalidated, or,
+	// for the entries stored by previous versions, its Date header. It is
+	// zero if unknown.
+	Stored time.Time
+	// Age is the current age of the response, or zero if unknown
+	Age time.Duration
+	// Expires is when the response becomes stale, or zero if it has no
+	// explicit freshness lifetime
+	Expires time.Time
+	// ETag and LastModified are the validators of the response, if any
+	ETag, LastModified string
+	// Vary is the Vary header of the response, listing the request headers
+	// its variants depend on
+	Vary string
+	// Size is the size in bytes of the cached entry
+	Size int
+}
+
+// xStored is the header recording when a response was stored in the cache.
+// It is only stored in the cache, never served.
+const xStored = "X-Httpcache-Stored"
+
+// storedTime returns when the response with the headers respHeaders was
+// stored, falling back to its Date header.
+func storedTime(respHeaders http.Header) (time.Time, bool) {
+	if stored, err := time.Parse(time.RFC3339Nano, respHeaders.Get(xStored)); err == nil {
+		return stored, true
+	}
+	return parseDate(respHeaders)
+}
+
+// stripMarks removes from respHeaders the marks only meaningful in the
+// cache.
+func stripMarks(respHeaders http.Header) {
+	respHeaders.Del(xSoftPurged)
+	respHeaders.Del(xGrace)
+	respHeaders.Del(xLifetime)
+	respHeaders.Del(xStored)
+	respHeaders.Del(xKey)
+	for name := range respHeaders {
+		if strings.HasPrefix(name, xVaried) {
+			respHeaders.Del(name)
+		}
+	}
+}
+
+// CachedResponseWithMeta is like CachedResponse, with the metadata of the
+// cached response, e.g. to tell the users how old the data they see is. It
+// returns nil metadata when nothing is cached.
+func CachedResponseWithMeta(c Cache, req *http.Request) (*http.Response, *EntryMeta, error) {
+	cachedVal, ok := c.Get(cacheKey(req))
+	if !ok {
+		return nil, nil, nil
+	}
+	resp, err := readEntry(cachedVal, req)
+	if err != nil {
+		return nil, nil, err
+	}
+	meta := &EntryMeta{
+		ETag:         resp.Header.Get("etag"),
+		LastModified: resp.Header.Get("last-modified"),
+		Vary:         resp.Header.Get("vary"),
+		Size:         len(cachedVal),
+	}
+	meta.Stored, _ = storedTime(resp.Header)
+	stripMarks(resp.Header)
+	if date, ok := parseDate(resp.Header); ok {
+		meta.Age = clock.since(date)
+		if lifetime, ok := responseLifetime(resp.Header, ParseCacheControl(resp.Header), date); ok {
+			meta.Expires = date.Add(lifetime)
+		}
+	}
+	return resp, meta, nil
 }
 
 // MemoryCache is an implemtation of Cache that stores responses in an in-memory map.
 type MemoryCache struct {
-	mu    sync.RWMutex
-	items *lru.Cache
+	items *lru.SyncCache
 }
 
 // Get returns the []byte representation of the response and true if present, false if not
 func (c *MemoryCache) Get(key string) (resp []byte, ok bool) {
-	c.mu.Lock()
-	resp, ok = c.items.Get(lru.Key(key))
-	c.mu.Unlock()
-	return resp, ok
+	return c.items.Get(lru.Key(key))
+}
+
+// Peek is like Get, without marking the response as recently used
+func (c *MemoryCache) Peek(key string) (resp []byte, ok bool) {
+	return c.items.Peek(lru.Key(key))
 }
 
 // Set saves response resp to the cache with key
 func (c *MemoryCache) Set(key string, resp []byte) {
-	c.mu.Lock()
 	c.items.Add(lru.Key(key), resp)
-	c.mu.Unlock()
+}
+
+// SetWithTTL saves response resp to the cache with key, and expires it after
+// ttl if it is not zero
+func (c *MemoryCache) SetWithTTL(key string, resp []byte, ttl time.Duration) {
+	if ttl <= 0 {
+		c.Set(key, resp)
+		return
+	}
+	c.items.AddWithExpiry(lru.Key(key), resp, time.Now().Add(ttl))
 }
 
 // Delete removes key from the cache
 func (c *MemoryCache) Delete(key string) {
-	c.mu.Lock()
 	c.items.Remove(lru.Key(key))
-	c.mu.Unlock()
+}
+
+// RemoveExpired removes at most max expired entries, or all of them if max is
+// zero, and returns how many were removed
+func (c *MemoryCache) RemoveExpired(max int) int {
+	return c.items.RemoveExpired(max)
+}
+
+// Pin prevents the entry stored at key from being evicted when the cache is
+// full. It can still be deleted, or expire.
+func (c *MemoryCache) Pin(key string) {
+	c.items.Pin(lru.Key(key))
+}
+
+// Unpin makes the entry stored at key evictable again
+func (c *MemoryCache) Unpin(key string) {
+	c.items.Unpin(lru.Key(key))
+}
+
+// PinMatching prevents the entries whose key matches f from being evicted,
+// e.g. all the URLs under a given prefix. A nil f unpins them.
+func (c *MemoryCache) PinMatching(f func(key string) bool) {
+	if f == nil {
+		c.items.PinFunc(nil)
+		return
+	}
+	c.items.PinFunc(func(key lru.Key) bool { return f(string(key)) })
+}
+
+// SetMaxIdle makes the cache drop the entries that haven't been accessed for
+// d, whether they are still fresh or not. Zero disables idle eviction.
+func (c *MemoryCache) SetMaxIdle(d time.Duration) {
+	c.items.SetMaxIdle(d)
+}
+
+// PurgeMatching removes the entries whose key matches f, in a single pass
+// over the cache, and returns how many were removed
+func (c *MemoryCache) PurgeMatching(f func(key string) bool) int {
+	return c.items.RemoveMatching(func(key lru.Key) bool { return f(string(key)) })
+}
+
+// Keys returns the keys of the entries in the cache that haven't expired
+func (c *MemoryCache) Keys() []string {
+	keys := c.items.Keys()
+	strs := make([]string, len(keys))
+	for i, key := range keys {
+		strs[i] = string(key)
+	}
+	return strs
+}
+
+// Stats returns the usage statistics of the cache
+func (c *MemoryCache) Stats() lru.Stats {
+	return c.items.Stats()
 }
 
 // NewMemoryCache returns a new Cache that will store items in an in-memory map
 func NewMemoryCache(maxEntries int) *MemoryCache {
-	return &MemoryCache{items: lru.New(maxEntries)}
+	return &MemoryCache{items: lru.NewSync(maxEntries)}
 }
 
 // Transport is an implementation of http.RoundTripper that will return values from a cache
@@ -112,8 +481,499 @@ type Transport struct {
 	// If nil, http.DefaultTransport is used
 	Transport http.RoundTripper
 	Cache     Cache
-	// If true, responses returned from the cache will be given an extra header, X-From-Cache
+	// If true, responses returned from the cache will be given an extra header, X-From-Cache,
+	// set to CacheHit, CacheRevalidated, CacheStale or CacheGrace
 	MarkCachedResponses bool
+	// If true, the X-From-Cache header is set to "1" whatever the way the
+	// response was served, as in previous versions
+	LegacyCacheMarker bool
+	// CacheMarkerHeader is the name of the header used instead of X-From-Cache
+	// to mark cached responses, for backends and proxies that strip X-
+	// headers. The marker is disabled by MarkCachedResponses.
+	CacheMarkerHeader string
+
+	// OnHit, if non-nil, is called when a fresh response is served from the cache
+	OnHit func(req *http.Request, ev Event)
+	// OnMiss, if non-nil, is called when no usable response is cached for a request
+	OnMiss func(req *http.Request, ev Event)
+	// OnStore, if non-nil, is called when a response is written to the cache
+	OnStore func(req *http.Request, ev Event)
+	// OnRevalidate, if non-nil, is called when a stale cached response has been
+	// validated with the server, whether it was modified or not
+	OnRevalidate func(req *http.Request, ev Event)
+	// OnBypass, if non-nil, is called when the cache isn't used for a request
+	OnBypass func(req *http.Request, ev Event)
+	// OnDivergence, if non-nil, is called when a shadow request finds that
+	// the server answers differently than the cache, see ShadowSampleRate
+	OnDivergence func(req *http.Request, ev Event)
+	// ServeTransform, if non-nil, is applied to the responses served from
+	// the cache, e.g. to add a header telling the age of the data or to
+	// refresh dynamic fields. The responses are copies of the cached ones:
+	// the changes aren't stored.
+	ServeTransform func(req *http.Request, resp *http.Response)
+	// OnCacheError, if non-nil, is called when the cache fails to read or
+	// store a response: the reads fall back to the server, so that a
+	// failing cache would otherwise go unnoticed
+	OnCacheError func(req *http.Request, ev Event)
+
+	// ShadowSampleRate is the fraction, between 0 and 1, of the cache hits
+	// that are also sent to the server in the background, to compare its
+	// response with the cached one. It validates that caching an API is
+	// safe, reporting the differences to OnDivergence.
+	ShadowSampleRate float64
+	// ShadowTimeout bounds the duration of the shadow requests, defaulting
+	// to 30s
+	ShadowTimeout time.Duration
+
+	// Logger, if non-nil, receives a record for each cache decision with its
+	// reason, e.g. "stale: max-age exceeded by 42s"
+	Logger *slog.Logger
+	// LogLevel is the level of the records sent to Logger
+	LogLevel slog.Level
+	// If true, responses are given an extra header, X-Httpcache-Debug,
+	// detailing the inputs and the result of the freshness calculation
+	Debug bool
+	// Now, if set, returns the current time, instead of the real one, for
+	// the Transport to compute the age of the cached responses, e.g. in
+	// tests and simulations
+	Now func() time.Time
+	// Offline makes the Transport serve any cached response, whatever its
+	// freshness, without contacting the server, for clients on flaky links,
+	// except the stale responses with a must-revalidate directive.
+	// The requests that can't be served from the cache, such as those with
+	// a no-cache directive, are still sent, and fall back to the cached
+	// response on network errors. See also WithOffline.
+	Offline bool
+	// DefaultFreshness, MinFreshness, MaxFreshness, ForceCacheTTL,
+	// ForceCacheIgnoreNoStore, ServerErrorGrace, MaxEntrySize, Shared,
+	// SetCookie, LegacyExpires, MaxStale, AlwaysRevalidate, HTTPSOnly,
+	// RevalidationInterval, StaleIfSlow, TightDeadline, DeadlineMaxStale,
+	// Lifetime, Storable and StorableBody are the fields of the default
+	// Policy, see Policy
+	DefaultFreshness        time.Duration
+	MinFreshness            time.Duration
+	MaxFreshness            time.Duration
+	ForceCacheTTL           time.Duration
+	ForceCacheIgnoreNoStore bool
+	ServerErrorGrace        time.Duration
+	MaxEntrySize            int
+	Shared                  bool
+	SetCookie               SetCookieMode
+	LegacyExpires           bool
+	MaxStale                time.Duration
+	AlwaysRevalidate        bool
+	HTTPSOnly               bool
+	RevalidationInterval    time.Duration
+	StaleIfSlow             time.Duration
+	TightDeadline           time.Duration
+	DeadlineMaxStale        time.Duration
+	Lifetime                func(respHeaders http.Header, cc CacheControl) (time.Duration, bool)
+	Storable                func(req *http.Request, resp *http.Response, cc CacheControl) bool
+	StorableBody            func(req *http.Request, resp *http.Response, body []byte) bool
+	// KeyByAuthorization makes the Transport cache the responses to the
+	// requests with an Authorization header under keys folding a salted
+	// hash of its value, so that API clients using the tokens of several
+	// accounts get caching without leaking data across accounts.
+	// Invalidating a URL doesn't remove its responses cached for a token,
+	// unlike invalidating its prefix.
+	KeyByAuthorization bool
+	// AuthorizationSalt is the salt of the hashes of KeyByAuthorization.
+	// If empty, a random salt is generated for the Transport, and its
+	// entries in a persistent cache are lost when the process restarts.
+	AuthorizationSalt []byte
+	// Policies maps hosts, or URL prefixes such as
+	// "https://api.example.com/v1/", to the Policy of their requests,
+	// replacing the default one. The longest URL prefix matching a request
+	// wins over its host.
+	Policies map[string]Policy
+	// ConnectFailureTTL, if set, makes the Transport remember for this
+	// duration the hosts it failed to resolve or connect to, and fail the
+	// requests to them fast with ErrHostUnreachable, or serve the stale
+	// cached responses, instead of retrying the same doomed connections
+	ConnectFailureTTL time.Duration
+	// Via, if set, is the name, such as a host name or a pseudonym,
+	// identifying the Transport in the Via header of the requests it sends
+	// to the server and of its responses, as a shared cache should do for
+	// the downstream systems to detect it
+	Via string
+	// HopByHopHeaders lists the headers handled as hop-by-hop, in addition
+	// to the standard ones and those listed in Connection: they are
+	// neither stored nor updated by revalidations
+	HopByHopHeaders []string
+	// StripResponseHeaders lists the response headers removed before
+	// storing the responses, such as echoes of credentials or internal
+	// debugging headers. They are still sent to the client of the request
+	// fetching the response.
+	StripResponseHeaders []string
+	// StripRevalidationHeaders lists the request headers never forwarded to
+	// the server when revalidating a cached response
+	StripRevalidationHeaders []string
+	// StoreTimeout, if set, bounds the time spent waiting for the cache to
+	// store a response, in addition to the context of the request, so that
+	// an unresponsive backend doesn't block the clients closing the bodies
+	StoreTimeout time.Duration
+	// OnlyIfCachedMiss tells how the requests with an only-if-cached
+	// directive are answered when they can't be served from the cache
+	OnlyIfCachedMiss OnlyIfCachedMode
+	// Prefetch, if set, makes the Transport prefetch into the cache the
+	// resources referenced by the Link headers of the responses it fetches,
+	// with rel=prefetch or rel=preload
+	Prefetch *PrefetchOptions
+	// Middleware is a chain of decorators of the underlying Transport, such
+	// as authentication, retries or rate limiting, applied only to the
+	// requests sent to the servers, including the revalidations, and not
+	// to the responses served from the cache. The first one is the
+	// outermost.
+	Middleware []func(http.RoundTripper) http.RoundTripper
+	// OriginBudget, if set, caps the requests sent by the Transport on its
+	// own behalf: the revalidations made by Revalidate and the Scheduler,
+	// the prefetches, and the warming. The revalidations of the requests
+	// of the clients aren't capped.
+	OriginBudget *OriginBudget
+	// InvalidationBus, if set, broadcasts the invalidations made through
+	// the Transport to the other instances sharing or mirroring its cache.
+	// See ListenInvalidations.
+	InvalidationBus InvalidationBus
+	// NeverPersist, if set, tells which responses must never be persisted
+	// in the cache, whatever their headers allow, e.g. to comply with
+	// data-handling policies. The requests can also be marked with
+	// WithNeverPersist.
+	NeverPersist func(req *http.Request, resp *http.Response) bool
+	// SensitiveCache, if set, stores the responses that must never be
+	// persisted, and should be in memory, such as a MemoryCache. They
+	// aren't stored at all otherwise.
+	SensitiveCache Cache
+	// Audit, if set, records the reads, writes and purges of the cached
+	// entries, with the principal of their context, see WithPrincipal. The
+	// purges through the methods without a context have no principal, and
+	// those of the entries that weren't stored are recorded too.
+	Audit func(ctx context.Context, rec AuditRecord)
+
+	st *transportState
+}
+
+// transportState holds what a Transport accumulates while it is used.
+type transportState struct {
+	stats Stats
+	cache atomic.Pointer[Cache] // set by SetCache
+
+	mu           sync.Mutex
+	access       map[string]entryAccess // by key
+	hosts        map[string]*Stats      // by host
+	nextPrefetch time.Time
+	prefetching  int                            // workers prefetching links
+	prefetched   map[string]struct{}            // URLs being prefetched
+	tags         map[string]map[string]struct{} // tag -> keys
+	keyTags      map[string][]string            // key -> tags
+	hostFailures map[string]hostFailure
+	// authorizationSalt is the random salt used without AuthorizationSalt
+	authorizationSalt []byte
+
+	evictionMu      sync.Mutex
+	watched         EvictionNotifier              // the cache the Transport is registered to
+	evictionSubs    atomic.Pointer[[]evictionSub] // copied on write
+	nextEvictionSub int
+}
+
+// stateMu guards the lazy initialization of the state of all Transports.
+var stateMu sync.Mutex
+
+// state returns the state of t, creating it on first use.
+func (t *Transport) state() *transportState {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	if t.st == nil {
+		t.st = &transportState{}
+	}
+	return t.st
+}
+
+// SetCache replaces the Cache of t with c, atomically, while requests may be
+// in flight, e.g. to reload the configuration or fail over to another
+// backend. Unlike the assignment of the Cache field, it doesn't race with
+// the requests. The responses being read while the Cache is replaced may be
+// stored in either Cache. The subscriptions to the evictions follow c.
+func (t *Transport) SetCache(c Cache) {
+	st := t.state()
+	st.cache.Store(&c)
+	st.evictionMu.Lock()
+	watching := st.watched != nil
+	st.evictionMu.Unlock()
+	if watching {
+		t.watchEvictions()
+	}
+}
+
+// cache returns the Cache of t, set by SetCache or else the Cache field.
+func (t *Transport) cache() Cache {
+	if c := t.state().cache.Load(); c != nil {
+		return *c
+	}
+	return t.Cache
+}
+
+// An Event holds the metadata of a decision taken by the Transport, passed to
+// its hooks.
+type Event struct {
+	// Key is the cache key of the request
+	Key string
+	// Response is the cached response for OnHit and unmodified OnRevalidate,
+	// and the response of the server for OnStore, modified OnRevalidate and
+	// OnDivergence
+	Response *http.Response
+	// Modified reports, for OnRevalidate, whether the server sent a new
+	// response instead of 304 Not Modified
+	Modified bool
+	// Reason explains why the cache was bypassed, for OnBypass, how the
+	// responses differ, for OnDivergence, and why the cache couldn't be
+	// read, for OnMiss after a cache error
+	Reason string
+	// Err is the error of the cache, for OnCacheError
+	Err error
+	// Lookup is the time spent looking the request up in the cache
+	Lookup time.Duration
+	// RequestID is the ID of the request, see WithRequestID
+	RequestID string
+}
+
+// Stats holds aggregate statistics on the requests handled by a Transport,
+// to quantify the value of the cache.
+type Stats struct {
+	// Hits is the number of requests served from the cache without
+	// contacting the server
+	Hits int64
+	// Revalidated is the number of requests served from the cache after the
+	// server answered 304 Not Modified
+	Revalidated int64
+	// Fetched is the number of requests for which the server sent a full
+	// response
+	Fetched int64
+	// BytesSaved is the number of body bytes served from the cache instead of
+	// being downloaded from the server
+	BytesSaved int64
+	// RequestsSaved is the number of requests that didn't reach the server
+	RequestsSaved int64
+	// BytesFetched is the number of body bytes of the full responses sent
+	// by the server, as told by their Content-Length
+	BytesFetched int64
+	// CacheErrors is the number of failed reads and writes of the cache
+	CacheErrors int64
+	// Refreshes is the number of requests sent to the server by the
+	// Transport on its own behalf, to revalidate, prefetch or warm the
+	// cached responses
+	Refreshes int64
+}
+
+// Stats returns the statistics of the requests handled by t so far.
+func (t *Transport) Stats() Stats {
+	return t.state().stats.load()
+}
+
+// HostStats returns the statistics of the requests handled by t so far, by
+// host, e.g. to compare the savings of the cache for each origin.
+func (t *Transport) HostStats() map[string]Stats {
+	st := t.state()
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	stats := make(map[string]Stats, len(st.hosts))
+	for host, s := range st.hosts {
+		stats[host] = s.load()
+	}
+	return stats
+}
+
+// load returns a copy of s, which is updated atomically.
+func (s *Stats) load() Stats {
+	return Stats{
+		Hits:          atomic.LoadInt64(&s.Hits),
+		Revalidated:   atomic.LoadInt64(&s.Revalidated),
+		Fetched:       atomic.LoadInt64(&s.Fetched),
+		BytesSaved:    atomic.LoadInt64(&s.BytesSaved),
+		RequestsSaved: atomic.LoadInt64(&s.RequestsSaved),
+		BytesFetched:  atomic.LoadInt64(&s.BytesFetched),
+		CacheErrors:   atomic.LoadInt64(&s.CacheErrors),
+		Refreshes:     atomic.LoadInt64(&s.Refreshes),
+	}
+}
+
+// countServed updates s for resp, served from the cache, after a
+// revalidation if revalidated is true.
+func (s *Stats) countServed(resp *http.Response, revalidated bool) {
+	if revalidated {
+		atomic.AddInt64(&s.Revalidated, 1)
+	} else {
+		atomic.AddInt64(&s.Hits, 1)
+		atomic.AddInt64(&s.RequestsSaved, 1)
+	}
+	if resp.ContentLength > 0 {
+		atomic.AddInt64(&s.BytesSaved, resp.ContentLength)
+	}
+}
+
+// countFetched updates s for resp, a full response of the server.
+func (s *Stats) countFetched(resp *http.Response) {
+	atomic.AddInt64(&s.Fetched, 1)
+	if resp.ContentLength > 0 {
+		atomic.AddInt64(&s.BytesFetched, resp.ContentLength)
+	}
+}
+
+// countServed updates the statistics of t for resp, served from the cache
+// for req at key, after a revalidation if revalidated is true.
+func (t *Transport) countServed(req *http.Request, key string, resp *http.Response, revalidated bool) {
+	t.watchEvictions()
+	st := t.state()
+	st.mu.Lock()
+	access := st.access[key]
+	access.hits++
+	access.last = t.now()
+	st.setAccess(key, access)
+	host := st.host(req.URL.Host)
+	st.mu.Unlock()
+	st.stats.countServed(resp, revalidated)
+	host.countServed(resp, revalidated)
+}
+
+// host returns the statistics of the requests to host. st.mu must be held.
+func (st *transportState) host(host string) *Stats {
+	s, ok := st.hosts[host]
+	if !ok {
+		if st.hosts == nil {
+			st.hosts = make(map[string]*Stats)
+		}
+		s = &Stats{}
+		st.hosts[host] = s
+	}
+	return s
+}
+
+// entryAccess records how an entry has been served by a Transport.
+type entryAccess struct {
+	hits        int64     // number of times the entry was served
+	last        time.Time // last time it was served
+	revalidated time.Time // last time it was revalidated, if limited
+}
+
+// markRevalidated records that the entry stored at key is being revalidated,
+// see Policy.RevalidationInterval.
+func (t *Transport) markRevalidated(key string) {
+	t.watchEvictions()
+	st := t.state()
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	access := st.access[key]
+	access.revalidated = t.now()
+	st.setAccess(key, access)
+}
+
+// maxAccesses bounds the number of entries whose accesses are recorded, for
+// the caches that don't report their evictions.
+const maxAccesses = 100000
+
+// setAccess records access for the entry stored at key. Past maxAccesses
+// entries, it forgets the least recently served of a few of them to make
+// room. st.mu must be held.
+func (st *transportState) setAccess(key string, access entryAccess) {
+	if st.access == nil {
+		st.access = make(map[string]entryAccess)
+	}
+	if _, ok := st.access[key]; !ok && len(st.access) >= maxAccesses {
+		oldest, sampled := "", 0
+		for k, a := range st.access {
+			if oldest == "" || a.last.Before(st.access[oldest].last) {
+				oldest = k
+			}
+			if sampled++; sampled == 8 {
+				break
+			}
+		}
+		delete(st.access, oldest)
+	}
+	st.access[key] = access
+}
+
+// access returns how the entry stored at key has been served.
+func (t *Transport) access(key string) entryAccess {
+	st := t.state()
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.access[key]
+}
+
+// KeyStats holds the access statistics of a cached entry.
+type KeyStats struct {
+	Key        string    `json:"key"`
+	Hits       int64     `json:"hits"`        // number of times the entry was served
+	LastAccess time.Time `json:"last_access"` // last time the entry was served
+}
+
+// HotKeys returns the statistics of the n entries served most often by t,
+// hottest first, or of all of them if n is zero, to find the entries worth
+// pinning or those thrashing.
+func (t *Transport) HotKeys(n int) []KeyStats {
+	st := t.state()
+	st.mu.Lock()
+	keys := make([]KeyStats, 0, len(st.access))
+	for key, access := range st.access {
+		keys = append(keys, KeyStats{Key: key, Hits: access.hits, LastAccess: access.last})
+	}
+	st.mu.Unlock()
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Hits != keys[j].Hits {
+			return keys[i].Hits > keys[j].Hits
+		}
+		return keys[i].Key < keys[j].Key
+	})
+	if n > 0 && len(keys) > n {
+		keys = keys[:n]
+	}
+	return keys
+}
+
+// delete removes the entry stored at key from the cache, on behalf of the
+// principal of ctx, and forgets how it was served.
+func (t *Transport) delete(ctx context.Context, key string) {
+	t.cache().Delete(key)
+	if t.SensitiveCache != nil {
+		t.SensitiveCache.Delete(key)
+	}
+	st := t.state()
+	st.mu.Lock()
+	delete(st.access, key)
+	st.unindexTags(key)
+	st.mu.Unlock()
+	t.audit(ctx, AuditPurge, key, false)
+}
+
+// countFetched updates the statistics of t for resp, a full response
+// received from the server for req.
+func (t *Transport) countFetched(req *http.Request, resp *http.Response) {
+	st := t.state()
+	st.mu.Lock()
+	host := st.host(req.URL.Host)
+	st.mu.Unlock()
+	st.stats.countFetched(resp)
+	host.countFetched(resp)
+}
+
+// countRefresh updates the statistics of t for req, sent to the server on
+// behalf of t.
+func (t *Transport) countRefresh(req *http.Request) {
+	st := t.state()
+	st.mu.Lock()
+	host := st.host(req.URL.Host)
+	st.mu.Unlock()
+	atomic.AddInt64(&st.stats.Refreshes, 1)
+	atomic.AddInt64(&host.Refreshes, 1)
+}
+
+// hook calls fn with req and ev, completed with the request ID of req, if fn
+// is set.
+func hook(fn func(*http.Request, Event), req *http.Request, ev Event) {
+	if fn != nil {
+		ev.RequestID = RequestID(req.Context())
+		fn(req, ev)
+	}
 }
 
 // NewTransport returns a new Transport with the
@@ -122,6 +982,45 @@ func NewTransport(c Cache) *Transport {
 	return &Transport{Cache: c, MarkCachedResponses: true}
 }
 
+// Clone returns a copy of t, sharing its Cache, underlying Transport and
+// Logger, but none of its maps and slices, so that it can be tweaked, e.g.
+// for a tenant or a test, without racing with the requests in flight through
+// t. The statistics, hit counts and other state accumulated by t aren't
+// copied.
+func (t *Transport) Clone() *Transport {
+	t2 := *t
+	t2.Cache = t.cache()
+	t2.st = nil
+	if t.Policies != nil {
+		t2.Policies = make(map[string]Policy, len(t.Policies))
+		for pattern, p := range t.Policies {
+			t2.Policies[pattern] = p
+		}
+	}
+	if t.Prefetch != nil {
+		prefetch := *t.Prefetch
+		t2.Prefetch = &prefetch
+	}
+	t2.Middleware = append([]func(http.RoundTripper) http.RoundTripper(nil), t.Middleware...)
+	t2.HopByHopHeaders = append([]string(nil), t.HopByHopHeaders...)
+	t2.StripResponseHeaders = append([]string(nil), t.StripResponseHeaders...)
+	t2.StripRevalidationHeaders = append([]string(nil), t.StripRevalidationHeaders...)
+	return &t2
+}
+
+// origin returns the RoundTripper sending the requests to the servers: the
+// underlying Transport, decorated by the Middleware of t.
+func (t *Transport) origin() http.RoundTripper {
+	transport := t.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	for i := len(t.Middleware) - 1; i >= 0; i-- {
+		transport = t.Middleware[i](transport)
+	}
+	return transport
+}
+
 // Client returns an *http.Client that caches responses.
 func (t *Transport) Client() *http.Client {
 	return &http.Client{Transport: t}
@@ -135,105 +1034,523 @@ func (t *Transport) Client() *http.Client {
 // If there is a stale Response, then any validators it contains will be set on the new request
 // to give the server a chance to respond with NotModified. If this happens, then the cached Response
 // will be returned.
-func (t *Transport) RoundTrip(req *http.Request) (resp *http.Response, err error) {
-	cacheKey := cacheKey(req)
+//
+// If t.Via is set, it is added to the Via header of the requests sent to the
+// server and of the responses.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if RequestID(req.Context()) == "" {
+		req = req.WithContext(WithRequestID(req.Context(), newRequestID()))
+	}
+	resp, err := t.roundTrip(req)
+	if err == nil && t.Via != "" {
+		addVia(resp.Header, resp.ProtoMajor, resp.ProtoMinor, t.Via)
+	}
+	return resp, err
+}
+
+// roundTrip does the work of RoundTrip.
+// freshness evaluates the freshness of the response cached at key for req,
+// with the headers respHeaders, under policy. The stale responses are served
+// as fresh when t is offline, when the key was revalidated recently or when
+// the deadline of req is tight, unless they must be revalidated. RoundTrip,
+// Explain and Cached all decide with it.
+func (t *Transport) freshness(req *http.Request, key string, policy Policy, respHeaders http.Header) freshnessInfo {
+	info := policy.evaluateFreshness(t.clock(), respHeaders, req.Header)
+	if info.freshness != stale || policy.mustRevalidate(respHeaders) {
+		return info
+	}
+	var last time.Time
+	if policy.RevalidationInterval > 0 {
+		last = t.access(key).revalidated
+	}
+	switch {
+	case t.Offline || isOffline(req.Context()):
+		info.reason = "offline, " + info.reason
+	case !last.IsZero() && t.clock().since(last) < policy.RevalidationInterval:
+		info.reason = fmt.Sprintf("revalidated %s ago, ", t.clock().since(last).Round(time.Second)) + info.reason
+	case policy.tightDeadline(req.Context(), &info):
+		info.reason = "tight deadline, " + info.reason
+	default:
+		return info
+	}
+	info.freshness = fresh
+	info.staleServed = true
+	return info
+}
+
+func (t *Transport) roundTrip(req *http.Request) (resp *http.Response, err error) {
+	cacheKey := t.key(req)
+	policy := t.policy(req.URL)
 	cacheable := (req.Method == http.MethodGet || req.Method == http.MethodHead) && req.Header.Get("range") == ""
 	var cachedResp *http.Response
+	var lookup time.Duration
+	var decision string
+	var info *freshnessInfo
 	if cacheable {
-		cachedResp, err = CachedResponse(t.Cache, req)
+		start := time.Now()
+		cachedResp, err = t.cachedResponse(cacheKey, req)
+		lookup = time.Since(start)
 	}
-
-	transport := t.Transport
-	if transport == nil {
-		transport = http.DefaultTransport
+	var cacheErr error
+	if err != nil {
+		cacheErr, err = err, nil
+		t.cacheError(req, cacheKey, cacheErr)
+	}
+	trace := ContextClientTrace(req.Context())
+	if cacheable && trace != nil && trace.GotCacheLookup != nil {
+		trace.GotCacheLookup(cachedResp != nil && err == nil)
 	}
 
-	if cacheable && cachedResp != nil && err == nil {
-		if t.MarkCachedResponses {
-			cachedResp.Header.Set(XFromCache, "1")
-		}
+	transport := t.origin()
 
+	if cacheable && cachedResp != nil && err == nil {
 		// Can only use cached value if the new request doesn't Vary significantly
-		switch getFreshness(cachedResp.Header, req.Header) {
+		outReq := req
+		fi := t.freshness(req, cacheKey, policy, cachedResp.Header)
+		info = &fi
+		offline := t.Offline || isOffline(req.Context())
+		mustRevalidate := policy.mustRevalidate(cachedResp.Header)
+		// The marks are only meaningful in the cache, and are dropped when
+		// the entry is revalidated
+		stripMarks(cachedResp.Header)
+		switch info.freshness {
 		case fresh:
+			t.logDecision(req, cacheKey, "hit", info.String())
+			t.countServed(req, cacheKey, cachedResp, false)
+			hook(t.OnHit, req, Event{Key: cacheKey, Response: cachedResp, Lookup: lookup})
+			if trace != nil && trace.CacheHit != nil {
+				trace.CacheHit()
+			}
+			t.markFresh(cachedResp, info)
+			t.setDebugHeader(cachedResp, req, "hit", info, "", nil)
+			t.transform(req, cachedResp)
+			if t.ShadowSampleRate > 0 && rand.Float64() < t.ShadowSampleRate {
+				go t.shadow(req, cacheKey, transport)
+			}
 			return cachedResp, nil
 		case stale:
-			var req2 *http.Request
-			// Add validators if caller hasn't already done so
-			etag := cachedResp.Header.Get("etag")
-			if etag != "" && req.Header.Get("etag") == "" {
-				req2 = cloneRequest(req)
-				req2.Header.Set("if-none-match", etag)
-			}
-			lastModified := cachedResp.Header.Get("last-modified")
-			if lastModified != "" && req.Header.Get("last-modified") == "" {
-				if req2 == nil {
-					req2 = cloneRequest(req)
+			// Add the validators of the cached response
+			req2 := cloneRequest(req)
+			if conditional.AddValidators(req2.Header, cachedResp.Header) {
+				for _, name := range t.StripRevalidationHeaders {
+					req2.Header.Del(name)
 				}
-				req2.Header.Set("if-modified-since", lastModified)
-			}
-			if req2 != nil {
-				req = req2
+				outReq = req2
 			}
+		case transparent:
+			decision = "bypass"
+			t.logDecision(req, cacheKey, "bypass", info.String())
+			hook(t.OnBypass, req, Event{Key: cacheKey, Reason: info.reason, Lookup: lookup})
 		}
 
-		resp, err = transport.RoundTrip(req)
+		if onlyIfCached(req) {
+			// The server must not be contacted, and the cached entry kept
+			if mustRevalidate {
+				return t.onlyIfCachedMiss(req, cacheKey, nil)
+			}
+			return t.onlyIfCachedMiss(req, cacheKey, cachedResp)
+		}
+		if info.freshness == stale && policy.StaleIfSlow > 0 && !mustRevalidate && req.Context().Value(hedgeKey{}) == nil {
+			return t.hedge(req, cacheKey, cachedResp, policy.StaleIfSlow)
+		}
+		revalidating := outReq != req
+		if revalidating && policy.RevalidationInterval > 0 {
+			t.markRevalidated(cacheKey)
+		}
+		if revalidating && trace != nil && trace.RevalidationStart != nil {
+			trace.RevalidationStart()
+		}
+		resp, err = t.send(transport, outReq)
+		if revalidating && trace != nil && trace.RevalidationDone != nil {
+			trace.RevalidationDone(err == nil && resp.StatusCode != http.StatusNotModified, err)
+		}
 		if err != nil {
+			if (offline && isNetworkError(err)) || (t.ConnectFailureTTL > 0 && isConnectFailure(err)) {
+				if mustRevalidate {
+					// The stale response can't be served without
+					// validation, whatever happens to the server
+					t.logDecision(req, cacheKey, "gateway timeout", "must-revalidate response directive, "+err.Error())
+					return newGatewayTimeoutResponse(req), nil
+				}
+				t.logDecision(req, cacheKey, "stale on error", err.Error())
+				t.countServed(req, cacheKey, cachedResp, false)
+				t.mark(cachedResp, CacheStale)
+				t.transform(req, cachedResp)
+				return cachedResp, nil
+			}
 			return nil, err
 		}
 		if resp.StatusCode == http.StatusNotModified {
+			// The 304 response is dropped, releasing its connection
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
 			// Replace the 304 response with the one from cache, but update with some new headers
-			endToEndHeaders := getEndToEndHeaders(resp.Header)
+			endToEndHeaders := getEndToEndHeaders(resp.Header, t.HopByHopHeaders...)
 			for _, header := range endToEndHeaders {
 				cachedResp.Header[header] = resp.Header[header]
 			}
-			respBytes, err := httputil.DumpResponse(cachedResp, true)
+			respBytes, err := t.dumpResponse(cachedResp, withKey(withLifetime(req.Context(), policy.withVaried(nil, req, cachedResp.Header)), cacheKey), policy.stripped()...)
 			if err == nil {
-				t.Cache.Set(cacheKey, respBytes)
+				t.store(req, cacheKey, respBytes, cachedResp)
 			}
+			t.logDecision(req, cacheKey, "revalidated", info.String())
+			t.countServed(req, cacheKey, cachedResp, true)
+			hook(t.OnRevalidate, req, Event{Key: cacheKey, Response: cachedResp, Lookup: lookup})
+			t.mark(cachedResp, CacheRevalidated)
+			t.setDebugHeader(cachedResp, req, "revalidated", info, "", nil)
+			t.transform(req, cachedResp)
 			return cachedResp, nil
 		}
+		t.countFetched(req, resp)
+		if info.freshness == stale {
+			if revalidating {
+				decision = "modified"
+				t.logDecision(req, cacheKey, "modified", info.String())
+				hook(t.OnRevalidate, req, Event{Key: cacheKey, Response: resp, Modified: true, Lookup: lookup})
+			} else {
+				decision = "miss"
+				t.logDecision(req, cacheKey, "miss", info.String()+", no validators")
+				hook(t.OnMiss, req, Event{Key: cacheKey, Lookup: lookup})
+			}
+		}
 	} else {
-		reqCacheControl := parseCacheControl(req.Header)
-		if _, ok := reqCacheControl["only-if-cached"]; ok {
-			resp = newGatewayTimeoutResponse(req)
+		if cacheable {
+			decision = "miss"
+			reason := "no cached response"
+			if cacheErr != nil {
+				reason = "cache error: " + cacheErr.Error()
+			}
+			t.logDecision(req, cacheKey, "miss", reason)
+			ev := Event{Key: cacheKey, Lookup: lookup}
+			if cacheErr != nil {
+				ev.Reason = reason
+			}
+			hook(t.OnMiss, req, ev)
 		} else {
-			resp, err = transport.RoundTrip(req)
+			reason := bypassReason(req)
+			decision = "bypass"
+			t.logDecision(req, cacheKey, "bypass", reason)
+			hook(t.OnBypass, req, Event{Key: cacheKey, Reason: reason})
+		}
+		if onlyIfCached(req) {
+			resp, err = t.onlyIfCachedMiss(req, cacheKey, nil)
 			if err != nil {
 				return nil, err
 			}
+		} else {
+			resp, err = t.send(transport, req)
+			if err != nil {
+				return nil, err
+			}
+			t.countFetched(req, resp)
 		}
 	}
 
-	storeable := cacheable && canStore(resp.StatusCode,
-		parseCacheControl(req.Header),
-		parseCacheControl(resp.Header))
+	if t.Prefetch != nil && req.Method == http.MethodGet && resp.StatusCode == http.StatusOK {
+		t.prefetchLinks(req, resp.Header)
+	}
+
+	storeable := false
+	notStored := ""
+	if cacheable {
+		notStored = policy.cannotStoreReason(req, resp, t.now())
+		if notStored != "" {
+			t.logDecision(req, cacheKey, "not stored", notStored)
+		}
+		storeable = notStored == ""
+	}
+	t.setDebugHeader(resp, req, decision, info, notStored, cacheErr)
 	if storeable {
 		if req.Method == http.MethodGet && resp.StatusCode != http.StatusNoContent {
 			// Delay caching until EOF is reached.
 			resp.Body = &cachingReadCloser{
 				R: resp.Body,
 				OnClose: func(b []byte) {
+					if policy.StorableBody != nil && !policy.StorableBody(req, resp, b) {
+						t.logDecision(req, cacheKey, "not stored", "body refused by the policy")
+						t.delete(req.Context(), cacheKey)
+						return
+					}
 					resp := *resp
 					resp.Body = ioutil.NopCloser(bytes.NewReader(b))
-					respBytes, err := httputil.DumpResponse(&resp, true)
+					respBytes, err := t.dumpResponse(&resp, withKey(withLifetime(req.Context(), policy.marks(req, &resp)), cacheKey), policy.stripped()...)
 					if err == nil {
-						t.Cache.Set(cacheKey, respBytes)
+						t.store(req, cacheKey, respBytes, &resp)
 					}
 				},
 			}
 		} else {
-			respBytes, err := httputil.DumpResponse(resp, true)
+			respBytes, err := t.dumpResponse(resp, withKey(withLifetime(req.Context(), policy.marks(req, resp)), cacheKey), policy.stripped()...)
 			if err == nil {
-				t.Cache.Set(cacheKey, respBytes)
+				t.store(req, cacheKey, respBytes, resp)
 			}
 		}
 	} else if cachedResp != nil {
-		t.Cache.Delete(cacheKey)
+		t.delete(req.Context(), cacheKey)
 	}
 	return resp, nil
 }
 
+// store saves respBytes, the dump of resp, in the cache, passing a TTL hint if
+// the cache supports it, unless it is larger than allowed by the policy.
+func (t *Transport) store(req *http.Request, key string, respBytes []byte, resp *http.Response) {
+	policy := t.policy(req.URL)
+	if policy.MaxEntrySize > 0 && len(respBytes) > policy.MaxEntrySize {
+		t.logDecision(req, key, "not stored", fmt.Sprintf("entry of %d bytes larger than the maximum", len(respBytes)))
+		t.delete(req.Context(), key)
+		return
+	}
+	c := t.cache()
+	if t.neverPersist(req, resp) {
+		if t.SensitiveCache == nil {
+			t.logDecision(req, key, "not stored", "never persisted")
+			t.delete(req.Context(), key)
+			return
+		}
+		c.Delete(key)
+		c = t.SensitiveCache
+	} else if t.SensitiveCache != nil {
+		t.SensitiveCache.Delete(key)
+	}
+	ctx := req.Context()
+	if t.StoreTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.StoreTimeout)
+		defer cancel()
+	}
+	ttl := policy.ttlHint(t.clock(), resp.Header)
+	if lifetime, ok := contextLifetime(req.Context()); ok && policy.mustRevalidate(resp.Header) {
+		ttl = forcedTTLHint(t.clock(), resp.Header, lifetime)
+	}
+	if err := setIn(ctx, c, key, respBytes, ttl); err != nil {
+		t.logDecision(req, key, "not stored", err.Error())
+		if req.Context().Err() == nil {
+			t.cacheError(req, key, err)
+		}
+		return
+	}
+	t.indexTags(key, resp.Header)
+	t.audit(req.Context(), AuditWrite, key, false)
+	hook(t.OnStore, req, Event{Key: key, Response: resp})
+	if trace := ContextClientTrace(req.Context()); trace != nil && trace.StoredEntry != nil {
+		trace.StoredEntry(key)
+	}
+}
+
+// transform applies the ServeTransform of t to resp, served from the cache
+// for req.
+func (t *Transport) transform(req *http.Request, resp *http.Response) {
+	if t.ServeTransform != nil {
+		t.ServeTransform(req, resp)
+	}
+}
+
+// cacheError reports err, a failure of the cache reading or storing the
+// response to req at key.
+func (t *Transport) cacheError(req *http.Request, key string, err error) {
+	atomic.AddInt64(&t.state().stats.CacheErrors, 1)
+	t.logError(req.Context(), "cache error", err)
+	hook(t.OnCacheError, req, Event{Key: key, Err: err})
+}
+
+// mark sets the XFromCache header of resp to value, if t marks the cached
+// responses.
+func (t *Transport) mark(resp *http.Response, value string) {
+	if !t.MarkCachedResponses {
+		return
+	}
+	if t.LegacyCacheMarker {
+		value = "1"
+	}
+	resp.Header.Set(t.markerHeader(), value)
+}
+
+// set stores respBytes at key in the cache of t, with the TTL hint ttl if the
+// cache supports it, and stops waiting for the cache when ctx is done. The
+// write of a cache that isn't a ContextCache may still complete afterwards.
+func (t *Transport) set(ctx context.Context, key string, respBytes []byte, ttl time.Duration) error {
+	return setIn(ctx, t.cache(), key, respBytes, ttl)
+}
+
+// setIn is like set, storing respBytes in c.
+func setIn(ctx context.Context, c Cache, key string, respBytes []byte, ttl time.Duration) error {
+	if cc, ok := c.(ContextCache); ok {
+		return cc.SetContext(ctx, key, respBytes, ttl)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	set := func() {
+		if tc, ok := c.(TTLCache); ok {
+			tc.SetWithTTL(key, respBytes, ttl)
+		} else {
+			c.Set(key, respBytes)
+		}
+	}
+	if ctx.Done() == nil {
+		set()
+		return nil
+	}
+	done := make(chan struct{})
+	go func() {
+		set()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// markFresh marks resp, served from the cache without contacting the server,
+// according to info, its freshness.
+func (t *Transport) markFresh(resp *http.Response, info *freshnessInfo) {
+	if info.grace {
+		t.mark(resp, CacheGrace)
+	} else if info.staleServed {
+		t.mark(resp, CacheStale)
+	} else {
+		t.mark(resp, CacheHit)
+	}
+}
+
+// markerHeader returns the name of the header marking cached responses.
+func (t *Transport) markerHeader() string {
+	if t.CacheMarkerHeader != "" {
+		return t.CacheMarkerHeader
+	}
+	return XFromCache
+}
+
+// setDebugHeader describes on resp the decision taken for req, when t is in
+// debug mode. info holds the freshness of the cached response if there was
+// one, notStored why resp couldn't be stored, and cacheErr why the cache
+// couldn't be read.
+func (t *Transport) setDebugHeader(resp *http.Response, req *http.Request, decision string, info *freshnessInfo, notStored string, cacheErr error) {
+	if !t.Debug {
+		return
+	}
+	v := "decision=" + decision
+	if info != nil {
+		v += fmt.Sprintf("; freshness=%s; age=%s; lifetime=%s; reason=%q",
+			freshnessName(info.freshness), info.age, info.lifetime, info.reason)
+	}
+	if notStored != "" {
+		v += fmt.Sprintf("; not-stored=%q", notStored)
+	}
+	if cacheErr != nil {
+		v += fmt.Sprintf("; cache-error=%q", cacheErr.Error())
+	}
+	v += fmt.Sprintf("; response-directives=%q; request-directives=%q",
+		resp.Header.Get("Cache-Control"), req.Header.Get("Cache-Control"))
+	resp.Header.Set(XHttpcacheDebug, v)
+}
+
+// dumpResponse returns the representation of resp to store in the cache,
+// without the headers added by t, the hop-by-hop ones and the stripped ones,
+// and with the internal marks, including the time it is stored unless it is
+// already marked.
+func (t *Transport) dumpResponse(resp *http.Response, marks http.Header, stripped ...string) ([]byte, error) {
+	r := *resp
+	r.Header = resp.Header.Clone()
+	r.Header.Del(t.markerHeader())
+	r.Header.Del(XHttpcacheDebug)
+	if t.Via != "" {
+		removeVia(r.Header, resp.ProtoMajor, resp.ProtoMinor, t.Via)
+	}
+	for name := range hopByHopHeaders(resp.Header, t.HopByHopHeaders...) {
+		r.Header.Del(name)
+	}
+	if r.Header.Get(xStored) == "" {
+		r.Header.Set(xStored, t.now().UTC().Format(time.RFC3339Nano))
+	}
+	for _, name := range stripped {
+		r.Header.Del(name)
+	}
+	for _, name := range t.StripResponseHeaders {
+		r.Header.Del(name)
+	}
+	if r.Uncompressed {
+		r.Header.Set(xUncompressed, "1")
+	}
+	for name, values := range marks {
+		r.Header[name] = values
+	}
+	if r.ProtoMajor >= 2 && r.ContentLength < 0 {
+		// Without a length, the HTTP/1.1 serialization would close the
+		// connection to delimit the body, so chunk it instead
+		r.TransferEncoding = []string{"chunked"}
+	}
+	b, err := httputil.DumpResponse(&r, true)
+	resp.Body = r.Body
+	return b, err
+}
+
+// logDecision records the decision taken for req and its reason.
+func (t *Transport) logDecision(req *http.Request, key, decision, reason string) {
+	if t.Logger == nil {
+		return
+	}
+	t.Logger.LogAttrs(req.Context(), t.LogLevel, "httpcache decision",
+		slog.String("decision", decision),
+		slog.String("reason", reason),
+		slog.String("method", req.Method),
+		slog.String("url", req.URL.String()),
+		slog.String("key", key),
+		slog.String("request_id", RequestID(req.Context())),
+	)
+}
+
+// freshnessName returns the name of a freshness.
+func freshnessName(freshness int) string {
+	switch freshness {
+	case fresh:
+		return "fresh"
+	case transparent:
+		return "transparent"
+	default:
+		return "stale"
+	}
+}
+
+// String returns the freshness and the reason it was decided.
+func (f freshnessInfo) String() string {
+	return freshnessName(f.freshness) + ": " + f.reason
+}
+
+// bypassReason explains why req can't be served from the cache.
+func bypassReason(req *http.Request) string {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return "uncacheable method " + req.Method
+	}
+	return "range request"
+}
+
+// clock returns the timer of t.
+func (t *Transport) clock() timer {
+	if t.Now != nil {
+		return nowClock(t.Now)
+	}
+	return clock
+}
+
+// now returns the current time for t.
+func (t *Transport) now() time.Time {
+	if t.Now != nil {
+		return t.Now()
+	}
+	return time.Now()
+}
+
+// nowClock is a timer based on a function returning the current time.
+type nowClock func() time.Time
+
+func (c nowClock) since(d time.Time) time.Duration {
+	return c().Sub(d)
+}
+
 type realClock struct{}
 
 func (c *realClock) since(d time.Time) time.Duration {
@@ -253,57 +1570,128 @@ var clock timer = &realClock{}
 // stale indicates that the response needs validating before it is returned
 // transparent indicates the response should not be used to fulfil the request
 //
-// Because this is only a private cache, 'public' and 'private' in cache-control aren't
-// signficant. Similarly, smax-age isn't used.
+// It evaluates the freshness for a private cache: 'public' and 'private' in
+// cache-control aren't significant, and s-maxage isn't used. The shared cache
+// refuses to store the private responses and evaluates s-maxage, see Policy.Shared.
 func getFreshness(respHeaders, reqHeaders http.Header) (freshness int) {
-	respCacheControl := parseCacheControl(respHeaders)
-	reqCacheControl := parseCacheControl(reqHeaders)
+	return evaluateFreshness(respHeaders, reqHeaders).freshness
+}
+
+// freshnessInfo details how the freshness of a cached response was decided.
+type freshnessInfo struct {
+	freshness int
+	age       time.Duration // current age of the response, zero if unknown
+	lifetime  time.Duration // freshness lifetime the age was compared to
+	reason    string        // explanation of the decision, for humans
+	// staleServed is set when the response is fresh only because the request
+	// accepts stale responses
+	staleServed bool
+	// grace is set for a server error cached for the grace period
+	grace bool
+}
+
+// evaluateFreshness does the work of getFreshness, keeping track of the
+// inputs and the reason of the decision.
+func evaluateFreshness(respHeaders, reqHeaders http.Header) freshnessInfo {
+	return evaluateFreshnessWith(respHeaders, reqHeaders, freshnessOptions{})
+}
+
+// freshnessOptions tune the evaluation of freshness, see Policy.
+type freshnessOptions struct {
+	defaultLifetime time.Duration // lifetime of the responses without explicit one
+	minLifetime     time.Duration // clamps of the lifetime, if not zero
+	maxLifetime     time.Duration
+	shared          bool          // whether s-maxage applies
+	legacyExpires   bool          // whether an invalid Expires means a zero lifetime
+	maxStale        time.Duration // staleness accepted without max-stale request directive
+	clock           timer         // if nil, the package clock is used
+	// lifetime, if not nil, overrides the lifetime of the responses
+	lifetime func(respHeaders http.Header, cc CacheControl) (time.Duration, bool)
+}
+
+// since returns the time elapsed since d, according to the clock of opts.
+func (opts freshnessOptions) since(d time.Time) time.Duration {
+	if opts.clock != nil {
+		return opts.clock.since(d)
+	}
+	return clock.since(d)
+}
+
+// evaluateFreshnessWith is like evaluateFreshness, with the options opts.
+func evaluateFreshnessWith(respHeaders, reqHeaders http.Header, opts freshnessOptions) freshnessInfo {
+	respCacheControl := ParseCacheControl(respHeaders)
+	reqCacheControl := ParseCacheControl(reqHeaders)
 	if _, ok := reqCacheControl["no-cache"]; ok {
-		return transparent
+		return freshnessInfo{freshness: transparent, reason: "no-cache request directive"}
 	}
 	if _, ok := respCacheControl["no-cache"]; ok {
-		return stale
+		return freshnessInfo{freshness: stale, reason: "no-cache response directive"}
+	}
+	if _, ok := reqCacheControl["only-if-cached"]; ok && !mustRevalidate(respCacheControl, opts.shared) {
+		return freshnessInfo{freshness: fresh, reason: "only-if-cached request directive"}
 	}
-	if _, ok := reqCacheControl["only-if-cached"]; ok {
-		return fresh
+	if respHeaders.Get(xSoftPurged) != "" {
+		return freshnessInfo{freshness: stale, reason: "soft purged"}
 	}
 
 	date, ok := parseDate(respHeaders)
 	if !ok {
-		return stale
+		return freshnessInfo{freshness: stale, reason: "missing or invalid Date header"}
+	}
+	info := freshnessInfo{age: opts.since(date)}
+	currentAge := info.age
+	var hookLifetime time.Duration
+	hooked := false
+	if opts.lifetime != nil {
+		hookLifetime, hooked = opts.lifetime(respHeaders, respCacheControl)
+	}
+	if !hooked && !opts.legacyExpires && invalidExpires(respHeaders, respCacheControl) &&
+		!(opts.shared && respCacheControl.Has("s-maxage")) &&
+		(!acceptsAnyStale(reqCacheControl) || mustRevalidate(respCacheControl, opts.shared)) {
+		// An invalid Expires, such as 0, means already expired, which no
+		// clamp nor request directive but an unbounded max-stale can make
+		// up for
+		info.freshness = stale
+		info.reason = "invalid Expires header"
+		return info
 	}
-	currentAge := clock.since(date)
-
-	var err error
-	var lifetime time.Duration
-	var zeroDuration time.Duration
 
-	// If a response includes both an Expires header and a max-age directive,
-	// the max-age directive overrides the Expires header, even if the Expires header is more restrictive.
-	if maxAge, ok := respCacheControl["max-age"]; ok {
-		lifetime, err = parseDuration(maxAge)
-		if err != nil {
-			lifetime = zeroDuration
-		}
-	} else {
-		expiresHeader := respHeaders.Get("expires")
-		if expiresHeader != "" {
-			var expires time.Time
-			expires, err = time.Parse(http.TimeFormat, expiresHeader)
-			if err != nil {
-				lifetime = zeroDuration
-			} else {
-				lifetime = expires.Sub(date)
-			}
-		}
+	var source string
+	info.lifetime, ok = responseLifetime(respHeaders, respCacheControl, date)
+	switch {
+	case hooked:
+		info.lifetime = hookLifetime
+		source = "policy lifetime"
+	case opts.shared && respCacheControl.Has("s-maxage"):
+		info.lifetime, _ = parseDuration(respCacheControl["s-maxage"])
+		source = "s-maxage"
+	case !ok && opts.defaultLifetime > 0:
+		info.lifetime = opts.defaultLifetime
+		source = "default freshness"
+	case !ok:
+		source = "no explicit lifetime"
+	case respCacheControl.Has("max-age"):
+		source = "max-age"
+	default:
+		source = "Expires"
+	}
+	if opts.minLifetime > 0 && info.lifetime < opts.minLifetime {
+		info.lifetime = opts.minLifetime
+		source += " clamped to the minimum"
+	}
+	if opts.maxLifetime > 0 && info.lifetime > opts.maxLifetime {
+		info.lifetime = opts.maxLifetime
+		source += " clamped to the maximum"
 	}
 
 	if maxAge, ok := reqCacheControl["max-age"]; ok {
 		// the client is willing to accept a response whose age is no greater than the specified time in seconds
-		lifetime, err = parseDuration(maxAge)
+		var err error
+		info.lifetime, err = parseDuration(maxAge)
 		if err != nil {
-			lifetime = zeroDuration
+			info.lifetime = 0
 		}
+		source = "request max-age"
 	}
 
 	if minfresh, ok := reqCacheControl["min-fresh"]; ok {
@@ -314,7 +1702,10 @@ func getFreshness(respHeaders, reqHeaders http.Header) (freshness int) {
 		}
 	}
 
-	if maxstale, ok := reqCacheControl["max-stale"]; ok {
+	// The responses that must be revalidated are never served stale,
+	// whatever the request or the policy accepts
+	staleAllowed := !mustRevalidate(respCacheControl, opts.shared)
+	if maxstale, ok := reqCacheControl["max-stale"]; ok && staleAllowed {
 		// Indicates that the client is willing to accept a response that has exceeded its expiration time.
 		// If max-stale is assigned a value, then the client is willing to accept a response that has exceeded
 		// its expiration time by no more than the specified number of seconds.
@@ -324,24 +1715,125 @@ func getFreshness(respHeaders, reqHeaders http.Header) (freshness int) {
 		// but that seems like a  hassle, and is it actually useful? If so, then there needs to be a different
 		// return-value available here.
 		if maxstale == "" {
-			return fresh
+			info.freshness = fresh
+			info.staleServed = info.lifetime <= info.age
+			info.reason = "max-stale request directive accepts any age"
+			return info
 		}
 		maxstaleDuration, err := parseDuration(maxstale)
 		if err == nil {
 			currentAge = currentAge - maxstaleDuration
 		}
+	} else if staleAllowed && opts.maxStale > 0 && !reqCacheControl.Has("max-age") && !reqCacheControl.Has("min-fresh") {
+		// The policy accepts stale responses like a max-stale request
+		// directive, unless the request or the server is stricter
+		currentAge -= opts.maxStale
+		source += " extended by the maximum staleness"
 	}
 
-	if lifetime > currentAge {
-		return fresh
+	if info.lifetime > currentAge {
+		info.freshness = fresh
+		info.staleServed = info.lifetime <= info.age
+		info.reason = fmt.Sprintf("%s left of %s", info.lifetime-currentAge, source)
+		return info
 	}
 
-	return stale
+	info.freshness = stale
+	info.reason = fmt.Sprintf("%s exceeded by %s", source, currentAge-info.lifetime)
+	return info
 }
 
-func getEndToEndHeaders(respHeaders http.Header) []string {
+// responseLifetime returns the freshness lifetime assigned by the origin to a
+// response generated at date, and false if the response doesn't carry any
+// explicit freshness information.
+func responseLifetime(respHeaders http.Header, respCacheControl CacheControl, date time.Time) (lifetime time.Duration, ok bool) {
+	// If a response includes both an Expires header and a max-age directive,
+	// the max-age directive overrides the Expires header, even if the Expires header is more restrictive.
+	if maxAge, ok := respCacheControl["max-age"]; ok {
+		lifetime, err := parseDuration(maxAge)
+		if err != nil {
+			return 0, true
+		}
+		return lifetime, true
+	}
+	expiresHeader := respHeaders.Get("expires")
+	if expiresHeader == "" {
+		return 0, false
+	}
+	expires, err := http.ParseTime(expiresHeader)
+	if err != nil {
+		return 0, true
+	}
+	return expires.Sub(date), true
+}
+
+// invalidExpires reports whether the freshness lifetime of a response comes
+// from an Expires header that can't be parsed, such as 0.
+func invalidExpires(respHeaders http.Header, respCacheControl CacheControl) bool {
+	if respCacheControl.Has("max-age") {
+		return false
+	}
+	expiresHeader := respHeaders.Get("expires")
+	if expiresHeader == "" {
+		return false
+	}
+	_, err := http.ParseTime(expiresHeader)
+	return err != nil
+}
+
+// mustRevalidate reports whether a response has a directive forbidding to
+// serve it stale without validation, in a shared cache or not.
+func mustRevalidate(respCacheControl CacheControl, shared bool) bool {
+	return respCacheControl.Has("must-revalidate") || (shared && respCacheControl.Has("proxy-revalidate"))
+}
+
+// acceptsAnyStale reports whether a request has a max-stale directive without
+// value, accepting stale responses of any age.
+func acceptsAnyStale(reqCacheControl CacheControl) bool {
+	maxStale, ok := reqCacheControl["max-stale"]
+	return ok && maxStale == ""
+}
+
+// ttlHint returns how long a response can be of any use to the cache: the
+// remaining freshness lifetime for a response without validators that must be
+// revalidated once stale, as it can't be. It returns zero when the response
+// should be kept until evicted, including when it may still be served stale,
+// e.g. to the requests with a max-stale directive or offline.
+func ttlHint(respHeaders http.Header) time.Duration {
+	return ttlHintWith(clock, respHeaders, false)
+}
+
+// ttlHintWith is like ttlHint, according to the timer c, for a shared cache
+// if shared is true.
+func ttlHintWith(c timer, respHeaders http.Header, shared bool) time.Duration {
+	if respHeaders.Get("etag") != "" || respHeaders.Get("last-modified") != "" {
+		return 0
+	}
+	respCacheControl := ParseCacheControl(respHeaders)
+	if !mustRevalidate(respCacheControl, shared) {
+		return 0
+	}
+	date, ok := parseDate(respHeaders)
+	if !ok {
+		return 0
+	}
+	lifetime, ok := responseLifetime(respHeaders, respCacheControl, date)
+	if !ok {
+		return 0
+	}
+	remaining := lifetime - c.since(date)
+	if remaining <= 0 {
+		return 0
+	}
+	return remaining
+}
+
+// hopByHopHeaders returns the set of the hop-by-hop headers of respHeaders,
+// which are only meaningful for a single connection, including the extra
+// ones.
+func hopByHopHeaders(respHeaders http.Header, extra ...string) map[string]struct{} {
 	// These headers are always hop-by-hop
-	hopByHopHeaders := map[string]struct{}{
+	headers := map[string]struct{}{
 		"Connection":          {},
 		"Keep-Alive":          {},
 		"Proxy-Authenticate":  {},
@@ -352,12 +1844,20 @@ func getEndToEndHeaders(respHeaders http.Header) []string {
 		"Upgrade":             {},
 	}
 
+	for _, name := range extra {
+		headers[http.CanonicalHeaderKey(name)] = struct{}{}
+	}
 	for _, extra := range strings.Split(respHeaders.Get("connection"), ",") {
 		// any header listed in connection, if present, is also considered hop-by-hop
 		if strings.Trim(extra, " ") != "" {
-			hopByHopHeaders[http.CanonicalHeaderKey(extra)] = struct{}{}
+			headers[http.CanonicalHeaderKey(extra)] = struct{}{}
 		}
 	}
+	return headers
+}
+
+func getEndToEndHeaders(respHeaders http.Header, extra ...string) []string {
+	hopByHopHeaders := hopByHopHeaders(respHeaders, extra...)
 	endToEndHeaders := []string{}
 	for respHeader := range respHeaders {
 		if _, ok := hopByHopHeaders[respHeader]; !ok {
@@ -367,17 +1867,23 @@ func getEndToEndHeaders(respHeaders http.Header) []string {
 	return endToEndHeaders
 }
 
-func canStore(code int, reqCacheControl, respCacheControl cacheControl) (canStore bool) {
+func canStore(code int, reqCacheControl, respCacheControl CacheControl) (canStore bool) {
+	return cannotStoreReason(code, reqCacheControl, respCacheControl) == ""
+}
+
+// cannotStoreReason explains why a response can't be stored, or returns an
+// empty string if it can.
+func cannotStoreReason(code int, reqCacheControl, respCacheControl CacheControl) string {
 	if _, ok := cacheableResponseCodes[code]; !ok {
-		return false
+		return "uncacheable status code " + strconv.Itoa(code)
 	}
 	if _, ok := respCacheControl["no-store"]; ok {
-		return false
+		return "no-store response directive"
 	}
 	if _, ok := reqCacheControl["no-store"]; ok {
-		return false
+		return "no-store request directive"
 	}
-	return true
+	return ""
 }
 
 func newGatewayTimeoutResponse(req *http.Request) *http.Response {
@@ -405,26 +1911,55 @@ func cloneRequest(r *http.Request) *http.Request {
 	return r2
 }
 
-type cacheControl map[string]string
+// CacheControl holds the directives of a Cache-Control header, mapping their
+// lower-cased names to their unquoted values, empty for the directives
+// without any. It includes the extension directives unknown to the
+// Transport, for the hooks of a Policy to act on them.
+type CacheControl map[string]string
 
-func parseCacheControl(headers http.Header) cacheControl {
-	cc := cacheControl{}
-	ccHeader := headers.Get("Cache-Control")
-	for _, part := range strings.Split(ccHeader, ",") {
-		part = strings.Trim(part, " ")
-		if part == "" {
-			continue
-		}
-		if strings.ContainsRune(part, '=') {
-			keyval := strings.Split(part, "=")
-			cc[strings.Trim(keyval[0], " ")] = strings.Trim(keyval[1], ",")
-		} else {
-			cc[part] = ""
+// Has reports whether directive is present.
+func (cc CacheControl) Has(directive string) bool {
+	_, ok := cc[directive]
+	return ok
+}
+
+// ParseCacheControl parses the Cache-Control headers of headers.
+func ParseCacheControl(headers http.Header) CacheControl {
+	cc := CacheControl{}
+	for _, ccHeader := range headers.Values("Cache-Control") {
+		for _, part := range splitDirectives(ccHeader) {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			name, value, _ := strings.Cut(part, "=")
+			value = strings.TrimSpace(value)
+			if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+				value = value[1 : len(value)-1]
+			}
+			cc[strings.ToLower(strings.TrimSpace(name))] = value
 		}
 	}
 	return cc
 }
 
+// splitDirectives splits a Cache-Control header value on the commas
+// separating its directives, ignoring those inside quoted strings.
+func splitDirectives(value string) []string {
+	var parts []string
+	inQuotes, start := false, 0
+	for i, c := range value {
+		switch {
+		case c == '"':
+			inQuotes = !inQuotes
+		case c == ',' && !inQuotes:
+			parts = append(parts, value[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, value[start:])
+}
+
 // cachingReadCloser is a wrapper around ReadCloser R that calls OnClose
 // handler with a full copy of the content read from R when close is
 // called.
@@ -480,7 +2015,7 @@ func parseDate(respHeaders http.Header) (date time.Time, ok bool) {
 		return
 	}
 	var err error
-	date, err = time.Parse(http.TimeFormat, dateHeader)
+	date, err = http.ParseTime(dateHeader)
 	ok = (err == nil)
 	return
 }
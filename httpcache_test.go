@@ -2,15 +2,21 @@ package httpcache
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
 	"flag"
 	"io"
 	"io/ioutil"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"net/http/httputil"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -330,8 +336,8 @@ func TestDontStorePartialRangeInCache(t *testing.T) {
 		if resp.StatusCode != http.StatusOK {
 			t.Errorf("response status code isn't 200 OK: %v", resp.StatusCode)
 		}
-		if resp.Header.Get(XFromCache) != "1" {
-			t.Errorf(`XFromCache header isn't "1": %v`, resp.Header.Get(XFromCache))
+		if resp.Header.Get(XFromCache) != CacheRevalidated {
+			t.Errorf(`XFromCache header isn't %q: %v`, CacheRevalidated, resp.Header.Get(XFromCache))
 		}
 	}
 	{
@@ -445,8 +451,8 @@ func TestGetOnlyIfCachedHit(t *testing.T) {
 			t.Fatal(err)
 		}
 		defer resp.Body.Close()
-		if resp.Header.Get(XFromCache) != "1" {
-			t.Fatalf(`XFromCache header isn't "1": %v`, resp.Header.Get(XFromCache))
+		if resp.Header.Get(XFromCache) != CacheHit {
+			t.Fatalf(`XFromCache header isn't %q: %v`, CacheHit, resp.Header.Get(XFromCache))
 		}
 		if resp.StatusCode != http.StatusOK {
 			t.Fatalf("response status code isn't 200 OK: %v", resp.StatusCode)
@@ -558,8 +564,8 @@ func TestGetWithEtag(t *testing.T) {
 			t.Fatal(err)
 		}
 		defer resp.Body.Close()
-		if resp.Header.Get(XFromCache) != "1" {
-			t.Fatalf(`XFromCache header isn't "1": %v`, resp.Header.Get(XFromCache))
+		if resp.Header.Get(XFromCache) != CacheRevalidated {
+			t.Fatalf(`XFromCache header isn't %q: %v`, CacheRevalidated, resp.Header.Get(XFromCache))
 		}
 		// additional assertions to verify that 304 response is converted properly
 		if resp.StatusCode != http.StatusOK {
@@ -597,8 +603,8 @@ func TestGetWithLastModified(t *testing.T) {
 			t.Fatal(err)
 		}
 		defer resp.Body.Close()
-		if resp.Header.Get(XFromCache) != "1" {
-			t.Fatalf(`XFromCache header isn't "1": %v`, resp.Header.Get(XFromCache))
+		if resp.Header.Get(XFromCache) != CacheRevalidated {
+			t.Fatalf(`XFromCache header isn't %q: %v`, CacheRevalidated, resp.Header.Get(XFromCache))
 		}
 	}
 }
@@ -630,8 +636,8 @@ func TestGetVaryUnused(t *testing.T) {
 			t.Fatal(err)
 		}
 		defer resp.Body.Close()
-		if resp.Header.Get(XFromCache) != "1" {
-			t.Fatalf(`XFromCache header isn't "1": %v`, resp.Header.Get(XFromCache))
+		if resp.Header.Get(XFromCache) != CacheHit {
+			t.Fatalf(`XFromCache header isn't %q: %v`, CacheHit, resp.Header.Get(XFromCache))
 		}
 	}
 }
@@ -661,8 +667,8 @@ func TestUpdateFields(t *testing.T) {
 			t.Fatal(err)
 		}
 		defer resp.Body.Close()
-		if resp.Header.Get(XFromCache) != "1" {
-			t.Fatalf(`XFromCache header isn't "1": %v`, resp.Header.Get(XFromCache))
+		if resp.Header.Get(XFromCache) != CacheRevalidated {
+			t.Fatalf(`XFromCache header isn't %q: %v`, CacheRevalidated, resp.Header.Get(XFromCache))
 		}
 		counter2 = resp.Header.Get("x-counter")
 	}
@@ -703,13 +709,13 @@ func TestCachedErrorsKeepStatus(t *testing.T) {
 func TestParseCacheControl(t *testing.T) {
 	resetTest()
 	h := http.Header{}
-	for range parseCacheControl(h) {
+	for range ParseCacheControl(h) {
 		t.Fatal("cacheControl should be empty")
 	}
 
 	h.Set("cache-control", "no-cache")
 	{
-		cc := parseCacheControl(h)
+		cc := ParseCacheControl(h)
 		if _, ok := cc["foo"]; ok {
 			t.Error(`Value "foo" shouldn't exist`)
 		}
@@ -723,7 +729,7 @@ func TestParseCacheControl(t *testing.T) {
 	}
 	h.Set("cache-control", "no-cache, max-age=3600")
 	{
-		cc := parseCacheControl(h)
+		cc := ParseCacheControl(h)
 		noCache, ok := cc["no-cache"]
 		if !ok {
 			t.Fatalf(`"no-cache" value isn't set`)
@@ -737,6 +743,23 @@ func TestParseCacheControl(t *testing.T) {
 	}
 }
 
+func TestParseCacheControlExtensions(t *testing.T) {
+	h := http.Header{"Cache-Control": {`No-Cache="Set-Cookie, X-Token", max-age=60`, `x-vendor-ttl = 120, x-flag`}}
+	cc := ParseCacheControl(h)
+	want := CacheControl{"no-cache": "Set-Cookie, X-Token", "max-age": "60", "x-vendor-ttl": "120", "x-flag": ""}
+	if len(cc) != len(want) {
+		t.Fatalf("got %v, want %v", cc, want)
+	}
+	for name, value := range want {
+		if got, ok := cc[name]; !ok || got != value {
+			t.Fatalf("got %v, want %v", cc, want)
+		}
+	}
+	if !cc.Has("x-flag") || cc.Has("x-other") {
+		t.Fatal("Has doesn't report the present directives")
+	}
+}
+
 func TestNoCacheRequestExpiration(t *testing.T) {
 	resetTest()
 	respHeaders := http.Header{}
@@ -868,6 +891,43 @@ func TestMinFreshWithExpires(t *testing.T) {
 	}
 }
 
+func TestInvalidExpires(t *testing.T) {
+	resetTest()
+	now := time.Now().UTC()
+	for _, expires := range []string{"0", "tomorrow"} {
+		respHeaders := http.Header{}
+		respHeaders.Set("date", now.Format(http.TimeFormat))
+		respHeaders.Set("expires", expires)
+		reqHeaders := http.Header{}
+		reqHeaders.Set("cache-control", "max-stale=60")
+		opts := freshnessOptions{minLifetime: time.Minute}
+		if info := evaluateFreshnessWith(respHeaders, reqHeaders, opts); info.freshness != stale {
+			t.Fatalf("Expires %q: got %s, want already expired", expires, info)
+		}
+		opts.legacyExpires = true
+		if info := evaluateFreshnessWith(respHeaders, reqHeaders, opts); info.freshness != fresh {
+			t.Fatalf("Expires %q: got %s with the legacy handling", expires, info)
+		}
+		reqHeaders.Set("cache-control", "max-stale")
+		if getFreshness(respHeaders, reqHeaders) != fresh {
+			t.Fatalf("Expires %q: unbounded max-stale not honored", expires)
+		}
+	}
+}
+
+func TestObsoleteDateFormats(t *testing.T) {
+	resetTest()
+	now := time.Now().UTC()
+	for _, layout := range []string{time.RFC850, time.ANSIC} {
+		respHeaders := http.Header{}
+		respHeaders.Set("date", now.Format(layout))
+		respHeaders.Set("expires", now.Add(time.Hour).Format(layout))
+		if info := evaluateFreshness(respHeaders, http.Header{}); info.freshness != fresh {
+			t.Fatalf("%s: got %s", layout, info)
+		}
+	}
+}
+
 func TestEmptyMaxStale(t *testing.T) {
 	resetTest()
 	now := time.Now().UTC()
@@ -970,6 +1030,102 @@ func TestGetEndToEndHeaders(t *testing.T) {
 	}
 }
 
+func TestHopByHopHeaders(t *testing.T) {
+	resetTest()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Keep-Alive", "timeout=5")
+		w.Header().Set("X-Hop", r.Header.Get("If-None-Match"))
+		if r.Header.Get("If-None-Match") == `"1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"1"`)
+		w.Write([]byte("body"))
+	}))
+	defer server.Close()
+
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	tp.HopByHopHeaders = []string{"x-hop"}
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := tp.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		if i == 1 && resp.Header.Get(XFromCache) != CacheRevalidated {
+			t.Fatal("response not revalidated")
+		}
+	}
+	b, _ := tp.Cache.Get(server.URL)
+	for _, name := range []string{"Keep-Alive", "X-Hop"} {
+		if strings.Contains(string(b), name) {
+			t.Fatalf("hop-by-hop header %s stored:\n%s", name, b)
+		}
+	}
+}
+
+func TestHTTP2(t *testing.T) {
+	resetTest()
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=3600")
+		if r.URL.Path == "/gzip" {
+			w.Header().Set("Content-Encoding", "gzip")
+			gz := gzip.NewWriter(w)
+			io.WriteString(gz, "body")
+			gz.Close()
+			return
+		}
+		// Flushing before the end leaves the length unknown
+		io.WriteString(w, "bo")
+		w.(http.Flusher).Flush()
+		io.WriteString(w, "dy")
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	tp.Transport = server.Client().Transport
+	for _, path := range []string{"/", "/gzip"} {
+		for i := 0; i < 2; i++ {
+			req, err := http.NewRequest(http.MethodGet, server.URL+path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := tp.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if i == 1 && resp.Header.Get(XFromCache) != CacheHit {
+				t.Fatalf("%s: response not cached", path)
+			}
+			if string(body) != "body" {
+				t.Fatalf("%s: got body %q", path, body)
+			}
+			if resp.ProtoMajor != 2 || resp.ContentLength != -1 || resp.Close || resp.TransferEncoding != nil {
+				t.Fatalf("%s: got %s response, length %d, close %v, transfer encoding %v",
+					path, resp.Proto, resp.ContentLength, resp.Close, resp.TransferEncoding)
+			}
+			if resp.Uncompressed != (path == "/gzip") {
+				t.Fatalf("%s: got uncompressed %v", path, resp.Uncompressed)
+			}
+			if resp.Header.Get("Connection") != "" || resp.Header.Get(xUncompressed) != "" {
+				t.Fatalf("%s: got headers %v", path, resp.Header)
+			}
+		}
+	}
+}
+
 type transportMock struct {
 	response *http.Response
 	err      error
@@ -1051,9 +1207,712 @@ func TestGetWithStatuseCodes(t *testing.T) {
 			t.Fatal(err2)
 		}
 		httputil.DumpResponse(resp2, false)
-		isCached := resp2.Header.Get(XFromCache) == "1"
+		isCached := resp2.Header.Get(XFromCache) != ""
 		if isCacheable != isCached {
 			t.Fatalf("Should be cached %s: %t got: %t", path, isCacheable, isCached)
 		}
 	}
 }
+
+func TestMemoryCacheSetWithTTL(t *testing.T) {
+	c := NewMemoryCache(defaultMaxEntries)
+	c.SetWithTTL("expiring", []byte("a"), 10*time.Millisecond)
+	c.SetWithTTL("lasting", []byte("b"), 0)
+	if _, ok := c.Get("expiring"); !ok {
+		t.Fatal("entry expired too early")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, ok := c.Get("expiring"); ok {
+		t.Fatal("expired entry is still present")
+	}
+	if _, ok := c.Get("lasting"); !ok {
+		t.Fatal("entry without TTL was expired")
+	}
+}
+
+func TestTTLHint(t *testing.T) {
+	resetTest()
+	now := time.Now().UTC()
+	respHeaders := http.Header{}
+	respHeaders.Set("date", now.Format(http.TimeFormat))
+	respHeaders.Set("cache-control", "max-age=60")
+	clock = &fakeClock{elapsed: 10 * time.Second}
+	if got := ttlHint(respHeaders); got != 0 {
+		t.Fatalf("got TTL %v for a response that can be served stale, want 0", got)
+	}
+	respHeaders.Set("cache-control", "max-age=60, must-revalidate")
+	if got, want := ttlHint(respHeaders), 50*time.Second; got != want {
+		t.Fatalf("got TTL %v, want %v", got, want)
+	}
+
+	respHeaders.Set("etag", `"abc"`)
+	if got := ttlHint(respHeaders); got != 0 {
+		t.Fatalf("got TTL %v for a revalidatable response, want 0", got)
+	}
+
+	respHeaders = http.Header{}
+	respHeaders.Set("date", now.Format(http.TimeFormat))
+	if got := ttlHint(respHeaders); got != 0 {
+		t.Fatalf("got TTL %v for a response without freshness information, want 0", got)
+	}
+}
+
+// ttlRecordingCache is a TTLCache recording the TTL hints of its entries.
+type ttlRecordingCache struct {
+	*MemoryCache
+	mu   sync.Mutex
+	ttls map[string]time.Duration
+}
+
+func (c *ttlRecordingCache) SetWithTTL(key string, resp []byte, ttl time.Duration) {
+	c.mu.Lock()
+	c.ttls[key] = ttl
+	c.mu.Unlock()
+	c.MemoryCache.SetWithTTL(key, resp, ttl)
+}
+
+func TestTTLHintStale(t *testing.T) {
+	resetTest()
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Cache-Control", "max-age=1")
+		io.WriteString(w, "body")
+	}))
+	defer server.Close()
+
+	c := &ttlRecordingCache{MemoryCache: NewMemoryCache(defaultMaxEntries), ttls: map[string]time.Duration{}}
+	tp := NewTransport(c)
+	now := time.Now()
+	tp.Now = func() time.Time { return now }
+	get := func(ctx context.Context, cacheControl string) string {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+		if cacheControl != "" {
+			req.Header.Set("Cache-Control", cacheControl)
+		}
+		resp, err := tp.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		return resp.Header.Get(XFromCache)
+	}
+	get(context.Background(), "")
+	if ttl := c.ttls[server.URL]; ttl != 0 {
+		t.Fatalf("got TTL %v for a response that can be served stale, want 0", ttl)
+	}
+	now = now.Add(time.Hour)
+	if got := get(context.Background(), "max-stale"); got != CacheStale {
+		t.Fatalf("got %q with max-stale, want the stale response", got)
+	}
+	if got := get(WithOffline(context.Background()), ""); got != CacheStale {
+		t.Fatalf("got %q offline, want the stale response", got)
+	}
+	if requests != 1 {
+		t.Fatalf("got %d requests, want 1", requests)
+	}
+}
+
+func TestMemoryCacheStats(t *testing.T) {
+	c := NewMemoryCache(defaultMaxEntries)
+	c.Set("key", []byte("value"))
+	c.Get("key")
+	c.Get("missing")
+	stats := c.Stats()
+	if stats.Hits != 1 || stats.Misses != 1 || stats.Entries != 1 || stats.Bytes != 5 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestHooks(t *testing.T) {
+	resetTest()
+	var events []string
+	record := func(name string) func(*http.Request, Event) {
+		return func(req *http.Request, ev Event) {
+			if ev.Modified {
+				name += " modified"
+			}
+			events = append(events, name+" "+req.URL.Path)
+		}
+	}
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	tp.OnHit = record("hit")
+	tp.OnMiss = record("miss")
+	tp.OnStore = record("store")
+	tp.OnRevalidate = record("revalidate")
+	tp.OnBypass = record("bypass")
+	client := tp.Client()
+
+	do := func(method, path string) {
+		req, err := http.NewRequest(method, s.server.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	do("GET", "/")
+	do("GET", "/")
+	do("GET", "/etag")
+	do("GET", "/etag")
+	do("POST", "/method")
+
+	want := []string{
+		"miss /", "store /",
+		"hit /",
+		"miss /etag", "store /etag",
+		"store /etag", "revalidate /etag",
+		"bypass /method",
+	}
+	if strings.Join(events, ", ") != strings.Join(want, ", ") {
+		t.Fatalf("got events %q, want %q", events, want)
+	}
+}
+
+func TestEvaluateFreshnessReason(t *testing.T) {
+	resetTest()
+	now := time.Now().UTC()
+	respHeaders := http.Header{}
+	respHeaders.Set("date", now.Format(http.TimeFormat))
+	respHeaders.Set("cache-control", "max-age=60")
+	clock = &fakeClock{elapsed: 102 * time.Second}
+
+	info := evaluateFreshness(respHeaders, http.Header{})
+	if got, want := info.String(), "stale: max-age exceeded by 42s"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestLogDecisions(t *testing.T) {
+	resetTest()
+	var buf bytes.Buffer
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	tp.Logger = slog.New(slog.NewTextHandler(&buf, nil))
+
+	resp, err := tp.Client().Get(s.server.URL + "/nostore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if !strings.Contains(buf.String(), `decision="not stored" reason="no-store response directive"`) {
+		t.Fatalf("decision not logged: %s", buf.String())
+	}
+}
+
+func TestTransportStats(t *testing.T) {
+	resetTest()
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	client := tp.Client()
+	for _, path := range []string{"/method", "/method", "/etag", "/etag"} {
+		resp, err := client.Get(s.server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	want := Stats{
+		Hits:          1,
+		Revalidated:   1,
+		Fetched:       2,
+		BytesSaved:    int64(len("GET")),
+		RequestsSaved: 1,
+		BytesFetched:  int64(len("GET")),
+	}
+	if got := tp.Stats(); got != want {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	u, err := url.Parse(s.server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts := tp.HostStats()
+	if len(hosts) != 1 || hosts[u.Host] != want {
+		t.Fatalf("got host statistics %+v, want %+v for %s", hosts, want, u.Host)
+	}
+}
+
+func TestDebugHeader(t *testing.T) {
+	resetTest()
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	tp.Debug = true
+	client := tp.Client()
+
+	resp, err := client.Get(s.server.URL + "/nostore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got, want := resp.Header.Get(XHttpcacheDebug), `decision=miss; not-stored="no-store response directive"; response-directives="no-store"; request-directives=""`; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	for i := 0; i < 2; i++ {
+		resp, err = client.Get(s.server.URL + "/method")
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	if got := resp.Header.Get(XHttpcacheDebug); !strings.HasPrefix(got, "decision=hit; freshness=fresh; age=") {
+		t.Fatalf("unexpected debug header for a hit: %q", got)
+	}
+	cached, err := CachedResponse(tp.Cache, resp.Request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached.Header.Get(XHttpcacheDebug) != "" {
+		t.Fatal("debug header was stored")
+	}
+	for name := range cached.Header {
+		if strings.HasPrefix(name, "X-Httpcache-") {
+			t.Fatalf("internal header %s returned by CachedResponse", name)
+		}
+	}
+}
+
+func TestCacheMarkerValues(t *testing.T) {
+	resetTest()
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	get := func(cacheControl string) string {
+		req, err := http.NewRequest("GET", s.server.URL+"/method", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cacheControl != "" {
+			req.Header.Set("Cache-Control", cacheControl)
+		}
+		resp, err := tp.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		return resp.Header.Get(XFromCache)
+	}
+
+	if got := get(""); got != "" {
+		t.Fatalf("got marker %q for a miss", got)
+	}
+	if got := get(""); got != CacheHit {
+		t.Fatalf("got marker %q, want %q", got, CacheHit)
+	}
+	clock = &fakeClock{elapsed: 2 * time.Hour}
+	if got := get("max-stale"); got != CacheStale {
+		t.Fatalf("got marker %q, want %q", got, CacheStale)
+	}
+	tp.LegacyCacheMarker = true
+	if got := get("max-stale"); got != "1" {
+		t.Fatalf("got marker %q in compatibility mode, want \"1\"", got)
+	}
+}
+
+func TestCacheMarkerHeader(t *testing.T) {
+	resetTest()
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	tp.CacheMarkerHeader = "Acme-Cache"
+	var resp *http.Response
+	for i := 0; i < 2; i++ {
+		var err error
+		resp, err = tp.Client().Get(s.server.URL + "/method")
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	if got := resp.Header.Get("Acme-Cache"); got != CacheHit {
+		t.Fatalf("got marker %q, want %q", got, CacheHit)
+	}
+	if resp.Header.Get(XFromCache) != "" {
+		t.Fatal("XFromCache header isn't blank")
+	}
+}
+
+func TestClone(t *testing.T) {
+	resetTest()
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	tp.Policies = map[string]Policy{"example.com": {DefaultFreshness: time.Minute}}
+	tp.Prefetch = &PrefetchOptions{MaxLinks: 2}
+	resp, err := tp.Client().Get(s.server.URL + "/method")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	clone := tp.Clone()
+	clone.Policies["example.com"] = Policy{}
+	clone.Prefetch.MaxLinks = 4
+	if tp.Policies["example.com"].DefaultFreshness != time.Minute || tp.Prefetch.MaxLinks != 2 {
+		t.Fatal("the clone shares the configuration of the Transport")
+	}
+	if clone.Cache != tp.Cache {
+		t.Fatal("the clone doesn't share the cache of the Transport")
+	}
+	if stats := clone.Stats(); stats != (Stats{}) {
+		t.Fatalf("the clone has the statistics of the Transport: %+v", stats)
+	}
+}
+
+func TestSetCache(t *testing.T) {
+	resetTest()
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	client := tp.Client()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				resp, err := client.Get(s.server.URL + "/method")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				io.Copy(ioutil.Discard, resp.Body)
+				resp.Body.Close()
+			}
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		tp.SetCache(NewMemoryCache(defaultMaxEntries))
+	}
+	wg.Wait()
+
+	c := NewMemoryCache(defaultMaxEntries)
+	tp.SetCache(c)
+	resp, err := client.Get(s.server.URL + "/method")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if _, ok := c.Get(s.server.URL + "/method"); !ok {
+		t.Fatal("response not stored in the new cache")
+	}
+}
+
+func TestCachedResponseWithMeta(t *testing.T) {
+	resetTest()
+	req, err := http.NewRequest(http.MethodGet, s.server.URL+"/etag", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, meta, err := CachedResponseWithMeta(s.transport.Cache, req)
+	if resp != nil || meta != nil || err != nil {
+		t.Fatalf("got %v, %v, %v for an empty cache", resp, meta, err)
+	}
+	resp, err = s.client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	clock = &fakeClock{elapsed: time.Minute}
+	resp, meta, err = CachedResponseWithMeta(s.transport.Cache, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || meta == nil {
+		t.Fatal("response not found")
+	}
+	if meta.ETag != "124567" || meta.Age != time.Minute || meta.Stored.IsZero() || meta.Size == 0 {
+		t.Fatalf("unexpected metadata: %+v", meta)
+	}
+	if !meta.Expires.IsZero() {
+		t.Fatalf("got Expires %v for a response without lifetime", meta.Expires)
+	}
+}
+
+func TestKeyAndDelete(t *testing.T) {
+	resetTest()
+	req, err := http.NewRequest(http.MethodHead, s.server.URL+"/method", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key := Key(req); key != "HEAD "+s.server.URL+"/method" {
+		t.Fatalf("got key %q", key)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if _, ok := s.transport.Cache.Get(Key(req)); !ok {
+		t.Fatal("response not cached at its key")
+	}
+	Delete(s.transport.Cache, req)
+	if _, ok := s.transport.Cache.Get(Key(req)); ok {
+		t.Fatal("response not deleted")
+	}
+}
+
+func TestHotKeys(t *testing.T) {
+	resetTest()
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	client := tp.Client()
+	for _, path := range []string{"/method", "/", "/method", "/", "/method"} {
+		resp, err := client.Get(s.server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	keys := tp.HotKeys(1)
+	if len(keys) != 1 || keys[0].Key != s.server.URL+"/method" || keys[0].Hits != 2 || keys[0].LastAccess.IsZero() {
+		t.Fatalf("got hot keys %+v", keys)
+	}
+	if keys := tp.HotKeys(0); len(keys) != 2 {
+		t.Fatalf("got %d hot keys, want 2", len(keys))
+	}
+}
+
+func TestAccessesBounded(t *testing.T) {
+	st := &transportState{}
+	for i := 0; i <= maxAccesses; i++ {
+		st.setAccess(strconv.Itoa(i), entryAccess{hits: 1})
+	}
+	if len(st.access) != maxAccesses {
+		t.Fatalf("got %d accesses recorded, want %d", len(st.access), maxAccesses)
+	}
+	if _, ok := st.access[strconv.Itoa(maxAccesses)]; !ok {
+		t.Fatal("last access not recorded")
+	}
+}
+
+func TestTransportNow(t *testing.T) {
+	resetTest()
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	var elapsed time.Duration
+	tp.Now = func() time.Time {
+		return time.Now().Add(elapsed)
+	}
+	get := func() string {
+		resp, err := tp.Client().Get(s.server.URL + "/method")
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		return resp.Header.Get(XFromCache)
+	}
+	get()
+	if got := get(); got != CacheHit {
+		t.Fatalf("got X-From-Cache %q, want %q", got, CacheHit)
+	}
+	elapsed = 2 * time.Hour
+	if got := get(); got != "" {
+		t.Fatalf("got X-From-Cache %q for a response stale according to Now", got)
+	}
+}
+
+// blockingCache is a Cache whose writes block until release is closed.
+type blockingCache struct {
+	Cache
+	release chan struct{}
+}
+
+func (c blockingCache) Set(key string, b []byte) {
+	<-c.release
+	c.Cache.Set(key, b)
+}
+
+func TestStoreTimeout(t *testing.T) {
+	resetTest()
+	c := blockingCache{Cache: NewMemoryCache(defaultMaxEntries), release: make(chan struct{})}
+	defer close(c.release)
+	tp := NewTransport(c)
+	tp.StoreTimeout = 10 * time.Millisecond
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		resp, err := tp.Client().Get(s.server.URL + "/method")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("request blocked by the cache beyond StoreTimeout")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, err := http.NewRequest(http.MethodGet, s.server.URL+"/method", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tp.set(ctx, Key(req), []byte("x"), 0); err != context.Canceled {
+		t.Fatalf("got error %v, want context.Canceled", err)
+	}
+}
+
+func TestKeyBinding(t *testing.T) {
+	resetTest()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=3600")
+		io.WriteString(w, r.URL.Path)
+	}))
+	defer server.Close()
+
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	get := func(path string) (string, string) {
+		resp, err := tp.Client().Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.Header.Get(xKey) != "" {
+			t.Fatal("key binding served")
+		}
+		return string(body), resp.Header.Get(XFromCache)
+	}
+	get("/a")
+	if _, from := get("/a"); from != CacheHit {
+		t.Fatal("response not cached")
+	}
+	// A misbehaving backend returns the entry of /a for /b
+	b, _ := tp.Cache.Get(server.URL + "/a")
+	tp.Cache.Set(server.URL+"/b", b)
+	if body, from := get("/b"); from == CacheHit || body != "/b" {
+		t.Fatalf("got %q from %q, want the misplaced entry ignored", body, from)
+	}
+}
+
+func TestEntryLimits(t *testing.T) {
+	resetTest()
+	valid := "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n"
+	if _, err := readEntry([]byte(valid), nil); err != nil {
+		t.Fatalf("valid entry: %v", err)
+	}
+	manyHeaders := "HTTP/1.1 200 OK\r\n" + strings.Repeat("X-A: b\r\n", maxEntryHeaders+1) + "\r\n"
+	largeHeader := "HTTP/1.1 200 OK\r\nX-A: " + strings.Repeat("b", maxEntryHeaderBytes) + "\r\n\r\n"
+	longStatus := "HTTP/1.1 200 " + strings.Repeat("O", maxEntryStatusLine) + "\r\n\r\n"
+	for name, entry := range map[string]string{
+		"header count": manyHeaders,
+		"header size":  largeHeader,
+		"status line":  longStatus,
+	} {
+		if _, err := readEntry([]byte(entry), nil); !errors.Is(err, ErrEntryLimits) {
+			t.Errorf("%s: got %v, want ErrEntryLimits", name, err)
+		}
+	}
+
+	// Such an entry is a miss
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Write([]byte("fresh"))
+	}))
+	defer ts.Close()
+	tp := NewMemoryCacheTransport(0)
+	tp.Cache.Set(ts.URL, []byte(manyHeaders))
+	resp, err := tp.Client().Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "fresh" || resp.Header.Get(XFromCache) != "" {
+		t.Errorf("got %q from cache %q, want a miss", body, resp.Header.Get(XFromCache))
+	}
+}
+
+func TestServeTransform(t *testing.T) {
+	resetTest()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Write([]byte("body"))
+	}))
+	defer server.Close()
+
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	tp.ServeTransform = func(req *http.Request, resp *http.Response) {
+		resp.Header.Set("X-Data-As-Of", resp.Header.Get("Date"))
+	}
+	get := func() *http.Response {
+		resp, err := tp.Client().Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		return resp
+	}
+	if resp := get(); resp.Header.Get("X-Data-As-Of") != "" {
+		t.Fatal("response of the server transformed")
+	}
+	if resp := get(); resp.Header.Get(XFromCache) != CacheHit || resp.Header.Get("X-Data-As-Of") == "" {
+		t.Fatal("cached response not transformed")
+	}
+	b, _ := tp.Cache.Get(server.URL)
+	if bytes.Contains(b, []byte("X-Data-As-Of")) {
+		t.Fatal("transformation stored")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	resetTest()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=0")
+		w.Header().Set("ETag", `"1"`)
+		if r.Header.Get("If-None-Match") == `"1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte(r.Header.Get("X-Chain")))
+	}))
+	defer server.Close()
+
+	var sent []string
+	decorator := func(name string) func(http.RoundTripper) http.RoundTripper {
+		return func(next http.RoundTripper) http.RoundTripper {
+			return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				sent = append(sent, name)
+				req = req.Clone(req.Context())
+				req.Header.Set("X-Chain", req.Header.Get("X-Chain")+name)
+				return next.RoundTrip(req)
+			})
+		}
+	}
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	tp.Middleware = []func(http.RoundTripper) http.RoundTripper{decorator("a"), decorator("b")}
+	resp, err := tp.Client().Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "ab" {
+		t.Fatalf("got chain %q, want %q", body, "ab")
+	}
+	resp, err = tp.Client().Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.Header.Get(XFromCache) != CacheRevalidated || len(sent) != 4 {
+		t.Fatalf("got %q after sending %v, want the revalidation through the middleware", resp.Header.Get(XFromCache), sent)
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
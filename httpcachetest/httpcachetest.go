@@ -0,0 +1,188 @@
+// Package httpcachetest provides utilities to test the caching behavior of
+// the applications using httpcache: a fake clock, a scripted in-memory
+// origin server, and assertions on how responses were served.
+//
+// A typical test wires them to a Transport:
+//
+//	clock := httpcachetest.NewClock(time.Now())
+//	origin := httpcachetest.NewOrigin(clock)
+//	origin.Handle("/a", httpcachetest.Response{Header: http.Header{"Cache-Control": {"max-age=60"}}, Body: "a"})
+//	tp := httpcache.NewMemoryCacheTransport(100)
+//	tp.Transport = origin
+//	tp.Now = clock.Now
+package httpcachetest
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cozy/httpcache"
+	"github.com/cozy/httpcache/conditional"
+)
+
+// Clock is a fake clock, safe for concurrent use, whose time only changes
+// when told to. Its Now method can be used as the Now of a Transport.
+type Clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewClock returns a Clock set to now.
+func NewClock(now time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+// Now returns the current time of c.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves c forward by d.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+// Set sets the current time of c to now.
+func (c *Clock) Set(now time.Time) {
+	c.mu.Lock()
+	c.now = now
+	c.mu.Unlock()
+}
+
+// A Response is a response scripted for an Origin.
+type Response struct {
+	// Status defaults to 200 OK
+	Status int
+	Header http.Header
+	Body   string
+}
+
+// Origin is an in-memory origin server, an http.RoundTripper answering the
+// requests with the responses scripted for their path, without any network.
+//
+// The responses are given a Date header from the clock of the Origin if they
+// have none. Origin answers 304 Not Modified to the conditional requests
+// validating the response, see conditional.NotModified.
+type Origin struct {
+	clock *Clock
+
+	mu        sync.Mutex
+	responses map[string][]Response
+	requests  map[string]int
+}
+
+// NewOrigin returns an Origin dating its responses with clock, or with the
+// real time if clock is nil.
+func NewOrigin(clock *Clock) *Origin {
+	return &Origin{
+		clock:     clock,
+		responses: make(map[string][]Response),
+		requests:  make(map[string]int),
+	}
+}
+
+// Handle scripts the responses to the requests for path: each request gets
+// the next response, and the last one is repeated.
+func (o *Origin) Handle(path string, responses ...Response) {
+	o.mu.Lock()
+	o.responses[path] = responses
+	o.mu.Unlock()
+}
+
+// Requests returns how many requests for path o received.
+func (o *Origin) Requests(path string) int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.requests[path]
+}
+
+// RoundTrip answers req with the next response scripted for its path, or 404
+// Not Found if there is none.
+func (o *Origin) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		io.Copy(ioutil.Discard, req.Body)
+		req.Body.Close()
+	}
+	path := req.URL.Path
+	o.mu.Lock()
+	o.requests[path]++
+	scripted := Response{Status: http.StatusNotFound}
+	if responses := o.responses[path]; len(responses) > 0 {
+		scripted = responses[0]
+		if len(responses) > 1 {
+			o.responses[path] = responses[1:]
+		}
+	}
+	o.mu.Unlock()
+
+	status := scripted.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	header := scripted.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	if header.Get("Date") == "" {
+		now := time.Now()
+		if o.clock != nil {
+			now = o.clock.Now()
+		}
+		header.Set("Date", now.UTC().Format(http.TimeFormat))
+	}
+	body := scripted.Body
+	if conditional.NotModified(req, header) {
+		status = http.StatusNotModified
+		body = ""
+	}
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	return &http.Response{
+		Status:        strconv.Itoa(status) + " " + http.StatusText(status),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader([]byte(body))),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// AssertHit fails the test if resp wasn't served from the cache without
+// contacting the server. Like the other assertions, it relies on the
+// X-From-Cache header set by the Transports marking their responses.
+func AssertHit(t testing.TB, resp *http.Response) {
+	t.Helper()
+	assertMarker(t, resp, httpcache.CacheHit)
+}
+
+// AssertMiss fails the test if resp was served from the cache.
+func AssertMiss(t testing.TB, resp *http.Response) {
+	t.Helper()
+	assertMarker(t, resp, "")
+}
+
+// AssertRevalidated fails the test if resp wasn't served from the cache
+// after the server answered 304 Not Modified.
+func AssertRevalidated(t testing.TB, resp *http.Response) {
+	t.Helper()
+	assertMarker(t, resp, httpcache.CacheRevalidated)
+}
+
+func assertMarker(t testing.TB, resp *http.Response, want string) {
+	t.Helper()
+	if got := resp.Header.Get(httpcache.XFromCache); got != want {
+		t.Errorf("%s %s: got %s %q, want %q", resp.Request.Method, resp.Request.URL, httpcache.XFromCache, got, want)
+	}
+}
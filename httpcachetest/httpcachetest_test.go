@@ -0,0 +1,69 @@
+package httpcachetest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/cozy/httpcache"
+)
+
+func TestOrigin(t *testing.T) {
+	clock := NewClock(time.Now())
+	origin := NewOrigin(clock)
+	origin.Handle("/a", Response{
+		Header: http.Header{"Cache-Control": {"max-age=60"}, "Etag": {`"1"`}},
+		Body:   "a",
+	})
+	tp := httpcache.NewMemoryCacheTransport(10)
+	tp.Transport = origin
+	tp.Now = clock.Now
+	client := tp.Client()
+	get := func() *http.Response {
+		resp, err := client.Get("http://example.com/a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != "a" {
+			t.Fatalf("got body %q", body)
+		}
+		return resp
+	}
+
+	AssertMiss(t, get())
+	AssertHit(t, get())
+	clock.Advance(2 * time.Minute)
+	AssertRevalidated(t, get())
+	if n := origin.Requests("/a"); n != 2 {
+		t.Fatalf("origin received %d requests, want 2", n)
+	}
+}
+
+func TestOriginScript(t *testing.T) {
+	origin := NewOrigin(nil)
+	origin.Handle("/a", Response{Body: "1"}, Response{Status: http.StatusTeapot, Body: "2"})
+	for _, want := range []string{"1", "2", "2"} {
+		req, err := http.NewRequest(http.MethodGet, "http://example.com/a", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := origin.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(resp.Body)
+		if string(b) != want {
+			t.Fatalf("got body %q, want %q", b, want)
+		}
+	}
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com/b", nil)
+	if resp, _ := origin.RoundTrip(req); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("got status %d for an unscripted path", resp.StatusCode)
+	}
+}
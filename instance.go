@@ -0,0 +1,275 @@
+package httpcache
+
+import (
+	"container/list"
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type instanceKey struct{}
+
+// WithInstance returns a new context based on ctx, for the requests made on
+// behalf of the cozy instance of the given domain, see InstanceTransport.
+func WithInstance(ctx context.Context, domain string) context.Context {
+	return context.WithValue(ctx, instanceKey{}, domain)
+}
+
+// Instance returns the domain of the cozy instance set by WithInstance on
+// ctx, or an empty string.
+func Instance(ctx context.Context) string {
+	domain, _ := ctx.Value(instanceKey{}).(string)
+	return domain
+}
+
+// InstanceTransport partitions the cache of a Transport between the cozy
+// instances of a cozy-stack, so that one busy instance can't evict the
+// cached responses of all the others. The partition of a request is derived
+// from the instance domain of its context, see WithInstance; the requests
+// without one use the Transport unpartitioned.
+//
+// The quotas are enforced from the entries stored by the process: those
+// already in a persistent cache when it starts aren't accounted for.
+type InstanceTransport struct {
+	// Transport is the template of the Transports of the instances, which
+	// are its clones using a partition of its cache
+	Transport *Transport
+	// MaxEntries and MaxBytes, if set, are the quotas of each instance: the
+	// least recently used responses of an instance are evicted to make
+	// room for its new ones, leaving those of the others alone
+	MaxEntries int
+	MaxBytes   int64
+
+	mu        sync.Mutex
+	instances map[string]*Transport
+}
+
+// NewInstanceTransport returns an InstanceTransport partitioning the cache
+// of t, with the quotas maxEntries and maxBytes per instance.
+func NewInstanceTransport(t *Transport, maxEntries int, maxBytes int64) *InstanceTransport {
+	return &InstanceTransport{Transport: t, MaxEntries: maxEntries, MaxBytes: maxBytes}
+}
+
+// RoundTrip handles req with the Transport of its instance.
+func (it *InstanceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	domain := Instance(req.Context())
+	if domain == "" {
+		return it.Transport.RoundTrip(req)
+	}
+	return it.ForInstance(domain).RoundTrip(req)
+}
+
+// ForInstance returns the Transport of the instance of the given domain,
+// e.g. to invalidate its cached responses or read its statistics.
+func (it *InstanceTransport) ForInstance(domain string) *Transport {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+	if t, ok := it.instances[domain]; ok {
+		return t
+	}
+	if it.instances == nil {
+		it.instances = make(map[string]*Transport)
+	}
+	t := it.Transport.Clone()
+	if audit := t.Audit; audit != nil {
+		t.Audit = func(ctx context.Context, rec AuditRecord) {
+			rec.Namespace = domain
+			audit(ctx, rec)
+		}
+	}
+	t.SetCache(it.partition(it.Transport.cache(), domain))
+	if it.Transport.SensitiveCache != nil {
+		t.SensitiveCache = it.partition(it.Transport.SensitiveCache, domain)
+	}
+	it.instances[domain] = t
+	return t
+}
+
+// partition returns the partition of c holding the entries of the instance
+// of the given domain.
+func (it *InstanceTransport) partition(c Cache, domain string) *partition {
+	return &partition{
+		c:          c,
+		prefix:     partitionPrefix + domain + " ",
+		maxEntries: it.MaxEntries,
+		maxBytes:   it.MaxBytes,
+		ll:         list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+// partitionPrefix starts the keys of the entries of the partitions, followed
+// by the instance domain and a space.
+const partitionPrefix = "instance "
+
+// stripPartitionKey returns key without the prefix of its partition, if any.
+func stripPartitionKey(key string) string {
+	if rest, ok := strings.CutPrefix(key, partitionPrefix); ok {
+		if _, key, ok = strings.Cut(rest, " "); ok {
+			return key
+		}
+	}
+	return key
+}
+
+// partition is the part of a cache holding the entries of an instance, under
+// a prefix of their keys, within quotas.
+type partition struct {
+	c          Cache
+	prefix     string
+	maxEntries int
+	maxBytes   int64
+
+	mu      sync.Mutex
+	ll      *list.List // of *partitionEntry, the most recently used first
+	entries map[string]*list.Element
+	bytes   int64
+	notify  func(Eviction) // set by NotifyEvictions
+}
+
+// partitionEntry is the accounting of an entry of a partition.
+type partitionEntry struct {
+	key  string
+	size int64
+}
+
+// Get returns the response cached at key in the partition.
+func (p *partition) Get(key string) ([]byte, bool) {
+	b, ok := p.c.Get(p.prefix + key)
+	p.mu.Lock()
+	if elt, tracked := p.entries[key]; tracked {
+		if ok {
+			p.ll.MoveToFront(elt)
+		} else {
+			p.remove(elt)
+		}
+	}
+	p.mu.Unlock()
+	return b, ok
+}
+
+// Peek returns the response cached at key in the partition, without marking
+// it as recently used.
+func (p *partition) Peek(key string) ([]byte, bool) {
+	return peek(p.c, p.prefix+key)
+}
+
+// Set stores b at key in the partition, evicting its least recently used
+// entries if it exceeds its quotas.
+func (p *partition) Set(key string, b []byte) {
+	p.c.Set(p.prefix+key, b)
+	p.track(key, len(b))
+}
+
+// SetWithTTL is like Set, with the TTL hint ttl if the underlying cache
+// supports it.
+func (p *partition) SetWithTTL(key string, b []byte, ttl time.Duration) {
+	if c, ok := p.c.(TTLCache); ok {
+		c.SetWithTTL(p.prefix+key, b, ttl)
+	} else {
+		p.c.Set(p.prefix+key, b)
+	}
+	p.track(key, len(b))
+}
+
+// Delete removes the entry at key from the partition.
+func (p *partition) Delete(key string) {
+	p.c.Delete(p.prefix + key)
+	p.mu.Lock()
+	if elt, ok := p.entries[key]; ok {
+		p.remove(elt)
+	}
+	p.mu.Unlock()
+}
+
+// Keys returns the keys of the entries of the partition, listed by the
+// underlying cache if it implements KeyLister, or else those stored by the
+// process.
+func (p *partition) Keys() []string {
+	if lister, ok := p.c.(KeyLister); ok {
+		var keys []string
+		for _, key := range lister.Keys() {
+			if key, ok := strings.CutPrefix(key, p.prefix); ok {
+				keys = append(keys, key)
+			}
+		}
+		return keys
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	keys := make([]string, 0, len(p.entries))
+	for key := range p.entries {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// PurgeMatching removes the entries of the partition whose key matches f, and
+// returns how many were removed.
+func (p *partition) PurgeMatching(f func(key string) bool) int {
+	var n int
+	if purger, ok := p.c.(Purger); ok {
+		n = purger.PurgeMatching(func(key string) bool {
+			key, ok := strings.CutPrefix(key, p.prefix)
+			return ok && f(key)
+		})
+	} else {
+		for _, key := range p.Keys() {
+			if f(key) {
+				p.c.Delete(p.prefix + key)
+				n++
+			}
+		}
+	}
+	p.mu.Lock()
+	for key, elt := range p.entries {
+		if f(key) {
+			p.remove(elt)
+		}
+	}
+	p.mu.Unlock()
+	return n
+}
+
+// track accounts for an entry of size bytes stored at key, and enforces the
+// quotas.
+func (p *partition) track(key string, size int) {
+	var evicted []*partitionEntry
+	p.mu.Lock()
+	if elt, ok := p.entries[key]; ok {
+		p.remove(elt)
+	}
+	p.entries[key] = p.ll.PushFront(&partitionEntry{key: key, size: int64(size)})
+	p.bytes += int64(size)
+	for p.ll.Len() > 0 && ((p.maxEntries > 0 && p.ll.Len() > p.maxEntries) || (p.maxBytes > 0 && p.bytes > p.maxBytes)) {
+		oldest := p.ll.Back()
+		evicted = append(evicted, oldest.Value.(*partitionEntry))
+		p.remove(oldest)
+	}
+	notify := p.notify
+	p.mu.Unlock()
+	for _, e := range evicted {
+		p.c.Delete(p.prefix + e.key)
+		if notify != nil {
+			notify(Eviction{Key: e.key, Reason: EvictQuota, Size: int(e.size)})
+		}
+	}
+}
+
+// NotifyEvictions makes p call f with the entries evicted to keep it within
+// its quotas. The other evictions are reported by the underlying cache, with
+// the keys of the partition prefixed.
+func (p *partition) NotifyEvictions(f func(Eviction)) {
+	p.mu.Lock()
+	p.notify = f
+	p.mu.Unlock()
+}
+
+// remove stops accounting for the entry of elt. p.mu must be held.
+func (p *partition) remove(elt *list.Element) {
+	e := p.ll.Remove(elt).(*partitionEntry)
+	delete(p.entries, e.key)
+	p.bytes -= e.size
+}
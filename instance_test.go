@@ -0,0 +1,115 @@
+package httpcache
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestInstanceTransport(t *testing.T) {
+	resetTest()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=3600")
+		io.WriteString(w, "body")
+	}))
+	defer server.Close()
+
+	it := NewInstanceTransport(NewMemoryCacheTransport(defaultMaxEntries), 2, 0)
+	get := func(domain, path string) string {
+		req, err := http.NewRequestWithContext(WithInstance(context.Background(), domain), http.MethodGet, server.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := it.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		return resp.Header.Get(XFromCache)
+	}
+	get("bob.example.net", "/1")
+	if get("bob.example.net", "/1") != CacheHit {
+		t.Fatal("response of the instance not cached")
+	}
+	if get("alice.example.net", "/1") == CacheHit {
+		t.Fatal("response cached for another instance served")
+	}
+	for _, path := range []string{"/2", "/3", "/4"} {
+		get("alice.example.net", path)
+	}
+	if get("bob.example.net", "/1") != CacheHit {
+		t.Fatal("response of an instance evicted by another one")
+	}
+	if get("alice.example.net", "/1") == CacheHit || get("alice.example.net", "/4") != CacheHit {
+		t.Fatal("quota of the instance not enforced")
+	}
+	if get("", "/1") == CacheHit {
+		t.Fatal("response cached for an instance served without instance")
+	}
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := it.ForInstance("bob.example.net").Cached(req); !ok {
+		t.Fatal("response not cached in the Transport of the instance")
+	}
+}
+
+func TestInstanceTransportMaintenance(t *testing.T) {
+	resetTest()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Header().Set("ETag", `"v1"`)
+		io.WriteString(w, "body")
+	}))
+	defer server.Close()
+
+	c := NewMemoryCache(0)
+	it := NewInstanceTransport(NewTransport(c), 0, 0)
+	for _, path := range []string{"/1", "/2"} {
+		req, err := http.NewRequestWithContext(WithInstance(context.Background(), "bob.example.net"), http.MethodGet, server.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := it.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	report, err := CheckConsistency(c, CheckOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Problems() != 0 {
+		t.Fatalf("got report %q for the entries of an instance", report)
+	}
+	gc, err := NewGC(c, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := gc.RemoveExpired(0); n != 0 {
+		t.Fatalf("garbage collected %d fresh entries of an instance", n)
+	}
+
+	bob := it.ForInstance("bob.example.net")
+	if keys := bob.cache().(KeyLister).Keys(); len(keys) != 2 || !strings.HasPrefix(keys[0], server.URL) {
+		t.Fatalf("got keys %q for the instance, want the 2 URLs", keys)
+	}
+	if keys := it.ForInstance("alice.example.net").cache().(KeyLister).Keys(); len(keys) != 0 {
+		t.Fatalf("got keys %q for another instance, want none", keys)
+	}
+	if n, err := bob.PurgeMatching(func(key string) bool { return strings.HasSuffix(key, "/1") }); err != nil || n != 1 {
+		t.Fatalf("purged %d entries of the instance, %v, want 1", n, err)
+	}
+	if keys := c.Keys(); len(keys) != 1 || !strings.HasSuffix(keys[0], "/2") {
+		t.Fatalf("got keys %q after purging, want only /2", keys)
+	}
+}
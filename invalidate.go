@@ -0,0 +1,97 @@
+package httpcache
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Invalidate removes the cached responses for the resource requested by req,
+// whatever the method they were cached for, e.g. after learning out of band
+// that the resource has changed.
+func (t *Transport) Invalidate(req *http.Request) {
+	t.invalidate(req.Context(), Invalidation{URL: req.URL.String()})
+}
+
+// InvalidateURL removes the cached responses for the resource at rawurl, like
+// Invalidate.
+func (t *Transport) InvalidateURL(rawurl string) error {
+	return t.ApplyInvalidation(Invalidation{URL: rawurl})
+}
+
+// xSoftPurged is the header marking the cached responses that have been soft
+// purged. It is only stored in the cache, never served.
+const xSoftPurged = "X-Httpcache-Soft-Purged"
+
+// SoftPurge marks the cached responses for the resource requested by req as
+// stale, without deleting them: the next request revalidates them, and can
+// still benefit from a 304 Not Modified instead of a full download.
+func (t *Transport) SoftPurge(req *http.Request) {
+	t.invalidate(req.Context(), Invalidation{URL: req.URL.String(), Soft: true})
+}
+
+// SoftPurgeURL marks the cached responses for the resource at rawurl as
+// stale, like SoftPurge.
+func (t *Transport) SoftPurgeURL(rawurl string) error {
+	return t.ApplyInvalidation(Invalidation{URL: rawurl, Soft: true})
+}
+
+// softPurge marks the response cached for req at key as stale, on behalf of
+// the principal of ctx.
+func (t *Transport) softPurge(ctx context.Context, key string, req *http.Request) {
+	if t.rewrite(key, req, func(resp *http.Response) { resp.Header.Set(xSoftPurged, "1") }) {
+		t.audit(ctx, AuditPurge, key, false)
+	}
+}
+
+// rewrite stores again the response cached for req at key, once modified by
+// f, and reports whether there was one.
+func (t *Transport) rewrite(key string, req *http.Request, f func(resp *http.Response)) bool {
+	c := t.cache()
+	b, ok := c.Get(key)
+	if !ok && t.SensitiveCache != nil {
+		c = t.SensitiveCache
+		b, ok = c.Get(key)
+	}
+	if !ok {
+		return false
+	}
+	resp, err := readEntry(b, req)
+	if err != nil {
+		return false
+	}
+	f(resp)
+	b, err = t.dumpResponse(resp, nil)
+	if err != nil {
+		return false
+	}
+	if tc, ok := c.(TTLCache); ok {
+		tc.SetWithTTL(key, b, t.policy(req.URL).ttlHint(t.clock(), resp.Header))
+	} else {
+		c.Set(key, b)
+	}
+	return true
+}
+
+// keyRequest returns a request whose response is cached at key.
+func keyRequest(key string) (*http.Request, error) {
+	method, rawurl := http.MethodGet, stripAuthorizationKey(stripPartitionKey(key))
+	if i := strings.IndexByte(rawurl, ' '); i >= 0 {
+		method, rawurl = rawurl[:i], rawurl[i+1:]
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Request{Method: method, URL: u}, nil
+}
+
+// resourceRequests returns the requests whose responses for the resource at
+// u can be cached.
+func resourceRequests(u *url.URL) []*http.Request {
+	return []*http.Request{
+		{Method: http.MethodGet, URL: u},
+		{Method: http.MethodHead, URL: u},
+	}
+}
@@ -0,0 +1,86 @@
+package httpcache
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestInvalidate(t *testing.T) {
+	resetTest()
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	client := tp.Client()
+	url := s.server.URL + "/method"
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		req, err := http.NewRequest(method, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	if n := len(tp.Cache.(KeyLister).Keys()); n != 2 {
+		t.Fatalf("got %d entries, want 2", n)
+	}
+
+	if err := tp.InvalidateURL(url); err != nil {
+		t.Fatal(err)
+	}
+	if keys := tp.Cache.(KeyLister).Keys(); len(keys) != 0 {
+		t.Fatalf("got entries %q after invalidation", keys)
+	}
+	if err := tp.InvalidateURL("://invalid"); err == nil {
+		t.Fatal("no error for an invalid URL")
+	}
+}
+
+func TestSoftPurge(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte("body"))
+	}))
+	defer server.Close()
+
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	get := func() *http.Response {
+		t.Helper()
+		resp, err := tp.Client().Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		return resp
+	}
+	get()
+	if resp := get(); resp.Header.Get(XFromCache) != CacheHit {
+		t.Fatal("response not served from the cache")
+	}
+
+	if err := tp.SoftPurgeURL(server.URL); err != nil {
+		t.Fatal(err)
+	}
+	resp := get()
+	if resp.Header.Get(XFromCache) != CacheRevalidated || requests != 2 {
+		t.Fatalf("got X-From-Cache %q after %d requests, want a revalidation", resp.Header.Get(XFromCache), requests)
+	}
+	if resp.Header.Get(xSoftPurged) != "" {
+		t.Fatal("soft purge mark served to the client")
+	}
+	if resp := get(); resp.Header.Get(XFromCache) != CacheHit || requests != 2 {
+		t.Fatal("revalidated response not fresh again")
+	}
+}
@@ -0,0 +1,72 @@
+package httpcache
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// An Expirer is a Cache that can remove its expired entries on demand, so
+// that they don't linger until the next lookup.
+type Expirer interface {
+	// RemoveExpired removes at most max expired entries, or all of them if
+	// max is zero, and returns how many were removed.
+	RemoveExpired(max int) int
+}
+
+// JanitorOptions configures the sweeps of a janitor started with StartJanitor.
+type JanitorOptions struct {
+	// Interval is the time between two sweeps. It defaults to one minute.
+	Interval time.Duration
+	// Jitter is the maximum random duration added to each interval, so that
+	// janitors started at the same time don't sweep together.
+	Jitter time.Duration
+	// BatchSize is the maximum number of entries removed at once. The cache
+	// is released to concurrent requests between two batches. Zero means the
+	// whole sweep is done in a single batch.
+	BatchSize int
+}
+
+// StartJanitor starts a goroutine that periodically removes the expired
+// entries of c, keeping its size proportional to the live data. The janitor
+// runs until the returned stop function is called.
+func StartJanitor(c Expirer, opts JanitorOptions) (stop func()) {
+	interval := opts.Interval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	done := make(chan struct{})
+	go func() {
+		for {
+			wait := interval
+			if opts.Jitter > 0 {
+				wait += time.Duration(rand.Int63n(int64(opts.Jitter)))
+			}
+			timer := time.NewTimer(wait)
+			select {
+			case <-done:
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+			sweep(c, opts.BatchSize, done)
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+// sweep removes all the expired entries of c, batchSize at a time.
+func sweep(c Expirer, batchSize int, done <-chan struct{}) {
+	for {
+		n := c.RemoveExpired(batchSize)
+		if batchSize == 0 || n < batchSize {
+			return
+		}
+		select {
+		case <-done:
+			return
+		default:
+		}
+	}
+}
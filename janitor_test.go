@@ -0,0 +1,33 @@
+package httpcache
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestJanitorRemovesExpiredEntries(t *testing.T) {
+	c := NewMemoryCache(defaultMaxEntries)
+	for i := 0; i < 5; i++ {
+		c.SetWithTTL(strconv.Itoa(i), []byte("expiring"), time.Millisecond)
+	}
+	c.Set("lasting", []byte("lasting"))
+
+	stop := StartJanitor(c, JanitorOptions{
+		Interval:  5 * time.Millisecond,
+		Jitter:    time.Millisecond,
+		BatchSize: 2,
+	})
+	defer stop()
+
+	deadline := time.Now().Add(time.Second)
+	for c.items.Len() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d entries, want 1", c.items.Len())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, ok := c.Get("lasting"); !ok {
+		t.Fatal("janitor removed an entry that isn't expired")
+	}
+}
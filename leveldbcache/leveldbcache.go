@@ -4,6 +4,7 @@ package leveldbcache
 
 import (
 	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
 )
 
 // Cache is an implementation of httpcache.Cache with leveldb storage
@@ -31,6 +32,46 @@ func (c *Cache) Delete(key string) {
 	c.db.Delete([]byte(key), nil)
 }
 
+// Keys returns the keys of the responses in the cache
+func (c *Cache) Keys() []string {
+	var keys []string
+	iter := c.db.NewIterator(nil, nil)
+	for iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	iter.Release()
+	return keys
+}
+
+// PurgePrefix removes the responses whose key starts with prefix, iterating
+// over that range of keys only, and returns how many were removed
+func (c *Cache) PurgePrefix(prefix string) int {
+	return c.purge(util.BytesPrefix([]byte(prefix)), nil)
+}
+
+// PurgeMatching removes the responses whose key matches f, and returns how
+// many were removed
+func (c *Cache) PurgeMatching(f func(key string) bool) int {
+	return c.purge(nil, f)
+}
+
+// purge removes the responses in slice whose key matches f, or all of them if
+// f is nil.
+func (c *Cache) purge(slice *util.Range, f func(key string) bool) int {
+	batch := new(leveldb.Batch)
+	iter := c.db.NewIterator(slice, nil)
+	for iter.Next() {
+		if f == nil || f(string(iter.Key())) {
+			batch.Delete(append([]byte(nil), iter.Key()...))
+		}
+	}
+	iter.Release()
+	if iter.Error() != nil || c.db.Write(batch, nil) != nil {
+		return 0
+	}
+	return batch.Len()
+}
+
 // New returns a new Cache that will store leveldb in path
 func New(path string) (*Cache, error) {
 	cache := &Cache{}
@@ -44,3 +44,48 @@ func TestDiskCache(t *testing.T) {
 		t.Fatal("deleted key still present")
 	}
 }
+
+func TestPurge(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "httpcache")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	cache, err := New(filepath.Join(tempDir, "db"))
+	if err != nil {
+		t.Fatalf("New leveldb,: %v", err)
+	}
+	for _, key := range []string{"http://a/1", "http://a/2", "http://b/1", "http://b/2"} {
+		cache.Set(key, []byte(key))
+	}
+	if n := cache.PurgePrefix("http://a/"); n != 2 {
+		t.Fatalf("purged %d entries by prefix, want 2", n)
+	}
+	if n := cache.PurgeMatching(func(key string) bool { return key == "http://b/2" }); n != 1 {
+		t.Fatalf("purged %d matching entries, want 1", n)
+	}
+	for key, want := range map[string]bool{"http://a/1": false, "http://a/2": false, "http://b/1": true, "http://b/2": false} {
+		if _, ok := cache.Get(key); ok != want {
+			t.Errorf("got presence %v for %s, want %v", ok, key, want)
+		}
+	}
+}
+
+func TestKeys(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "httpcache")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	cache, err := New(filepath.Join(tempDir, "db"))
+	if err != nil {
+		t.Fatalf("New leveldb,: %v", err)
+	}
+	cache.Set("b", []byte("2"))
+	cache.Set("a", []byte("1"))
+	if keys := cache.Keys(); len(keys) != 2 || keys[0] != "a" || keys[1] != "b" {
+		t.Fatalf("got keys %q", keys)
+	}
+}
@@ -0,0 +1,60 @@
+package httpcache
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type lifetimeKey struct{}
+
+// WithLifetime returns a new context based on ctx, for requests whose
+// responses are stored with the freshness lifetime d, whatever their headers
+// say, for the callers knowing their tolerance better than the server. It
+// doesn't make storable the responses that can't be stored otherwise, such
+// as those with a no-store directive. The lifetime holds until the response
+// is stored again by a request without this option.
+func WithLifetime(ctx context.Context, d time.Duration) context.Context {
+	return context.WithValue(ctx, lifetimeKey{}, d)
+}
+
+// contextLifetime returns the lifetime set by WithLifetime on ctx, if any.
+func contextLifetime(ctx context.Context) (time.Duration, bool) {
+	d, ok := ctx.Value(lifetimeKey{}).(time.Duration)
+	return d, ok
+}
+
+// xLifetime is the header marking the responses stored with the lifetime of
+// WithLifetime, holding its duration in milliseconds. It is only stored in
+// the cache, never served.
+const xLifetime = "X-Httpcache-Lifetime"
+
+// withLifetime returns marks with the mark of the lifetime set on ctx, if
+// any.
+func withLifetime(ctx context.Context, marks http.Header) http.Header {
+	d, ok := contextLifetime(ctx)
+	if !ok {
+		return marks
+	}
+	if marks == nil {
+		marks = http.Header{}
+	}
+	marks.Set(xLifetime, lifetimeMark(d))
+	return marks
+}
+
+// lifetimeMark returns the value of the xLifetime mark of the lifetime d.
+func lifetimeMark(d time.Duration) string {
+	return strconv.FormatInt(int64(d/time.Millisecond), 10)
+}
+
+// markedLifetime returns the lifetime marked on the response with the headers
+// respHeaders, if any.
+func markedLifetime(respHeaders http.Header) (time.Duration, bool) {
+	ms, err := strconv.ParseInt(respHeaders.Get(xLifetime), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(ms) * time.Millisecond, true
+}
@@ -0,0 +1,54 @@
+package httpcache
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWithLifetime(t *testing.T) {
+	resetTest()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=0")
+		io.WriteString(w, "body")
+	}))
+	defer server.Close()
+
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	get := func(lifetime time.Duration) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lifetime > 0 {
+			req = req.WithContext(WithLifetime(req.Context(), lifetime))
+		}
+		resp, err := tp.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		return resp
+	}
+	get(time.Minute)
+	resp := get(0)
+	if resp.Header.Get(XFromCache) != CacheHit {
+		t.Fatal("response not fresh for the lifetime of the request storing it")
+	}
+	if resp.Header.Get(xLifetime) != "" {
+		t.Fatal("lifetime mark served")
+	}
+
+	clock = &fakeClock{elapsed: 2 * time.Minute}
+	if get(0).Header.Get(XFromCache) != "" {
+		t.Fatal("response served after the lifetime of the request")
+	}
+	get(0)
+	if get(0).Header.Get(XFromCache) != "" {
+		t.Fatal("lifetime of the request kept by a response stored without it")
+	}
+}
@@ -14,7 +14,10 @@ limitations under the License.
 // Package lru implements an LRU cache.
 package lru
 
-import "container/list"
+import (
+	"container/list"
+	"time"
+)
 
 type (
 	Key   string
@@ -27,13 +30,51 @@ type Cache struct {
 	// an item is evicted. Zero means no limit.
 	MaxEntries int
 
+	// MaxIdle is the duration after which an item that hasn't been
+	// accessed is treated as expired. Zero means no limit.
+	MaxIdle time.Duration
+
+	// OnRemoved, if set, is called with the items removed from the cache,
+	// and why, once they are removed.
+	OnRemoved func(key Key, value Value, reason Reason)
+
 	ll    *list.List
 	cache map[Key]*list.Element
+	now   func() time.Time
+	stats Stats
+
+	pinned   map[Key]struct{}
+	pinnedFn func(Key) bool
+}
+
+// Stats holds the usage statistics of a Cache.
+type Stats struct {
+	Hits       int64 // Number of successful lookups
+	Misses     int64 // Number of lookups of missing or expired keys
+	Evictions  int64 // Number of items evicted to honor MaxEntries
+	Insertions int64 // Number of items added or replaced
+	Entries    int64 // Current number of items
+	Bytes      int64 // Current total size of the values
 }
 
+// A Reason tells why an item was removed from a Cache.
+type Reason int
+
+const (
+	// Evicted items were the least recently used ones when the cache grew
+	// beyond MaxEntries
+	Evicted Reason = iota
+	// Expired items were expired, or idle for longer than MaxIdle
+	Expired
+	// Removed items were removed explicitly
+	Removed
+)
+
 type entry struct {
-	key   Key
-	value Value
+	key      Key
+	value    Value
+	expires  time.Time // zero means the entry never expires
+	accessed time.Time
 }
 
 // New creates a new Cache.
@@ -44,28 +85,68 @@ func New(maxEntries int) *Cache {
 		MaxEntries: maxEntries,
 		ll:         list.New(),
 		cache:      make(map[Key]*list.Element),
+		now:        time.Now,
 	}
 }
 
 // Add adds a value to the cache.
 func (c *Cache) Add(key Key, value Value) {
+	c.AddWithExpiry(key, value, time.Time{})
+}
+
+// AddWithExpiry adds a value to the cache that is treated as missing once
+// expires is reached. A zero expires means the value never expires.
+func (c *Cache) AddWithExpiry(key Key, value Value, expires time.Time) {
+	c.stats.Insertions++
 	if ee, ok := c.cache[key]; ok {
 		c.ll.MoveToFront(ee)
-		ee.Value.(*entry).value = value
+		e := ee.Value.(*entry)
+		c.stats.Bytes += int64(len(value) - len(e.value))
+		e.value = value
+		e.expires = expires
+		e.accessed = c.now()
 		return
 	}
-	ele := c.ll.PushFront(&entry{key, value})
+	ele := c.ll.PushFront(&entry{key, value, expires, c.now()})
 	c.cache[key] = ele
-	if c.MaxEntries != 0 && c.ll.Len() > c.MaxEntries {
-		c.RemoveOldest()
+	c.stats.Bytes += int64(len(value))
+	// The cache shrinks back to MaxEntries once it grew beyond because of the
+	// pinned items, and the item just added is only evicted by the next ones
+	for c.MaxEntries != 0 && c.ll.Len() > c.MaxEntries {
+		if !c.removeOldest(ele) {
+			break
+		}
+		c.stats.Evictions++
 	}
 }
 
-// Get looks up a key's value from the cache.
+// Get looks up a key's value from the cache. Expired values, and those
+// idle for longer than MaxIdle, are removed and reported as missing.
 func (c *Cache) Get(key Key) (value Value, ok bool) {
 	if ele, hit := c.cache[key]; hit {
+		e := ele.Value.(*entry)
+		if c.expired(e) {
+			c.removeElement(ele, Expired)
+			c.stats.Misses++
+			return
+		}
 		c.ll.MoveToFront(ele)
-		return ele.Value.(*entry).value, true
+		e.accessed = c.now()
+		c.stats.Hits++
+		return e.value, true
+	}
+	c.stats.Misses++
+	return
+}
+
+// Peek looks up a key's value from the cache like Get, without marking it
+// as recently used nor counting the lookup in the statistics. Expired values
+// are reported as missing, but left in place.
+func (c *Cache) Peek(key Key) (value Value, ok bool) {
+	if ele, hit := c.cache[key]; hit {
+		if e := ele.Value.(*entry); !c.expired(e) {
+			return e.value, true
+		}
 	}
 	return
 }
@@ -73,20 +154,126 @@ func (c *Cache) Get(key Key) (value Value, ok bool) {
 // Remove removes the provided key from the cache.
 func (c *Cache) Remove(key Key) {
 	if ele, hit := c.cache[key]; hit {
-		c.removeElement(ele)
+		c.removeElement(ele, Removed)
 	}
 }
 
-// RemoveOldest removes the oldest item from the cache.
+// RemoveOldest removes the oldest item from the cache that isn't pinned.
 func (c *Cache) RemoveOldest() {
-	ele := c.ll.Back()
-	if ele != nil {
-		c.removeElement(ele)
+	c.removeOldest(nil)
+}
+
+// removeOldest removes the oldest item from the cache that isn't pinned, other
+// than kept, and reports whether there was one.
+func (c *Cache) removeOldest(kept *list.Element) bool {
+	for ele := c.ll.Back(); ele != nil; ele = ele.Prev() {
+		if ele != kept && !c.isPinned(ele.Value.(*entry).key) {
+			c.removeElement(ele, Evicted)
+			return true
+		}
 	}
+	return false
+}
+
+// Pin protects key from eviction: it can still be removed explicitly, or
+// when it expires. Keys can be pinned before they are added. When all the
+// other items are pinned, the cache grows beyond MaxEntries instead of
+// evicting the item just added, and shrinks back as they are unpinned and
+// new items added.
+func (c *Cache) Pin(key Key) {
+	if c.pinned == nil {
+		c.pinned = make(map[Key]struct{})
+	}
+	c.pinned[key] = struct{}{}
+}
+
+// Unpin makes key subject to eviction again.
+func (c *Cache) Unpin(key Key) {
+	delete(c.pinned, key)
 }
 
-func (c *Cache) removeElement(e *list.Element) {
+// PinFunc protects from eviction all the keys for which f returns true, in
+// addition to those pinned with Pin. A nil f removes the previous function.
+func (c *Cache) PinFunc(f func(key Key) bool) {
+	c.pinnedFn = f
+}
+
+func (c *Cache) isPinned(key Key) bool {
+	if _, ok := c.pinned[key]; ok {
+		return true
+	}
+	return c.pinnedFn != nil && c.pinnedFn(key)
+}
+
+// RemoveExpired removes at most max expired or idle items from the cache,
+// starting with the least recently used ones, or all of them if max is zero.
+// It returns the number of removed items.
+func (c *Cache) RemoveExpired(max int) int {
+	n := 0
+	for ele := c.ll.Back(); ele != nil && (max == 0 || n < max); {
+		prev := ele.Prev()
+		if c.expired(ele.Value.(*entry)) {
+			c.removeElement(ele, Expired)
+			n++
+		}
+		ele = prev
+	}
+	return n
+}
+
+// RemoveMatching removes the items whose key matches f, pinned or not, and
+// returns how many were removed.
+func (c *Cache) RemoveMatching(f func(key Key) bool) int {
+	n := 0
+	for ele := c.ll.Back(); ele != nil; {
+		prev := ele.Prev()
+		if f(ele.Value.(*entry).key) {
+			c.removeElement(ele, Removed)
+			n++
+		}
+		ele = prev
+	}
+	return n
+}
+
+// Keys returns the keys of the items in the cache that haven't expired, from
+// the most to the least recently used.
+func (c *Cache) Keys() []Key {
+	keys := make([]Key, 0, c.ll.Len())
+	for ele := c.ll.Front(); ele != nil; ele = ele.Next() {
+		if e := ele.Value.(*entry); !c.expired(e) {
+			keys = append(keys, e.key)
+		}
+	}
+	return keys
+}
+
+// Len returns the number of items in the cache.
+func (c *Cache) Len() int {
+	return c.ll.Len()
+}
+
+// Stats returns the usage statistics of the cache.
+func (c *Cache) Stats() Stats {
+	stats := c.stats
+	stats.Entries = int64(c.ll.Len())
+	return stats
+}
+
+func (c *Cache) expired(e *entry) bool {
+	now := c.now()
+	if c.MaxIdle > 0 && now.Sub(e.accessed) >= c.MaxIdle {
+		return true
+	}
+	return !e.expires.IsZero() && !now.Before(e.expires)
+}
+
+func (c *Cache) removeElement(e *list.Element, reason Reason) {
 	c.ll.Remove(e)
 	kv := e.Value.(*entry)
 	delete(c.cache, kv.key)
+	c.stats.Bytes -= int64(len(kv.value))
+	if c.OnRemoved != nil {
+		c.OnRemoved(kv.key, kv.value, reason)
+	}
 }
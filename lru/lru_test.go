@@ -0,0 +1,218 @@
+package lru
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEviction(t *testing.T) {
+	c := New(2)
+	c.Add("a", Value("1"))
+	c.Add("b", Value("2"))
+	c.Get("a")
+	c.Add("c", Value("3"))
+	if _, ok := c.Get("b"); ok {
+		t.Fatal("least recently used entry wasn't evicted")
+	}
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("recently used entry was evicted")
+	}
+	if c.Len() != 2 {
+		t.Fatalf("got %d entries, want 2", c.Len())
+	}
+}
+
+func TestPeek(t *testing.T) {
+	c := New(2)
+	c.Add("a", Value("1"))
+	c.Add("b", Value("2"))
+	if v, ok := c.Peek("a"); !ok || string(v) != "1" {
+		t.Fatalf("got %q, %v, want the entry", v, ok)
+	}
+	c.Add("c", Value("3"))
+	if _, ok := c.Peek("a"); ok {
+		t.Fatal("peeked entry was marked as recently used")
+	}
+	if stats := c.Stats(); stats.Hits != 0 || stats.Misses != 0 {
+		t.Fatalf("got %+v, want the peeks uncounted", stats)
+	}
+}
+
+func TestSyncCacheConcurrentAccess(t *testing.T) {
+	c := NewSync(10)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				key := Key(strconv.Itoa(j % 20))
+				c.Add(key, Value("v"))
+				c.Get(key)
+				if j%7 == 0 {
+					c.Remove(key)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if c.Len() > 10 {
+		t.Fatalf("got %d entries, want at most 10", c.Len())
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	now := time.Now()
+	c := New(0)
+	c.now = func() time.Time { return now }
+	c.AddWithExpiry("a", Value("1"), now.Add(time.Minute))
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("entry expired too early")
+	}
+	now = now.Add(time.Minute)
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("expired entry is still present")
+	}
+	if c.Len() != 0 {
+		t.Fatal("expired entry wasn't removed")
+	}
+}
+
+func TestStats(t *testing.T) {
+	c := New(2)
+	c.Add("a", Value("12"))
+	c.Add("b", Value("345"))
+	c.Add("a", Value("6"))
+	c.Get("a")
+	c.Get("missing")
+	c.Add("c", Value("78"))
+
+	want := Stats{
+		Hits:       1,
+		Misses:     1,
+		Evictions:  1,
+		Insertions: 4,
+		Entries:    2,
+		Bytes:      3,
+	}
+	if got := c.Stats(); got != want {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestOnRemoved(t *testing.T) {
+	now := time.Now()
+	c := New(2)
+	c.now = func() time.Time { return now }
+	var removed []string
+	c.OnRemoved = func(key Key, value Value, reason Reason) {
+		removed = append(removed, fmt.Sprintf("%s=%s %d", key, value, reason))
+	}
+	c.Add("a", Value("1"))
+	c.AddWithExpiry("b", Value("2"), now.Add(time.Minute))
+	c.Add("c", Value("3"))
+	now = now.Add(time.Minute)
+	c.Get("b")
+	c.Remove("c")
+	want := []string{
+		fmt.Sprintf("a=1 %d", Evicted),
+		fmt.Sprintf("b=2 %d", Expired),
+		fmt.Sprintf("c=3 %d", Removed),
+	}
+	if fmt.Sprint(removed) != fmt.Sprint(want) {
+		t.Fatalf("got removals %v, want %v", removed, want)
+	}
+}
+
+func TestPinning(t *testing.T) {
+	c := New(2)
+	c.Pin("a")
+	c.PinFunc(func(key Key) bool { return key == "b" })
+	c.Add("a", Value("1"))
+	c.Add("b", Value("2"))
+	c.Add("c", Value("3"))
+	c.Add("d", Value("4"))
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("pinned entry was evicted")
+	}
+	if _, ok := c.Get("b"); !ok {
+		t.Fatal("entry pinned by function was evicted")
+	}
+	if _, ok := c.Get("c"); ok {
+		t.Fatal("unpinned entry wasn't evicted")
+	}
+
+	c.Unpin("a")
+	c.Add("e", Value("5"))
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("unpinned entry wasn't evicted")
+	}
+	c.Remove("b")
+	if _, ok := c.Get("b"); ok {
+		t.Fatal("pinned entry couldn't be removed")
+	}
+}
+
+func TestPinningGrows(t *testing.T) {
+	c := New(1)
+	c.Pin("a")
+	c.Add("a", Value("1"))
+	c.Add("b", Value("2"))
+	if _, ok := c.Get("b"); !ok || c.Len() != 2 {
+		t.Fatalf("entry added next to the pinned ones evicted, got %d entries", c.Len())
+	}
+	c.Add("c", Value("3"))
+	if _, ok := c.Get("b"); ok || c.Len() != 2 {
+		t.Fatalf("got %d entries, want b evicted by c", c.Len())
+	}
+	c.Unpin("a")
+	c.Add("d", Value("4"))
+	if c.Len() != 1 {
+		t.Fatalf("got %d entries, want the cache shrunk back to 1", c.Len())
+	}
+	if _, ok := c.Get("d"); !ok {
+		t.Fatal("entry just added evicted")
+	}
+}
+
+func TestMaxIdle(t *testing.T) {
+	now := time.Now()
+	c := New(0)
+	c.now = func() time.Time { return now }
+	c.MaxIdle = time.Hour
+	c.Add("a", Value("1"))
+	c.Add("b", Value("2"))
+
+	now = now.Add(40 * time.Minute)
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("entry evicted before being idle")
+	}
+	now = now.Add(40 * time.Minute)
+	if n := c.RemoveExpired(0); n != 1 {
+		t.Fatalf("removed %d idle entries, want 1", n)
+	}
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("recently accessed entry was evicted")
+	}
+	if _, ok := c.Get("b"); ok {
+		t.Fatal("idle entry is still present")
+	}
+}
+
+func TestKeys(t *testing.T) {
+	now := time.Now()
+	c := New(0)
+	c.now = func() time.Time { return now }
+	c.Add("a", Value("1"))
+	c.AddWithExpiry("b", Value("2"), now.Add(time.Minute))
+	c.Add("c", Value("3"))
+	c.Get("a")
+
+	now = now.Add(time.Hour)
+	if got := fmt.Sprint(c.Keys()); got != "[a c]" {
+		t.Fatalf("got keys %s, want [a c]", got)
+	}
+}
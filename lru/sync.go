@@ -0,0 +1,145 @@
+package lru
+
+import (
+	"sync"
+	"time"
+)
+
+// SyncCache is an LRU cache that is safe for concurrent access. It wraps a
+// Cache with a single mutex, since even Get needs to reorder the entries.
+type SyncCache struct {
+	mu sync.Mutex
+	c  *Cache
+}
+
+// NewSync creates a new SyncCache.
+// If maxEntries is zero, the cache has no limit and it's assumed
+// that eviction is done by the caller.
+func NewSync(maxEntries int) *SyncCache {
+	return &SyncCache{c: New(maxEntries)}
+}
+
+// Add adds a value to the cache.
+func (c *SyncCache) Add(key Key, value Value) {
+	c.mu.Lock()
+	c.c.Add(key, value)
+	c.mu.Unlock()
+}
+
+// AddWithExpiry adds a value to the cache that is treated as missing once
+// expires is reached. A zero expires means the value never expires.
+func (c *SyncCache) AddWithExpiry(key Key, value Value, expires time.Time) {
+	c.mu.Lock()
+	c.c.AddWithExpiry(key, value, expires)
+	c.mu.Unlock()
+}
+
+// Get looks up a key's value from the cache.
+func (c *SyncCache) Get(key Key) (value Value, ok bool) {
+	c.mu.Lock()
+	value, ok = c.c.Get(key)
+	c.mu.Unlock()
+	return value, ok
+}
+
+// Peek looks up a key's value from the cache without perturbing it, see
+// Cache.Peek.
+func (c *SyncCache) Peek(key Key) (value Value, ok bool) {
+	c.mu.Lock()
+	value, ok = c.c.Peek(key)
+	c.mu.Unlock()
+	return value, ok
+}
+
+// Remove removes the provided key from the cache.
+func (c *SyncCache) Remove(key Key) {
+	c.mu.Lock()
+	c.c.Remove(key)
+	c.mu.Unlock()
+}
+
+// RemoveOldest removes the oldest item from the cache.
+func (c *SyncCache) RemoveOldest() {
+	c.mu.Lock()
+	c.c.RemoveOldest()
+	c.mu.Unlock()
+}
+
+// RemoveExpired removes at most max expired items from the cache, or all of
+// them if max is zero. It returns the number of removed items.
+func (c *SyncCache) RemoveExpired(max int) int {
+	c.mu.Lock()
+	n := c.c.RemoveExpired(max)
+	c.mu.Unlock()
+	return n
+}
+
+// Pin protects key from eviction. See Cache.Pin.
+func (c *SyncCache) Pin(key Key) {
+	c.mu.Lock()
+	c.c.Pin(key)
+	c.mu.Unlock()
+}
+
+// Unpin makes key subject to eviction again.
+func (c *SyncCache) Unpin(key Key) {
+	c.mu.Lock()
+	c.c.Unpin(key)
+	c.mu.Unlock()
+}
+
+// PinFunc protects from eviction all the keys for which f returns true. f is
+// called with the cache locked. See Cache.PinFunc.
+func (c *SyncCache) PinFunc(f func(key Key) bool) {
+	c.mu.Lock()
+	c.c.PinFunc(f)
+	c.mu.Unlock()
+}
+
+// SetMaxIdle sets the duration after which an item that hasn't been accessed
+// is treated as expired. Zero means no limit.
+func (c *SyncCache) SetMaxIdle(d time.Duration) {
+	c.mu.Lock()
+	c.c.MaxIdle = d
+	c.mu.Unlock()
+}
+
+// SetOnRemoved sets the function called with the items removed from the
+// cache, and why. f is called with the cache locked. See Cache.OnRemoved.
+func (c *SyncCache) SetOnRemoved(f func(key Key, value Value, reason Reason)) {
+	c.mu.Lock()
+	c.c.OnRemoved = f
+	c.mu.Unlock()
+}
+
+// RemoveMatching removes the items whose key matches f, pinned or not, and
+// returns how many were removed.
+func (c *SyncCache) RemoveMatching(f func(key Key) bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.c.RemoveMatching(f)
+}
+
+// Keys returns the keys of the items in the cache that haven't expired, from
+// the most to the least recently used.
+func (c *SyncCache) Keys() []Key {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.c.Keys()
+}
+
+// Len returns the number of items in the cache.
+func (c *SyncCache) Len() int {
+	c.mu.Lock()
+	n := c.c.Len()
+	c.mu.Unlock()
+	return n
+}
+
+// Stats returns the usage statistics of the cache.
+func (c *SyncCache) Stats() Stats {
+	c.mu.Lock()
+	stats := c.c.Stats()
+	c.mu.Unlock()
+	return stats
+}
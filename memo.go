@@ -0,0 +1,147 @@
+package httpcache
+
+import (
+	"container/list"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+)
+
+// Memo memoizes the values decoded by the application from the responses
+// fetched through a Transport, such as parsed JSON structs, keyed by the
+// cache key and the validator of the responses, so that when a response is
+// served from the cache or revalidated with 304 Not Modified, its value is
+// reused instead of being parsed again. One value is kept per key, the one
+// of its latest response, for at most MaxEntries keys.
+type Memo[T any] struct {
+	// Transport fetches the responses
+	Transport *Transport
+	// Decode returns the value of a response. It needn't read the body to
+	// the end, nor close it.
+	Decode func(resp *http.Response) (T, error)
+	// MaxEntries is the maximum number of values memoized, the least
+	// recently used ones being dropped first, defaulting to 1000
+	MaxEntries int
+
+	mu     sync.Mutex
+	ll     *list.List               // of *memoValue, the most recently used first
+	values map[string]*list.Element // by key
+}
+
+// memoValue is a value decoded from the response with a validator.
+type memoValue[T any] struct {
+	key       string
+	validator string
+	value     T
+}
+
+// NewMemo returns a Memo of the values decoded by decode from the responses
+// fetched through t.
+func NewMemo[T any](t *Transport, decode func(resp *http.Response) (T, error)) *Memo[T] {
+	return &Memo[T]{Transport: t, Decode: decode}
+}
+
+// Get fetches the resource at u, and returns its decoded value.
+func (m *Memo[T]) Get(ctx context.Context, u string) (T, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return m.Do(req)
+}
+
+// Do sends req, and returns the decoded value of its response, reusing the
+// one decoded from a previous response with the same validator. Only the
+// values of the 200 OK responses with an ETag or a Last-Modified header are
+// memoized.
+func (m *Memo[T]) Do(req *http.Request) (T, error) {
+	resp, err := m.Transport.RoundTrip(req)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	// Reading the body to the end lets the response be stored
+	defer func() {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	key := m.Transport.key(req)
+	validator := responseValidator(resp)
+	if validator != "" && resp.StatusCode == http.StatusOK {
+		if value, ok := m.lookup(key, validator); ok {
+			return value, nil
+		}
+	}
+	value, err := m.Decode(resp)
+	if err != nil || validator == "" || resp.StatusCode != http.StatusOK {
+		return value, err
+	}
+	m.add(&memoValue[T]{key: key, validator: validator, value: value})
+	return value, nil
+}
+
+// lookup returns the value memoized at key for the response with validator,
+// if any, marking it as recently used.
+func (m *Memo[T]) lookup(key, validator string) (value T, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	elt, ok := m.values[key]
+	if !ok || elt.Value.(*memoValue[T]).validator != validator {
+		return value, false
+	}
+	m.ll.MoveToFront(elt)
+	return elt.Value.(*memoValue[T]).value, true
+}
+
+// add memoizes memo, replacing the value of its key, and drops the least
+// recently used values beyond MaxEntries.
+func (m *Memo[T]) add(memo *memoValue[T]) {
+	maxEntries := m.MaxEntries
+	if maxEntries <= 0 {
+		maxEntries = 1000
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.values == nil {
+		m.ll = list.New()
+		m.values = make(map[string]*list.Element)
+	}
+	if elt, ok := m.values[memo.key]; ok {
+		elt.Value = memo
+		m.ll.MoveToFront(elt)
+		return
+	}
+	m.values[memo.key] = m.ll.PushFront(memo)
+	for m.ll.Len() > maxEntries {
+		oldest := m.ll.Back()
+		m.ll.Remove(oldest)
+		delete(m.values, oldest.Value.(*memoValue[T]).key)
+	}
+}
+
+// Forget drops the value memoized for the response to req, if any.
+func (m *Memo[T]) Forget(req *http.Request) {
+	key := m.Transport.key(req)
+	m.mu.Lock()
+	if elt, ok := m.values[key]; ok {
+		m.ll.Remove(elt)
+		delete(m.values, key)
+	}
+	m.mu.Unlock()
+}
+
+// responseValidator returns the strongest validator of resp, or an empty
+// string if it has none.
+func responseValidator(resp *http.Response) string {
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		return "etag " + etag
+	}
+	if lastModified := resp.Header.Get("Last-Modified"); lastModified != "" {
+		return "last-modified " + lastModified
+	}
+	return ""
+}
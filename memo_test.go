@@ -0,0 +1,75 @@
+package httpcache
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMemo(t *testing.T) {
+	resetTest()
+	version := "1"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		etag := `"` + version + `"`
+		w.Header().Set("Cache-Control", "max-age=0")
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"version": version})
+	}))
+	defer server.Close()
+
+	type doc struct{ Version string }
+	decoded := 0
+	memo := NewMemo(NewMemoryCacheTransport(defaultMaxEntries), func(resp *http.Response) (*doc, error) {
+		decoded++
+		var d doc
+		err := json.NewDecoder(resp.Body).Decode(&d)
+		return &d, err
+	})
+	get := func() *doc {
+		d, err := memo.Get(context.Background(), server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	first := get()
+	if second := get(); second != first || decoded != 1 {
+		t.Fatalf("revalidated response decoded again, %d times", decoded)
+	}
+	version = "2"
+	if d := get(); d.Version != "2" || decoded != 2 {
+		t.Fatalf("got version %q after %d decodings, want the new one", d.Version, decoded)
+	}
+}
+
+func TestMemoMaxEntries(t *testing.T) {
+	resetTest()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Header().Set("ETag", `"1"`)
+		json.NewEncoder(w).Encode(map[string]string{"version": r.URL.Path})
+	}))
+	defer server.Close()
+
+	decoded := 0
+	memo := NewMemo(NewMemoryCacheTransport(defaultMaxEntries), func(resp *http.Response) (string, error) {
+		decoded++
+		return resp.Request.URL.Path, nil
+	})
+	memo.MaxEntries = 2
+	for _, path := range []string{"/a", "/b", "/a", "/c", "/a", "/b"} {
+		if _, err := memo.Get(context.Background(), server.URL+path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// /b is dropped for /c, and decoded again
+	if decoded != 4 || len(memo.values) != 2 {
+		t.Fatalf("decoded %d times with %d values memoized, want 4 and 2", decoded, len(memo.values))
+	}
+}
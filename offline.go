@@ -0,0 +1,30 @@
+package httpcache
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+type offlineKey struct{}
+
+// WithOffline returns a new context based on ctx, for requests handled as if
+// their Transport was Offline.
+func WithOffline(ctx context.Context) context.Context {
+	return context.WithValue(ctx, offlineKey{}, true)
+}
+
+// isOffline reports whether ctx is marked by WithOffline.
+func isOffline(ctx context.Context) bool {
+	return ctx.Value(offlineKey{}) != nil
+}
+
+// isNetworkError reports whether err comes from the network, rather than
+// from the request or its context.
+func isNetworkError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
@@ -0,0 +1,142 @@
+package httpcache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// failingTransport fails all the requests with a network error.
+type failingTransport struct{}
+
+func (failingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return nil, &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+}
+
+func TestOffline(t *testing.T) {
+	resetTest()
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Cache-Control", "max-age=0")
+		w.Header().Set("ETag", `"1"`)
+		io.WriteString(w, "body")
+	}))
+	defer server.Close()
+
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	get := func(ctx context.Context, header http.Header) (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		resp, err := tp.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		return resp, nil
+	}
+	if _, err := get(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := get(WithOffline(context.Background()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Header.Get(XFromCache) != CacheStale || requests != 1 {
+		t.Fatalf("got X-From-Cache %q after %d requests, want a stale response", resp.Header.Get(XFromCache), requests)
+	}
+
+	tp.Offline = true
+	tp.Transport = failingTransport{}
+	resp, err = get(context.Background(), http.Header{"Cache-Control": {"no-cache"}})
+	if err != nil {
+		t.Fatalf("network error not masked: %v", err)
+	}
+	if resp.Header.Get(XFromCache) != CacheStale {
+		t.Fatalf("got X-From-Cache %q, want a stale response", resp.Header.Get(XFromCache))
+	}
+
+	tp.Offline = false
+	if _, err := get(context.Background(), nil); err == nil {
+		t.Fatal("network error masked when online")
+	}
+}
+
+func TestMustRevalidate(t *testing.T) {
+	resetTest()
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Cache-Control", "max-age=0, must-revalidate")
+		w.Header().Set("ETag", `"1"`)
+		io.WriteString(w, "body")
+	}))
+	defer server.Close()
+
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	tp.MaxStale = time.Hour
+	tp.OnlyIfCachedMiss = OnlyIfCachedStale
+	get := func(ctx context.Context, cacheControl string) (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cacheControl != "" {
+			req.Header.Set("Cache-Control", cacheControl)
+		}
+		resp, err := tp.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		return resp, nil
+	}
+	if _, err := get(context.Background(), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, cacheControl := range []string{"", "max-stale", "max-stale=3600"} {
+		resp, err := get(WithOffline(context.Background()), cacheControl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Header.Get(XFromCache) == CacheStale {
+			t.Fatalf("Cache-Control %q: must-revalidate response served stale", cacheControl)
+		}
+	}
+	if requests != 4 {
+		t.Fatalf("got %d requests, want 4", requests)
+	}
+
+	resp, err := get(context.Background(), "only-if-cached")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusGatewayTimeout {
+		t.Fatalf("got status %d for only-if-cached, want 504", resp.StatusCode)
+	}
+
+	tp.Offline = true
+	tp.Transport = failingTransport{}
+	resp, err = get(context.Background(), "")
+	if err != nil {
+		t.Fatalf("failed revalidation returned %v, want a 504", err)
+	}
+	if resp.StatusCode != http.StatusGatewayTimeout {
+		t.Fatalf("got status %d for a failed revalidation, want 504", resp.StatusCode)
+	}
+}
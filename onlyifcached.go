@@ -0,0 +1,49 @@
+package httpcache
+
+import (
+	"errors"
+	"net/http"
+)
+
+// ErrNotCached is returned for the requests with an only-if-cached directive
+// that can't be served from the cache, when the Transport's OnlyIfCachedMiss
+// is OnlyIfCachedError.
+var ErrNotCached = errors.New("httpcache: response not cached")
+
+// An OnlyIfCachedMode tells how a Transport answers the requests with an
+// only-if-cached directive that it can't serve from the cache.
+type OnlyIfCachedMode int
+
+const (
+	// OnlyIfCachedGatewayTimeout answers with an empty 504 Gateway Timeout,
+	// as recommended by RFC 9111
+	OnlyIfCachedGatewayTimeout OnlyIfCachedMode = iota
+	// OnlyIfCachedError fails the requests with ErrNotCached
+	OnlyIfCachedError
+	// OnlyIfCachedStale serves the cached response even if it is stale,
+	// marked with CacheStale, and answers with a 504 Gateway Timeout when
+	// nothing is cached, or when the cached response has a must-revalidate
+	// directive
+	OnlyIfCachedStale
+)
+
+// onlyIfCached reports whether req has an only-if-cached directive.
+func onlyIfCached(req *http.Request) bool {
+	return ParseCacheControl(req.Header).Has("only-if-cached")
+}
+
+// onlyIfCachedMiss answers req, with an only-if-cached directive, for which
+// cachedResp, if not nil, can't be served as is.
+func (t *Transport) onlyIfCachedMiss(req *http.Request, key string, cachedResp *http.Response) (*http.Response, error) {
+	switch {
+	case t.OnlyIfCachedMiss == OnlyIfCachedError:
+		return nil, ErrNotCached
+	case t.OnlyIfCachedMiss == OnlyIfCachedStale && cachedResp != nil:
+		t.logDecision(req, key, "stale", "only-if-cached request directive")
+		t.countServed(req, key, cachedResp, false)
+		t.mark(cachedResp, CacheStale)
+		t.transform(req, cachedResp)
+		return cachedResp, nil
+	}
+	return newGatewayTimeoutResponse(req), nil
+}
@@ -0,0 +1,68 @@
+package httpcache
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOnlyIfCachedMiss(t *testing.T) {
+	resetTest()
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("ETag", `"1"`)
+		io.WriteString(w, "body")
+	}))
+	defer server.Close()
+
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	get := func(path string) (*http.Response, error) {
+		req, err := http.NewRequest(http.MethodGet, server.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Cache-Control", "only-if-cached")
+		return tp.RoundTrip(req)
+	}
+	resp, err := tp.Client().Get(server.URL + "/cached")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	for _, path := range []string{"/cached", "/missing"} {
+		resp, err := get(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusGatewayTimeout {
+			t.Fatalf("%s: got status %d, want 504", path, resp.StatusCode)
+		}
+	}
+
+	tp.OnlyIfCachedMiss = OnlyIfCachedError
+	if _, err := get("/cached"); !errors.Is(err, ErrNotCached) {
+		t.Fatalf("got error %v, want ErrNotCached", err)
+	}
+
+	tp.OnlyIfCachedMiss = OnlyIfCachedStale
+	resp, err = get("/cached")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get(XFromCache) != CacheStale {
+		t.Fatalf("got status %d and X-From-Cache %q, want the stale response", resp.StatusCode, resp.Header.Get(XFromCache))
+	}
+	if resp, err := get("/missing"); err != nil || resp.StatusCode != http.StatusGatewayTimeout {
+		t.Fatalf("got %v, %v for a missing response, want 504", resp, err)
+	}
+	if requests != 1 {
+		t.Fatalf("server contacted %d times, want 1", requests)
+	}
+}
@@ -0,0 +1,154 @@
+// Package otelmetrics reports the activity of an httpcache.Transport as
+// OpenTelemetry metrics, for applications exporting them through OTLP.
+package otelmetrics
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cozy/httpcache"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Instrument makes t record the following instruments with meter:
+//
+//   - httpcache.requests: requests handled, by outcome (hit, miss,
+//     revalidated, modified or bypass)
+//   - httpcache.stores: responses written to the cache
+//   - httpcache.cache.errors: failed reads and writes of the cache, after
+//     which the requests fall back to the origin
+//   - httpcache.lookup.duration: time spent looking requests up in the cache
+//   - httpcache.origin.duration: time taken by the origin to answer
+//   - httpcache.origin.saved: origin time avoided by cache hits, estimated
+//     from the recent latencies of the same host
+//
+// The hooks already set on t are still called. Instrument replaces the
+// underlying RoundTripper of t with a wrapper, and must be called before t is
+// used.
+func Instrument(t *httpcache.Transport, meter metric.Meter) error {
+	requests, err := meter.Int64Counter("httpcache.requests",
+		metric.WithDescription("Requests handled by the cache, by outcome"))
+	if err != nil {
+		return err
+	}
+	stores, err := meter.Int64Counter("httpcache.stores",
+		metric.WithDescription("Responses written to the cache"))
+	if err != nil {
+		return err
+	}
+	cacheErrors, err := meter.Int64Counter("httpcache.cache.errors",
+		metric.WithDescription("Failed reads and writes of the cache"))
+	if err != nil {
+		return err
+	}
+	lookup, err := meter.Float64Histogram("httpcache.lookup.duration",
+		metric.WithDescription("Time spent looking requests up in the cache"),
+		metric.WithUnit("s"))
+	if err != nil {
+		return err
+	}
+	origin, err := meter.Float64Histogram("httpcache.origin.duration",
+		metric.WithDescription("Time taken by the origin to answer"),
+		metric.WithUnit("s"))
+	if err != nil {
+		return err
+	}
+	saved, err := meter.Float64Histogram("httpcache.origin.saved",
+		metric.WithDescription("Origin time avoided by cache hits"),
+		metric.WithUnit("s"))
+	if err != nil {
+		return err
+	}
+
+	latencies := &latencies{byHost: make(map[string]time.Duration)}
+	next := t.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	t.Transport = &timedTransport{next: next, histogram: origin, latencies: latencies}
+
+	count := func(outcome string) func(*http.Request, httpcache.Event) {
+		return func(req *http.Request, ev httpcache.Event) {
+			o := outcome
+			if ev.Modified {
+				o = "modified"
+			}
+			opt := metric.WithAttributes(attribute.String("httpcache.outcome", o))
+			requests.Add(req.Context(), 1, opt)
+			lookup.Record(req.Context(), ev.Lookup.Seconds(), opt)
+		}
+	}
+	t.OnHit = chain(t.OnHit, count("hit"), func(req *http.Request, ev httpcache.Event) {
+		if d, ok := latencies.get(req.URL.Host); ok {
+			saved.Record(req.Context(), d.Seconds(), hostAttribute(req))
+		}
+	})
+	t.OnMiss = chain(t.OnMiss, count("miss"))
+	t.OnRevalidate = chain(t.OnRevalidate, count("revalidated"))
+	t.OnBypass = chain(t.OnBypass, count("bypass"))
+	t.OnStore = chain(t.OnStore, func(req *http.Request, ev httpcache.Event) {
+		stores.Add(req.Context(), 1)
+	})
+	t.OnCacheError = chain(t.OnCacheError, func(req *http.Request, ev httpcache.Event) {
+		cacheErrors.Add(req.Context(), 1)
+	})
+	return nil
+}
+
+// chain returns a hook calling all the non-nil hooks in order.
+func chain(hooks ...func(*http.Request, httpcache.Event)) func(*http.Request, httpcache.Event) {
+	return func(req *http.Request, ev httpcache.Event) {
+		for _, h := range hooks {
+			if h != nil {
+				h(req, ev)
+			}
+		}
+	}
+}
+
+func hostAttribute(req *http.Request) metric.MeasurementOption {
+	return metric.WithAttributes(attribute.String("server.address", req.URL.Hostname()))
+}
+
+// timedTransport records how long the origin takes to answer.
+type timedTransport struct {
+	next      http.RoundTripper
+	histogram metric.Float64Histogram
+	latencies *latencies
+}
+
+func (t *timedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	d := time.Since(start)
+	t.histogram.Record(req.Context(), d.Seconds(), hostAttribute(req))
+	t.latencies.observe(req.URL.Host, d)
+	return resp, nil
+}
+
+// latencies keeps a moving average of the origin latency for each host.
+type latencies struct {
+	mu     sync.Mutex
+	byHost map[string]time.Duration
+}
+
+func (l *latencies) observe(host string, d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if avg, ok := l.byHost[host]; ok {
+		d = (4*avg + d) / 5
+	}
+	l.byHost[host] = d
+}
+
+func (l *latencies) get(host string) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	d, ok := l.byHost[host]
+	return d, ok
+}
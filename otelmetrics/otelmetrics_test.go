@@ -0,0 +1,122 @@
+package otelmetrics
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cozy/httpcache"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestInstrument(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Write([]byte("some content"))
+	}))
+	defer server.Close()
+
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	tp := httpcache.NewMemoryCacheTransport(10)
+	if err := Instrument(tp, provider.Meter("httpcache")); err != nil {
+		t.Fatal(err)
+	}
+	client := tp.Client()
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	found := map[string]bool{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			found[m.Name] = true
+		}
+	}
+	for _, name := range []string{
+		"httpcache.requests",
+		"httpcache.stores",
+		"httpcache.lookup.duration",
+		"httpcache.origin.duration",
+		"httpcache.origin.saved",
+	} {
+		if !found[name] {
+			t.Errorf("metric %s wasn't recorded", name)
+		}
+	}
+}
+
+func TestInstrumentOutcomes(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		// The second response is modified, the third one isn't
+		etag := `"v1"`
+		if requests >= 2 {
+			etag = `"v2"`
+		}
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("ETag", etag)
+		w.Write([]byte("some content"))
+	}))
+	defer server.Close()
+
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	tp := httpcache.NewMemoryCacheTransport(10)
+	if err := Instrument(tp, provider.Meter("httpcache")); err != nil {
+		t.Fatal(err)
+	}
+	client := tp.Client()
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]int64{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name != "httpcache.requests" {
+				continue
+			}
+			for _, dp := range m.Data.(metricdata.Sum[int64]).DataPoints {
+				outcome, _ := dp.Attributes.Value("httpcache.outcome")
+				got[outcome.AsString()] += dp.Value
+			}
+		}
+	}
+	want := map[string]int64{"miss": 1, "modified": 1, "revalidated": 1}
+	if len(got) != len(want) {
+		t.Fatalf("got requests %v, want %v", got, want)
+	}
+	for outcome, n := range want {
+		if got[outcome] != n {
+			t.Fatalf("got requests %v, want %v", got, want)
+		}
+	}
+}
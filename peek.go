@@ -0,0 +1,34 @@
+package httpcache
+
+import (
+	"context"
+	"net/http"
+)
+
+type peekKey struct{}
+
+// withPeek returns a new context based on ctx, for the lookups that mustn't
+// perturb the cache, see Peeker.
+func withPeek(ctx context.Context) context.Context {
+	return context.WithValue(ctx, peekKey{}, true)
+}
+
+// isPeek reports whether ctx is marked by withPeek.
+func isPeek(ctx context.Context) bool {
+	return ctx.Value(peekKey{}) != nil
+}
+
+// Peek returns the response cached for req, fresh or not, without contacting
+// the server nor perturbing the cache: the lookup isn't counted in the
+// statistics of t nor reported to its hooks, and the entry isn't marked as
+// recently used if the Cache of t is a Peeker. It is meant for the
+// monitoring and debugging tools, which mustn't distort the eviction of the
+// entries.
+func (t *Transport) Peek(req *http.Request) (*http.Response, bool) {
+	cachedResp, err := t.cachedResponse(t.key(req), req.WithContext(withPeek(req.Context())))
+	if err != nil || cachedResp == nil {
+		return nil, false
+	}
+	stripMarks(cachedResp.Header)
+	return cachedResp, true
+}
@@ -0,0 +1,47 @@
+package httpcache
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPeek(t *testing.T) {
+	resetTest()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=3600")
+		io.WriteString(w, r.URL.Path)
+	}))
+	defer server.Close()
+
+	tp := NewMemoryCacheTransport(2)
+	get := func(path string) {
+		resp, err := tp.Client().Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	get("/a")
+	get("/b")
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/a", nil)
+	resp, ok := tp.Peek(req)
+	if !ok {
+		t.Fatal("cached response not peeked")
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "/a" {
+		t.Fatalf("got %q, want the cached response", body)
+	}
+	if stats := tp.Stats(); stats.Hits != 0 {
+		t.Fatalf("got %d hits, want the peek uncounted", stats.Hits)
+	}
+	get("/c")
+	if _, ok := tp.Peek(req); ok {
+		t.Fatal("peeked response marked as recently used")
+	}
+}
@@ -0,0 +1,386 @@
+package httpcache
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Policy overrides how the responses are cached, so that one Transport can
+// talk to both a well-behaved CDN and a legacy API without caching headers,
+// with appropriate rules for each.
+type Policy struct {
+	// DefaultFreshness, if set, is the freshness lifetime of the responses
+	// without max-age nor Expires, so that they can be served without
+	// being revalidated every time
+	DefaultFreshness time.Duration
+	// MinFreshness and MaxFreshness, if set, clamp the freshness lifetime
+	// of the responses
+	MinFreshness, MaxFreshness time.Duration
+	// ForceCacheTTL, if set, makes the Transport cache all the responses it
+	// can store for this duration, ignoring the caching headers of the
+	// server, even no-cache: for scraping servers that we don't control.
+	// The Date header is added to the responses missing it.
+	ForceCacheTTL time.Duration
+	// ForceCacheIgnoreNoStore makes ForceCacheTTL apply to the responses
+	// with a no-store directive too
+	ForceCacheIgnoreNoStore bool
+	// ServerErrorGrace, if set, makes the Transport cache the 5xx responses
+	// for this short duration, e.g. a few seconds, to absorb retry storms
+	// during an outage of the server. They are marked with CacheGrace.
+	ServerErrorGrace time.Duration
+	// MaxEntrySize, if set, is the size in bytes of the largest response
+	// stored
+	MaxEntrySize int
+	// Shared makes the Transport behave as a shared cache, for a proxy:
+	// private responses and those to authorized requests are not stored,
+	// s-maxage takes precedence over max-age, and a response with a Vary
+	// header is only served to the requests with the same values of the
+	// headers it names
+	Shared bool
+	// SetCookie tells how the responses with a Set-Cookie header are
+	// stored, so that the session of a user isn't replayed to others. By
+	// default, they aren't.
+	SetCookie SetCookieMode
+	// LegacyExpires restores the handling of the invalid Expires headers,
+	// such as 0, of the previous versions: a zero freshness lifetime, that
+	// the clamps and the request directives apply to, instead of an already
+	// expired response
+	LegacyExpires bool
+	// MaxStale, if set, makes the Transport serve the responses stale by up
+	// to this duration without revalidating them, as if the requests had a
+	// max-stale directive, e.g. to lower the load of a server. It doesn't
+	// apply to the responses with a must-revalidate directive, nor to the
+	// requests with their own max-stale, max-age or min-fresh directive.
+	// They are marked with CacheStale.
+	MaxStale time.Duration
+	// AlwaysRevalidate makes the Transport revalidate the cached responses
+	// with validators even when they are fresh, for the APIs such as
+	// GitHub's where the 304 Not Modified responses don't count against
+	// the rate limits: the data is always current, at the cost of a round
+	// trip. The requests with an only-if-cached directive are still served
+	// from the cache.
+	AlwaysRevalidate bool
+	// HTTPSOnly makes the Transport store only the responses fetched over
+	// HTTPS, so that the plaintext responses, which may have been tampered
+	// with on the way, aren't persisted in long-lived shared caches. They
+	// are still served from the cache if they were stored before.
+	HTTPSOnly bool
+	// RevalidationInterval, if set, is the minimum interval between the
+	// revalidations of a cached response: meanwhile, it is served stale
+	// without contacting the server, to protect the server from the
+	// revalidation storms of the hot responses that are always stale. It
+	// doesn't apply to the responses with a must-revalidate directive. They
+	// are marked with CacheStale.
+	RevalidationInterval time.Duration
+	// StaleIfSlow, if set, is the latency budget of the revalidations of
+	// the stale responses: when the server doesn't answer within it, the
+	// stale response is served, marked with CacheStale, while the request
+	// completes in the background to refresh the cache. It protects the
+	// latency of the clients when the server slows down. It doesn't apply
+	// to the responses with a must-revalidate directive.
+	StaleIfSlow time.Duration
+	// TightDeadline, if set, makes the Transport serve the stale responses
+	// without revalidating them to the requests whose context has a
+	// deadline closer than TightDeadline, as the revalidation would likely
+	// exceed it, if they are stale by up to DeadlineMaxStale, when set. It
+	// doesn't apply to the responses with a must-revalidate directive. They
+	// are marked with CacheStale.
+	TightDeadline, DeadlineMaxStale time.Duration
+	// Lifetime, if set, returns the freshness lifetime of the cached
+	// responses with the headers respHeaders and the directives cc, e.g.
+	// from an extension directive, taking precedence over max-age and
+	// Expires. If it returns false, the lifetime is computed as usual.
+	Lifetime func(respHeaders http.Header, cc CacheControl) (time.Duration, bool)
+	// Storable, if set, is called with the directives cc of the responses
+	// that can be stored otherwise, and refuses to store them by returning
+	// false
+	Storable func(req *http.Request, resp *http.Response, cc CacheControl) bool
+	// StorableBody, if set, is called with the body of the responses to GET
+	// requests that can be stored otherwise, once it has been read, and
+	// refuses to store them by returning false, e.g. for the error
+	// envelopes that some APIs return with a 200 OK status
+	StorableBody func(req *http.Request, resp *http.Response, body []byte) bool
+}
+
+// A SetCookieMode tells how the responses with a Set-Cookie header are
+// stored.
+type SetCookieMode int
+
+const (
+	// SetCookieRefuse doesn't store the responses with a Set-Cookie header
+	SetCookieRefuse SetCookieMode = iota
+	// SetCookieStrip stores the responses without their Set-Cookie header,
+	// which is only sent to the client of the request fetching them
+	SetCookieStrip
+	// SetCookieStore stores the responses with their Set-Cookie header, to
+	// be replayed with them
+	SetCookieStore
+)
+
+// policy returns the Policy applying to the requests for u: the one of
+// t.Policies registered for the longest URL prefix of u, or else for its
+// host, or else the one made of the fields of t.
+func (t *Transport) policy(u *url.URL) Policy {
+	if len(t.Policies) > 0 {
+		rawurl := u.String()
+		best := ""
+		for pattern := range t.Policies {
+			if strings.Contains(pattern, "/") && strings.HasPrefix(rawurl, pattern) && len(pattern) > len(best) {
+				best = pattern
+			}
+		}
+		if best != "" {
+			return t.Policies[best]
+		}
+		if p, ok := t.Policies[u.Host]; ok {
+			return p
+		}
+	}
+	return Policy{
+		DefaultFreshness:        t.DefaultFreshness,
+		MinFreshness:            t.MinFreshness,
+		MaxFreshness:            t.MaxFreshness,
+		ForceCacheTTL:           t.ForceCacheTTL,
+		ForceCacheIgnoreNoStore: t.ForceCacheIgnoreNoStore,
+		ServerErrorGrace:        t.ServerErrorGrace,
+		MaxEntrySize:            t.MaxEntrySize,
+		Shared:                  t.Shared,
+		SetCookie:               t.SetCookie,
+		LegacyExpires:           t.LegacyExpires,
+		MaxStale:                t.MaxStale,
+		AlwaysRevalidate:        t.AlwaysRevalidate,
+		HTTPSOnly:               t.HTTPSOnly,
+		RevalidationInterval:    t.RevalidationInterval,
+		StaleIfSlow:             t.StaleIfSlow,
+		TightDeadline:           t.TightDeadline,
+		DeadlineMaxStale:        t.DeadlineMaxStale,
+		Lifetime:                t.Lifetime,
+		Storable:                t.Storable,
+		StorableBody:            t.StorableBody,
+	}
+}
+
+// tightDeadline reports whether the stale response described by info can be
+// served to a request with the context ctx without revalidation, see
+// TightDeadline.
+func (p Policy) tightDeadline(ctx context.Context, info *freshnessInfo) bool {
+	if p.TightDeadline <= 0 {
+		return false
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok || time.Until(deadline) >= p.TightDeadline {
+		return false
+	}
+	return p.DeadlineMaxStale <= 0 || info.age-info.lifetime <= p.DeadlineMaxStale
+}
+
+// freshnessOptions returns the options of the evaluation of the freshness of
+// the responses under p, according to the timer c.
+func (p Policy) freshnessOptions(c timer) freshnessOptions {
+	return freshnessOptions{
+		defaultLifetime: p.DefaultFreshness,
+		minLifetime:     p.MinFreshness,
+		maxLifetime:     p.MaxFreshness,
+		shared:          p.Shared,
+		legacyExpires:   p.LegacyExpires,
+		maxStale:        p.MaxStale,
+		lifetime:        p.Lifetime,
+		clock:           c,
+	}
+}
+
+// evaluateFreshness evaluates the freshness of a cached response like the
+// function of the same name, under p and according to the timer c.
+func (p Policy) evaluateFreshness(c timer, respHeaders, reqHeaders http.Header) freshnessInfo {
+	if grace := respHeaders.Get(xGrace); grace != "" {
+		return evaluateGrace(c, respHeaders, grace)
+	}
+	if lifetime, ok := markedLifetime(respHeaders); ok {
+		return evaluateForced(c, respHeaders, reqHeaders, lifetime, "request lifetime")
+	}
+	if p.ForceCacheTTL <= 0 {
+		info := evaluateFreshnessWith(respHeaders, reqHeaders, p.freshnessOptions(c))
+		if p.AlwaysRevalidate && info.freshness == fresh && !ParseCacheControl(reqHeaders).Has("only-if-cached") &&
+			(respHeaders.Get("etag") != "" || respHeaders.Get("last-modified") != "") {
+			info.freshness = stale
+			info.reason = "always revalidated, " + info.reason
+		}
+		return info
+	}
+	return evaluateForced(c, respHeaders, reqHeaders, p.ForceCacheTTL, "forced TTL")
+}
+
+// evaluateForced evaluates the freshness of a cached response with the
+// headers respHeaders, whose lifetime is forced to lifetime by the given
+// source, according to the timer c.
+func evaluateForced(c timer, respHeaders, reqHeaders http.Header, lifetime time.Duration, source string) freshnessInfo {
+	reqCacheControl := ParseCacheControl(reqHeaders)
+	if _, ok := reqCacheControl["no-cache"]; ok {
+		return freshnessInfo{freshness: transparent, reason: "no-cache request directive"}
+	}
+	if _, ok := reqCacheControl["only-if-cached"]; ok {
+		return freshnessInfo{freshness: fresh, reason: "only-if-cached request directive"}
+	}
+	if respHeaders.Get(xSoftPurged) != "" {
+		return freshnessInfo{freshness: stale, reason: "soft purged"}
+	}
+	date, ok := parseDate(respHeaders)
+	if !ok {
+		return freshnessInfo{freshness: stale, reason: "missing or invalid Date header"}
+	}
+	info := freshnessInfo{age: c.since(date), lifetime: lifetime}
+	if info.lifetime > info.age {
+		info.freshness = fresh
+		info.reason = fmt.Sprintf("%s left of %s", info.lifetime-info.age, source)
+	} else {
+		info.freshness = stale
+		info.reason = fmt.Sprintf("%s exceeded by %s", source, info.age-info.lifetime)
+	}
+	return info
+}
+
+// ttlHint returns how long a response can be of any use to the cache, like
+// the function of the same name, under p and according to the timer c.
+func (p Policy) ttlHint(c timer, respHeaders http.Header) time.Duration {
+	if respHeaders.Get(xGrace) != "" {
+		return p.ServerErrorGrace
+	}
+	if !p.mustRevalidate(respHeaders) {
+		// Once stale, the response can still be served by MaxStale, to the
+		// requests with a max-stale directive, offline or on errors
+		return 0
+	}
+	if lifetime, ok := markedLifetime(respHeaders); ok {
+		return forcedTTLHint(c, respHeaders, lifetime)
+	}
+	if p.Lifetime != nil {
+		// The lifetime may be unrelated to the headers
+		return 0
+	}
+	return ttlHintWith(c, respHeaders, p.Shared)
+}
+
+// forcedTTLHint returns the TTL hint of a response with the headers
+// respHeaders, whose lifetime is forced to lifetime, according to the timer
+// c.
+func forcedTTLHint(c timer, respHeaders http.Header, lifetime time.Duration) time.Duration {
+	if respHeaders.Get("etag") != "" || respHeaders.Get("last-modified") != "" {
+		return 0
+	}
+	date, ok := parseDate(respHeaders)
+	if !ok {
+		return 0
+	}
+	if remaining := lifetime - c.since(date); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+// mustRevalidate reports whether the responses with the headers respHeaders
+// must be validated by the server once stale under p, instead of being served
+// stale by max-stale, offline mode or on errors.
+func (p Policy) mustRevalidate(respHeaders http.Header) bool {
+	if p.ForceCacheTTL > 0 {
+		return false
+	}
+	return mustRevalidate(ParseCacheControl(respHeaders), p.Shared)
+}
+
+// stripped returns the headers of the responses that aren't stored under p.
+func (p Policy) stripped() []string {
+	if p.SetCookie == SetCookieStore {
+		return nil
+	}
+	return []string{"Set-Cookie"}
+}
+
+// xGrace is the header marking the server errors cached for a grace period,
+// holding its duration in milliseconds. It is only stored in the cache, never
+// served.
+const xGrace = "X-Httpcache-Grace"
+
+// marks returns the internal headers to store with resp, the response to
+// req, under p.
+func (p Policy) marks(req *http.Request, resp *http.Response) http.Header {
+	var marks http.Header
+	if p.ServerErrorGrace > 0 && resp.StatusCode >= 500 && resp.StatusCode < 600 {
+		marks = http.Header{xGrace: {strconv.Itoa(int(p.ServerErrorGrace / time.Millisecond))}}
+	}
+	return p.withVaried(marks, req, resp.Header)
+}
+
+// evaluateGrace evaluates the freshness of a server error cached for a grace
+// period of grace milliseconds, according to the timer c.
+func evaluateGrace(c timer, respHeaders http.Header, grace string) freshnessInfo {
+	ms, err := strconv.Atoi(grace)
+	date, ok := parseDate(respHeaders)
+	if err != nil || !ok {
+		return freshnessInfo{freshness: stale, reason: "invalid grace period"}
+	}
+	info := freshnessInfo{age: c.since(date), lifetime: time.Duration(ms) * time.Millisecond}
+	if info.lifetime > info.age {
+		info.freshness = fresh
+		info.grace = true
+		info.reason = fmt.Sprintf("%s left of server error grace", info.lifetime-info.age)
+	} else {
+		info.freshness = stale
+		info.reason = fmt.Sprintf("server error grace exceeded by %s", info.age-info.lifetime)
+	}
+	return info
+}
+
+// cannotStoreReason returns why a response to req can't be stored under p, in
+// addition to the rules of the function of the same name, or an empty string
+// if it can be. now is the current time, that dates the responses without a
+// Date header when their lifetime is forced.
+func (p Policy) cannotStoreReason(req *http.Request, resp *http.Response, now time.Time) string {
+	if p.HTTPSOnly && req.URL.Scheme != "https" {
+		return "fetched over plain HTTP"
+	}
+	respCacheControl := ParseCacheControl(resp.Header)
+	if _, ok := contextLifetime(req.Context()); ok && resp.Header.Get("Date") == "" {
+		resp.Header.Set("Date", now.UTC().Format(http.TimeFormat))
+	}
+	if p.ForceCacheTTL > 0 {
+		if p.ForceCacheIgnoreNoStore {
+			delete(respCacheControl, "no-store")
+		}
+		if resp.Header.Get("Date") == "" {
+			resp.Header.Set("Date", now.UTC().Format(http.TimeFormat))
+		}
+	}
+	if p.ServerErrorGrace > 0 && resp.StatusCode >= 500 && resp.StatusCode < 600 {
+		if respCacheControl.Has("no-store") {
+			return "no-store response directive"
+		}
+		if resp.Header.Get("Date") == "" {
+			resp.Header.Set("Date", now.UTC().Format(http.TimeFormat))
+		}
+		return ""
+	}
+	if reason := cannotStoreReason(resp.StatusCode, ParseCacheControl(req.Header), respCacheControl); reason != "" {
+		return reason
+	}
+	if p.SetCookie == SetCookieRefuse && resp.Header.Get("Set-Cookie") != "" {
+		return "Set-Cookie response header"
+	}
+	if p.Shared {
+		if respCacheControl.Has("private") {
+			return "private response directive in a shared cache"
+		}
+		if req.Header.Get("Authorization") != "" && !respCacheControl.Has("public") &&
+			!respCacheControl.Has("s-maxage") && !respCacheControl.Has("must-revalidate") {
+			return "Authorization request header in a shared cache"
+		}
+	}
+	if p.Storable != nil && !p.Storable(req, resp, respCacheControl) {
+		return "refused by the policy"
+	}
+	return ""
+}
@@ -0,0 +1,598 @@
+package httpcache
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestForceCacheTTL(t *testing.T) {
+	resetTest()
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.Path {
+		case "/no-cache":
+			w.Header().Set("Cache-Control", "no-cache")
+		case "/no-store":
+			w.Header().Set("Cache-Control", "no-store")
+		}
+		io.WriteString(w, "body")
+	}))
+	defer server.Close()
+
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	tp.ForceCacheTTL = time.Minute
+	get := func(path string) string {
+		resp, err := tp.Client().Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		return resp.Header.Get(XFromCache)
+	}
+	for _, path := range []string{"/", "/no-cache", "/no-store"} {
+		get(path)
+	}
+	if get("/") != CacheHit || get("/no-cache") != CacheHit || get("/no-store") != "" {
+		t.Fatal("responses not forced into the cache, or no-store ignored")
+	}
+	if requests != 4 {
+		t.Fatalf("got %d requests, want 4", requests)
+	}
+
+	tp.ForceCacheIgnoreNoStore = true
+	get("/no-store")
+	if get("/no-store") != CacheHit {
+		t.Fatal("no-store response not forced into the cache")
+	}
+
+	clock = &fakeClock{elapsed: 2 * time.Minute}
+	if get("/") != "" {
+		t.Fatal("response served after the forced TTL")
+	}
+}
+
+func TestForceCacheTTLClock(t *testing.T) {
+	resetTest()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header()["Date"] = nil
+		io.WriteString(w, "body")
+	}))
+	defer server.Close()
+
+	// The responses without a Date header are dated by the clock of the
+	// Transport
+	now := time.Now().Add(-time.Hour)
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	tp.Now = func() time.Time { return now }
+	tp.ForceCacheTTL = time.Minute
+	get := func() string {
+		resp, err := tp.Client().Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		return resp.Header.Get(XFromCache)
+	}
+	get()
+	if get() != CacheHit {
+		t.Fatal("response not forced into the cache")
+	}
+	now = now.Add(2 * time.Minute)
+	if get() != "" {
+		t.Fatal("response served after the forced TTL")
+	}
+}
+
+func TestDefaultFreshness(t *testing.T) {
+	resetTest()
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	tp.DefaultFreshness = time.Minute
+	reqHeaders := http.Header{}
+	respHeaders := http.Header{"Date": {time.Now().UTC().Format(http.TimeFormat)}}
+
+	clock = &fakeClock{elapsed: 30 * time.Second}
+	if info := tp.policy(nil).evaluateFreshness(clock, respHeaders, reqHeaders); info.freshness != fresh || info.reason != "30s left of default freshness" {
+		t.Fatalf("got %s, want fresh by default", info)
+	}
+	respHeaders.Set("Cache-Control", "max-age=10")
+	if info := tp.policy(nil).evaluateFreshness(clock, respHeaders, reqHeaders); info.freshness != stale {
+		t.Fatalf("got %s, want max-age to take precedence", info)
+	}
+	respHeaders.Del("Cache-Control")
+	clock = &fakeClock{elapsed: 2 * time.Minute}
+	if info := tp.policy(nil).evaluateFreshness(clock, respHeaders, reqHeaders); info.freshness != stale {
+		t.Fatalf("got %s, want stale after the default freshness", info)
+	}
+}
+
+func TestMaxStale(t *testing.T) {
+	resetTest()
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	tp.MaxStale = time.Minute
+	respHeaders := http.Header{
+		"Date":          {time.Now().UTC().Format(http.TimeFormat)},
+		"Cache-Control": {"max-age=10"},
+	}
+	clock = &fakeClock{elapsed: 30 * time.Second}
+	info := tp.policy(nil).evaluateFreshness(clock, respHeaders, http.Header{})
+	if info.freshness != fresh || !info.staleServed {
+		t.Fatalf("got %s, want stale served", info)
+	}
+	if info := tp.policy(nil).evaluateFreshness(clock, respHeaders, http.Header{"Cache-Control": {"max-stale=5"}}); info.freshness != stale {
+		t.Fatalf("got %s, want the max-stale request directive to take precedence", info)
+	}
+	respHeaders.Set("Cache-Control", "max-age=10, must-revalidate")
+	if info := tp.policy(nil).evaluateFreshness(clock, respHeaders, http.Header{}); info.freshness != stale {
+		t.Fatalf("got %s, want must-revalidate honored", info)
+	}
+	respHeaders.Set("Cache-Control", "max-age=10")
+	clock = &fakeClock{elapsed: 2 * time.Minute}
+	if info := tp.policy(nil).evaluateFreshness(clock, respHeaders, http.Header{}); info.freshness != stale {
+		t.Fatalf("got %s, want stale beyond the maximum staleness", info)
+	}
+}
+
+func TestAlwaysRevalidate(t *testing.T) {
+	resetTest()
+	conditional := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Header().Set("ETag", `"1"`)
+		if r.Header.Get("If-None-Match") == `"1"` {
+			conditional++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		io.WriteString(w, "body")
+	}))
+	defer server.Close()
+
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	get := func(cacheControl string) string {
+		req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cacheControl != "" {
+			req.Header.Set("Cache-Control", cacheControl)
+		}
+		resp, err := tp.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		return resp.Header.Get(XFromCache)
+	}
+	get("")
+	if get("") != CacheHit {
+		t.Fatal("fresh response not served without the mode")
+	}
+	tp.Policies = map[string]Policy{strings.TrimPrefix(server.URL, "http://"): {AlwaysRevalidate: true}}
+	if get("") != CacheRevalidated || conditional != 1 {
+		t.Fatalf("fresh response not revalidated, after %d conditional requests", conditional)
+	}
+	if get("only-if-cached") != CacheHit {
+		t.Fatal("only-if-cached request not served from the cache")
+	}
+}
+
+func TestHTTPSOnly(t *testing.T) {
+	resetTest()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=3600")
+		io.WriteString(w, "body")
+	})
+	plain := httptest.NewServer(handler)
+	defer plain.Close()
+	secure := httptest.NewTLSServer(handler)
+	defer secure.Close()
+
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	tp.Transport = secure.Client().Transport
+	tp.HTTPSOnly = true
+	get := func(rawurl string) string {
+		resp, err := tp.Client().Get(rawurl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		return resp.Header.Get(XFromCache)
+	}
+	get(plain.URL)
+	if get(plain.URL) != "" {
+		t.Fatal("response fetched over HTTP stored")
+	}
+	get(secure.URL)
+	if get(secure.URL) != CacheHit {
+		t.Fatal("response fetched over HTTPS not stored")
+	}
+}
+
+func TestRevalidationInterval(t *testing.T) {
+	resetTest()
+	conditional := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=0")
+		w.Header().Set("ETag", `"1"`)
+		if r.Header.Get("If-None-Match") == `"1"` {
+			conditional++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		io.WriteString(w, "body")
+	}))
+	defer server.Close()
+
+	now := time.Now()
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	tp.Now = func() time.Time { return now }
+	tp.RevalidationInterval = 10 * time.Second
+	get := func(cacheControl string) string {
+		req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cacheControl != "" {
+			req.Header.Set("Cache-Control", cacheControl)
+		}
+		resp, err := tp.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		return resp.Header.Get(XFromCache)
+	}
+	get("")
+	if got := get(""); got != CacheRevalidated || conditional != 1 {
+		t.Fatalf("got %q after %d conditional requests, want a revalidation", got, conditional)
+	}
+	now = now.Add(5 * time.Second)
+	if got := get(""); got != CacheStale || conditional != 1 {
+		t.Fatalf("got %q after %d conditional requests, want a stale response", got, conditional)
+	}
+	now = now.Add(6 * time.Second)
+	if got := get(""); got != CacheRevalidated || conditional != 2 {
+		t.Fatalf("got %q after %d conditional requests, want a revalidation once the interval elapsed", got, conditional)
+	}
+}
+
+func TestTightDeadline(t *testing.T) {
+	resetTest()
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Cache-Control", "max-age=60")
+		io.WriteString(w, "body")
+	}))
+	defer server.Close()
+
+	now := time.Now()
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	tp.Now = func() time.Time { return now }
+	tp.TightDeadline = time.Second
+	tp.DeadlineMaxStale = 30 * time.Second
+	get := func(timeout time.Duration) string {
+		ctx := context.Background()
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := tp.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		return resp.Header.Get(XFromCache)
+	}
+	get(0)
+	now = now.Add(70 * time.Second)
+	if got := get(500 * time.Millisecond); got != CacheStale || requests != 1 {
+		t.Fatalf("got %q after %d requests, want a stale response", got, requests)
+	}
+	if got := get(10 * time.Second); got != "" || requests != 2 {
+		t.Fatalf("got %q after %d requests, want the response of the server with a loose deadline", got, requests)
+	}
+	now = now.Add(120 * time.Second)
+	if got := get(500 * time.Millisecond); got != "" || requests != 3 {
+		t.Fatalf("got %q after %d requests, want the response of the server when too stale", got, requests)
+	}
+}
+
+func TestPolicyHooks(t *testing.T) {
+	resetTest()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/vendor":
+			w.Header().Set("Cache-Control", "max-age=0, x-vendor-ttl=60")
+		case "/private":
+			w.Header().Set("Cache-Control", "max-age=60, x-vendor-private")
+		}
+		io.WriteString(w, "body")
+	}))
+	defer server.Close()
+
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	tp.Policies = map[string]Policy{
+		strings.TrimPrefix(server.URL, "http://"): {
+			Lifetime: func(respHeaders http.Header, cc CacheControl) (time.Duration, bool) {
+				seconds, err := strconv.Atoi(cc["x-vendor-ttl"])
+				return time.Duration(seconds) * time.Second, err == nil
+			},
+			Storable: func(req *http.Request, resp *http.Response, cc CacheControl) bool {
+				return !cc.Has("x-vendor-private")
+			},
+		},
+	}
+	get := func(path string) string {
+		resp, err := tp.Client().Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		return resp.Header.Get(XFromCache)
+	}
+	for _, path := range []string{"/vendor", "/private"} {
+		get(path)
+	}
+	if get("/vendor") != CacheHit {
+		t.Fatal("lifetime of the extension directive not used")
+	}
+	if _, ok := tp.Cache.Get(server.URL + "/private"); ok {
+		t.Fatal("response refused by the policy stored")
+	}
+}
+
+func TestStorableBody(t *testing.T) {
+	resetTest()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=3600")
+		if r.URL.Path == "/error" {
+			w.Write([]byte(`{"error": "quota exceeded"}`))
+			return
+		}
+		w.Write([]byte(`{"data": 1}`))
+	}))
+	defer server.Close()
+
+	storable := func(req *http.Request, resp *http.Response, body []byte) bool {
+		return !bytes.Contains(body, []byte(`"error":`))
+	}
+	// The hook applies from the Policy of a URL prefix, or from the fields
+	// of the default one
+	perPrefix := NewMemoryCacheTransport(defaultMaxEntries)
+	perPrefix.Policies = map[string]Policy{server.URL + "/": {StorableBody: storable}}
+	byDefault := NewMemoryCacheTransport(defaultMaxEntries)
+	byDefault.StorableBody = storable
+	for _, tp := range []*Transport{perPrefix, byDefault} {
+		get := func(path string) string {
+			resp, err := tp.Client().Get(server.URL + path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+			return resp.Header.Get(XFromCache)
+		}
+		for _, path := range []string{"/data", "/error"} {
+			get(path)
+		}
+		if get("/data") != CacheHit {
+			t.Fatal("response with an accepted body not stored")
+		}
+		if get("/error") != "" {
+			t.Fatal("response with a refused body stored")
+		}
+	}
+}
+
+func TestPolicies(t *testing.T) {
+	resetTest()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/cdn":
+			w.Header().Set("Cache-Control", "max-age=10, s-maxage=600")
+		case "/private":
+			w.Header().Set("Cache-Control", "private, max-age=600")
+		case "/big":
+			w.Header().Set("Cache-Control", "max-age=600")
+			io.WriteString(w, strings.Repeat("x", 1000))
+		case "/legacy/big":
+			io.WriteString(w, strings.Repeat("x", 1000))
+		}
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	tp.Policies = map[string]Policy{
+		u.Host:                  {Shared: true, MaxFreshness: 5 * time.Minute},
+		server.URL + "/legacy/": {DefaultFreshness: time.Hour, MaxEntrySize: 500},
+	}
+	get := func(path string) string {
+		resp, err := tp.Client().Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		return resp.Header.Get(XFromCache)
+	}
+	for _, path := range []string{"/cdn", "/private", "/legacy/small", "/legacy/big"} {
+		get(path)
+	}
+
+	clock = &fakeClock{elapsed: time.Minute}
+	if get("/cdn") != CacheHit {
+		t.Error("s-maxage not applied in a shared cache")
+	}
+	if get("/private") != "" {
+		t.Error("private response stored in a shared cache")
+	}
+	if get("/legacy/small") != CacheHit {
+		t.Error("default freshness of the URL prefix not applied")
+	}
+	if get("/legacy/big") != "" {
+		t.Error("response larger than MaxEntrySize stored")
+	}
+
+	clock = &fakeClock{elapsed: 6 * time.Minute}
+	if get("/cdn") != "" {
+		t.Error("freshness not clamped to MaxFreshness")
+	}
+
+	// Without a matching Policy, the fields of the Transport apply
+	clock = &realClock{}
+	tp.Policies = nil
+	tp.MaxEntrySize = 500
+	get("/big")
+	if get("/big") != "" {
+		t.Error("response larger than the MaxEntrySize of the Transport stored")
+	}
+}
+
+func TestServerErrorGrace(t *testing.T) {
+	resetTest()
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.Error(w, "overloaded", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	tp.ServerErrorGrace = 2 * time.Second
+	get := func() *http.Response {
+		resp, err := tp.Client().Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		return resp
+	}
+	if resp := get(); resp.Header.Get(xGrace) != "" {
+		t.Fatal("grace mark served to the client")
+	}
+	resp := get()
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get(XFromCache) != CacheGrace || requests != 1 {
+		t.Fatalf("got status %d, X-From-Cache %q after %d requests, want a graced error", resp.StatusCode, resp.Header.Get(XFromCache), requests)
+	}
+	if resp.Header.Get(xGrace) != "" {
+		t.Fatal("grace mark served to the client")
+	}
+
+	clock = &fakeClock{elapsed: 3 * time.Second}
+	if get(); requests != 2 {
+		t.Fatal("server error served after its grace period")
+	}
+}
+
+func TestSetCookie(t *testing.T) {
+	resetTest()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Set-Cookie", "session=1")
+		w.Write([]byte("body"))
+	}))
+	defer server.Close()
+
+	for _, test := range []struct {
+		mode         SetCookieMode
+		stored       bool
+		storedCookie bool
+	}{
+		{SetCookieRefuse, false, false},
+		{SetCookieStrip, true, false},
+		{SetCookieStore, true, true},
+	} {
+		tp := NewMemoryCacheTransport(defaultMaxEntries)
+		tp.SetCookie = test.mode
+		resp, err := tp.Client().Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.Header.Get("Set-Cookie") == "" {
+			t.Errorf("mode %d: Set-Cookie not sent to the client fetching the response", test.mode)
+		}
+		b, ok := tp.Cache.Get(server.URL)
+		if ok != test.stored {
+			t.Errorf("mode %d: got stored %v, want %v", test.mode, ok, test.stored)
+		}
+		if storedCookie := strings.Contains(string(b), "Set-Cookie"); storedCookie != test.storedCookie {
+			t.Errorf("mode %d: got Set-Cookie stored %v, want %v", test.mode, storedCookie, test.storedCookie)
+		}
+	}
+}
+
+func TestStripHeaders(t *testing.T) {
+	resetTest()
+	var token string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token = r.Header.Get("X-Token")
+		if r.Header.Get("If-None-Match") == `"1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"1"`)
+		w.Header().Set("X-Debug", "internal")
+		w.Write([]byte("body"))
+	}))
+	defer server.Close()
+
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	tp.StripResponseHeaders = []string{"X-Debug"}
+	tp.StripRevalidationHeaders = []string{"X-Token"}
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Token", "secret")
+		resp, err := tp.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		if i == 0 && resp.Header.Get("X-Debug") == "" {
+			t.Fatal("stripped header not sent to the client fetching the response")
+		}
+		if i == 1 && resp.Header.Get(XFromCache) != CacheRevalidated {
+			t.Fatal("response not revalidated")
+		}
+	}
+	if token != "" {
+		t.Fatalf("got X-Token %q in the revalidation request", token)
+	}
+	b, _ := tp.Cache.Get(server.URL)
+	if strings.Contains(string(b), "X-Debug") {
+		t.Fatalf("stripped header stored:\n%s", b)
+	}
+}
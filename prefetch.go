@@ -0,0 +1,295 @@
+package httpcache
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptrace"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// PrefetchOptions configures the prefetching of the resources referenced by
+// Link headers. Only the links of the responses fetched by the client are
+// followed, not those of the prefetched resources.
+type PrefetchOptions struct {
+	// CrossOrigin allows prefetching resources from other origins than the
+	// one of the response. By default, only same-origin links are followed.
+	CrossOrigin bool
+	// Interval is the minimum time between the start of two prefetches
+	// made by the Transport. It defaults to 100ms.
+	Interval time.Duration
+	// MaxLinks is the maximum number of links followed per response. It
+	// defaults to 8.
+	MaxLinks int
+	// EarlyHints also follows the links of the 103 Early Hints responses
+	// sent by the server before the final one, so that prefetching can
+	// start while the server is still preparing it
+	EarlyHints bool
+	// MaxConcurrent is the maximum number of responses whose links are
+	// prefetched at once. The links found while it is reached are dropped.
+	// It defaults to 4.
+	MaxConcurrent int
+	// NextPages, if set, is the number of pages of a paginated API
+	// prefetched by following the Link rel=next headers from the response
+	// fetched by the client, while it processes the current page. The pages
+	// are fetched one after the other, at the pace set by Interval.
+	NextPages int
+}
+
+// nextPagesKey holds, in the context of the prefetch requests for pages, the
+// number of pages still to be prefetched after them.
+type nextPagesKey struct{}
+
+// prefetchKey marks the context of prefetch requests, whose links are not
+// followed.
+type prefetchKey struct{}
+
+// prefetchLinks asynchronously fetches into the cache the resources
+// referenced by the Link headers of the response to req.
+func (t *Transport) prefetchLinks(req *http.Request, respHeaders http.Header) {
+	if req.Context().Value(prefetchKey{}) != nil {
+		return
+	}
+	maxLinks := t.Prefetch.MaxLinks
+	if maxLinks <= 0 {
+		maxLinks = 8
+	}
+	links := parseLinks(respHeaders)
+	var urls []string
+	for _, link := range links {
+		if link.rel != "prefetch" && link.rel != "preload" {
+			continue
+		}
+		if u, ok := t.linkURL(req, link.target); ok {
+			urls = append(urls, u)
+		}
+		if len(urls) == maxLinks {
+			break
+		}
+	}
+	next := ""
+	if t.Prefetch.NextPages > 0 {
+		next = t.nextPage(req, links)
+	}
+	urls = t.unfetched(urls)
+	if len(urls) == 0 && next == "" {
+		return
+	}
+	urls, ok := t.startPrefetch(urls)
+	if !ok {
+		t.logDecision(req, cacheKey(req), "prefetch dropped", "too many prefetches in progress")
+		return
+	}
+	// The request of the client may be canceled as soon as it is done
+	ctx := context.WithValue(detach(req.Context()), prefetchKey{}, true)
+	go func() {
+		defer t.endPrefetch(urls)
+		for _, u := range urls {
+			time.Sleep(t.reservePrefetch())
+			t.prefetch(ctx, u)
+		}
+		if next != "" {
+			time.Sleep(t.reservePrefetch())
+			t.prefetch(context.WithValue(ctx, nextPagesKey{}, t.Prefetch.NextPages-1), next)
+		}
+	}()
+}
+
+// unfetched returns the urls whose responses aren't cached fresh.
+func (t *Transport) unfetched(urls []string) []string {
+	var unfetched []string
+	for _, u := range urls {
+		req, err := http.NewRequest(http.MethodGet, u, nil)
+		if err != nil {
+			continue
+		}
+		if _, ok := t.Cached(req); !ok {
+			unfetched = append(unfetched, u)
+		}
+	}
+	return unfetched
+}
+
+// startPrefetch reserves a worker to prefetch the urls, unless
+// MaxConcurrent are already busy. It returns those of the urls that aren't
+// already being prefetched, which the worker must release with endPrefetch.
+func (t *Transport) startPrefetch(urls []string) ([]string, bool) {
+	max := t.Prefetch.MaxConcurrent
+	if max <= 0 {
+		max = 4
+	}
+	st := t.state()
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.prefetching >= max {
+		return nil, false
+	}
+	if st.prefetched == nil {
+		st.prefetched = make(map[string]struct{})
+	}
+	var pending []string
+	for _, u := range urls {
+		if _, ok := st.prefetched[u]; !ok {
+			st.prefetched[u] = struct{}{}
+			pending = append(pending, u)
+		}
+	}
+	st.prefetching++
+	return pending, true
+}
+
+// endPrefetch releases the worker reserved by startPrefetch for urls.
+func (t *Transport) endPrefetch(urls []string) {
+	st := t.state()
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.prefetching--
+	for _, u := range urls {
+		delete(st.prefetched, u)
+	}
+}
+
+// linkURL returns the URL of the target of a link of the response to req, if
+// it can be prefetched.
+func (t *Transport) linkURL(req *http.Request, target string) (string, bool) {
+	u, err := req.URL.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", false
+	}
+	if !t.Prefetch.CrossOrigin && (u.Scheme != req.URL.Scheme || u.Host != req.URL.Host) {
+		return "", false
+	}
+	u.Fragment = ""
+	return u.String(), true
+}
+
+// nextPage returns the URL of the next page linked by the response to req, or
+// an empty string.
+func (t *Transport) nextPage(req *http.Request, links []link) string {
+	for _, link := range links {
+		if link.rel != "next" {
+			continue
+		}
+		if u, ok := t.linkURL(req, link.target); ok {
+			return u
+		}
+	}
+	return ""
+}
+
+// captureEarlyHints returns req, or a copy of it prefetching the links of
+// the 103 Early Hints responses received for it if enabled.
+func (t *Transport) captureEarlyHints(req *http.Request) *http.Request {
+	if t.Prefetch == nil || !t.Prefetch.EarlyHints || req.Method != http.MethodGet {
+		return req
+	}
+	trace := &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			if code == http.StatusEarlyHints {
+				t.prefetchLinks(req, http.Header(header))
+			}
+			return nil
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
+
+// reservePrefetch reserves a slot for a prefetch, and returns how long to
+// wait before it.
+func (t *Transport) reservePrefetch() time.Duration {
+	interval := t.Prefetch.Interval
+	if interval <= 0 {
+		interval = 100 * time.Millisecond
+	}
+	st := t.state()
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	now := time.Now()
+	if st.nextPrefetch.Before(now) {
+		st.nextPrefetch = now
+	}
+	wait := st.nextPrefetch.Sub(now)
+	st.nextPrefetch = st.nextPrefetch.Add(interval)
+	return wait
+}
+
+// prefetch fetches u through t, reading the whole body so that the response
+// gets stored, and then the next pages still to be prefetched.
+func (t *Transport) prefetch(ctx context.Context, u string) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return
+	}
+	resp, err := t.RoundTrip(req)
+	if err != nil {
+		t.logDecision(req, cacheKey(req), "prefetch failed", err.Error())
+		return
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	// The pages are followed even when served from the cache
+	remaining, _ := ctx.Value(nextPagesKey{}).(int)
+	if remaining <= 0 || resp.StatusCode != http.StatusOK {
+		return
+	}
+	if next := t.nextPage(req, parseLinks(resp.Header)); next != "" {
+		time.Sleep(t.reservePrefetch())
+		t.prefetch(context.WithValue(ctx, nextPagesKey{}, remaining-1), next)
+	}
+}
+
+// link is a link of a Link header.
+type link struct {
+	target string
+	rel    string
+}
+
+// parseLinks returns the links of the Link headers in headers, with one
+// entry per relation type of each link.
+func parseLinks(headers http.Header) []link {
+	var links []link
+	for _, value := range headers.Values("Link") {
+		for _, part := range splitLinks(value) {
+			part = strings.TrimSpace(part)
+			end := strings.IndexByte(part, '>')
+			if !strings.HasPrefix(part, "<") || end < 0 {
+				continue
+			}
+			target := part[1:end]
+			for _, param := range strings.Split(part[end+1:], ";") {
+				name, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+				if !ok || !strings.EqualFold(name, "rel") {
+					continue
+				}
+				for _, rel := range strings.Fields(strings.Trim(value, `"`)) {
+					links = append(links, link{target: target, rel: strings.ToLower(rel)})
+				}
+			}
+		}
+	}
+	return links
+}
+
+// splitLinks splits a Link header value on the commas separating its links,
+// ignoring those inside <> and quoted strings.
+func splitLinks(value string) []string {
+	var parts []string
+	inURL, inQuotes, start := false, false, 0
+	for i, c := range value {
+		switch {
+		case c == '<' && !inQuotes:
+			inURL = true
+		case c == '>' && !inQuotes:
+			inURL = false
+		case c == '"' && !inURL:
+			inQuotes = !inQuotes
+		case c == ',' && !inURL && !inQuotes:
+			parts = append(parts, value[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, value[start:])
+}
@@ -0,0 +1,201 @@
+package httpcache
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPrefetch(t *testing.T) {
+	var mu sync.Mutex
+	requested := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested[r.URL.Path]++
+		mu.Unlock()
+		w.Header().Set("Cache-Control", "max-age=3600")
+		switch r.URL.Path {
+		case "/page":
+			w.Header().Set("Link", `</style.css>; rel=preload; as=style, <http://other.example/x>; rel="prefetch", </next>; rel="next"`)
+		case "/style.css":
+			w.Header().Set("Link", `</deep>; rel=prefetch`)
+		}
+	}))
+	defer server.Close()
+
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	tp.Prefetch = &PrefetchOptions{Interval: time.Millisecond}
+	resp, err := tp.Client().Get(server.URL + "/page")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	for deadline := time.Now().Add(time.Second); ; {
+		if _, ok := tp.Cache.Get(server.URL + "/style.css"); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("preloaded resource was not cached")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if requested["/deep"] != 0 || requested["/next"] != 0 || len(requested) != 2 {
+		t.Fatalf("got requests %v", requested)
+	}
+}
+
+func TestParseLinks(t *testing.T) {
+	headers := http.Header{"Link": {`<a,b>; rel="prefetch preload"; title="x, y", </c>; REL=Next`, `</d>`}}
+	got := parseLinks(headers)
+	want := []link{{"a,b", "prefetch"}, {"a,b", "preload"}, {"/c", "next"}}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestPrefetchEarlyHints(t *testing.T) {
+	hinted := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=3600")
+		if r.URL.Path == "/style.css" {
+			close(hinted)
+			return
+		}
+		w.Header().Set("Link", `</style.css>; rel=preload; as=style`)
+		w.WriteHeader(http.StatusEarlyHints)
+		w.Header().Del("Link")
+		// The final response waits for the hinted resource to be prefetched
+		select {
+		case <-hinted:
+		case <-time.After(time.Second):
+		}
+	}))
+	defer server.Close()
+
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	tp.Prefetch = &PrefetchOptions{Interval: time.Millisecond, EarlyHints: true}
+	resp, err := tp.Client().Get(server.URL + "/page")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	select {
+	case <-hinted:
+	default:
+		t.Fatal("hinted resource not prefetched")
+	}
+}
+
+func TestPrefetchNextPages(t *testing.T) {
+	var mu sync.Mutex
+	requested := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested[r.URL.RawQuery]++
+		mu.Unlock()
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Header().Set("Link", fmt.Sprintf(`</items?page=%d>; rel="next"`, page+1))
+	}))
+	defer server.Close()
+
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	tp.Prefetch = &PrefetchOptions{Interval: time.Millisecond, NextPages: 2}
+	resp, err := tp.Client().Get(server.URL + "/items?page=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	for deadline := time.Now().Add(time.Second); ; {
+		if _, ok := tp.Cache.Get(server.URL + "/items?page=3"); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("next pages were not cached")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if len(requested) != 3 || requested["page=4"] != 0 {
+		t.Fatalf("got requests %v, want 2 next pages", requested)
+	}
+}
+
+func TestPrefetchConcurrency(t *testing.T) {
+	var mu sync.Mutex
+	requested := map[string]int{}
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested[r.URL.Path]++
+		mu.Unlock()
+		w.Header().Set("Cache-Control", "max-age=3600")
+		switch r.URL.Path {
+		case "/slow":
+			<-release
+		case "/a", "/c":
+			w.Header().Set("Link", `</slow>; rel=prefetch`)
+		case "/b":
+			w.Header().Set("Link", `</other>; rel=prefetch`)
+		}
+	}))
+	defer server.Close()
+
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	tp.Prefetch = &PrefetchOptions{Interval: time.Millisecond, MaxConcurrent: 1}
+	get := func(path string) {
+		resp, err := tp.Client().Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	get("/a")
+	for deadline := time.Now().Add(time.Second); ; {
+		mu.Lock()
+		n := requested["/slow"]
+		mu.Unlock()
+		if n > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("linked resource not prefetched")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	get("/b")
+	get("/c")
+	close(release)
+	for deadline := time.Now().Add(time.Second); ; {
+		if _, ok := tp.Cache.Get(server.URL + "/slow"); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("prefetched resource was not cached")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	get("/c")
+	time.Sleep(20 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if requested["/other"] != 0 || requested["/slow"] != 1 {
+		t.Fatalf("got requests %v, want the links dropped while prefetching", requested)
+	}
+}
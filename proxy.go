@@ -0,0 +1,123 @@
+package httpcache
+
+import (
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"sync"
+)
+
+// A Proxy is a caching reverse proxy: an httputil.ReverseProxy forwarding
+// the requests to an origin server through a Transport in shared mode, as a
+// shield cache in front of the server.
+//
+// Concurrent requests for the same resource are collapsed into a single
+// request to the origin server, whose response is streamed to all of them if
+// it can be cached.
+//
+// The hop-by-hop headers are removed from the forwarded requests and the
+// responses by the ReverseProxy, and aren't stored in the cache. The
+// responses served from the cache are given an Age header, unless the
+// Transport doesn't mark them, see MarkCachedResponses. The Transport is
+// named "httpcache" in the Via headers.
+type Proxy struct {
+	// ReverseProxy forwards the requests to the origin server. Its
+	// Transport is set by NewProxy and shouldn't be replaced.
+	ReverseProxy *httputil.ReverseProxy
+	// Transport is the caching Transport used by ReverseProxy. Its
+	// Transport field can be set to reach the origin server differently.
+	Transport *Transport
+	// AuthorizePurge, if set, enables the PURGE method, removing from the
+	// cache the responses for the requested URL, and reports whether a
+	// PURGE request is allowed. Without it, PURGE requests are forwarded
+	// to the origin server like the others.
+	AuthorizePurge func(req *http.Request) bool
+
+	mu      sync.Mutex
+	flights map[string]*flight // requests in flight, by cache key
+}
+
+// NewProxy returns a Proxy caching in c the responses of the origin server
+// at target, whose requests are rewritten as by
+// httputil.NewSingleHostReverseProxy.
+func NewProxy(target *url.URL, c Cache) *Proxy {
+	t := NewTransport(c)
+	t.Shared = true
+	t.Via = "httpcache"
+	rp := httputil.NewSingleHostReverseProxy(target)
+	p := &Proxy{ReverseProxy: rp, Transport: t}
+	rp.Transport = proxyTransport{p}
+	return p
+}
+
+// ServeHTTP forwards the request to the origin server, or serves it from the
+// cache, or handles the PURGE requests, see AuthorizePurge.
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method == "PURGE" && p.AuthorizePurge != nil {
+		p.purge(w, req)
+		return
+	}
+	p.ReverseProxy.ServeHTTP(w, req)
+}
+
+// purge removes from the cache the responses for the URL requested by req,
+// a PURGE request, answering 404 Not Found if none was cached.
+func (p *Proxy) purge(w http.ResponseWriter, req *http.Request) {
+	if !p.AuthorizePurge(req) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	// The responses are cached for the URL of the origin server
+	outReq := req.Clone(req.Context())
+	if p.ReverseProxy.Director != nil {
+		p.ReverseProxy.Director(outReq)
+	}
+	found := false
+	for _, r := range resourceRequests(outReq.URL) {
+		if _, ok := p.Transport.cache().Get(cacheKey(r)); ok {
+			found = true
+		}
+	}
+	if err := p.Transport.InvalidateURL(outReq.URL.String()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "not cached", http.StatusNotFound)
+		return
+	}
+	io.WriteString(w, "purged\n")
+}
+
+// proxyTransport is the RoundTripper of the ReverseProxy of a Proxy.
+type proxyTransport struct {
+	p *Proxy
+}
+
+func (pt proxyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t := pt.p.Transport
+	resp, err := pt.p.roundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Header.Get(t.markerHeader()) != "" {
+		setAge(resp.Header, t.clock())
+	}
+	return resp, nil
+}
+
+// setAge sets the Age header of a cached response, from its Date header and
+// according to the timer c.
+func setAge(respHeaders http.Header, c timer) {
+	date, ok := parseDate(respHeaders)
+	if !ok {
+		return
+	}
+	age := int64(c.since(date).Seconds())
+	if age < 0 {
+		age = 0
+	}
+	respHeaders.Set("Age", strconv.FormatInt(age, 10))
+}
@@ -0,0 +1,342 @@
+package httpcache
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestProxy(t *testing.T) {
+	resetTest()
+	requests := 0
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Date", time.Now().UTC().Format(http.TimeFormat))
+		w.Header().Set("Connection", "X-Hop")
+		w.Header().Set("X-Hop", "1")
+		w.Write([]byte("body"))
+	}))
+	defer origin.Close()
+	target, err := url.Parse(origin.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewMemoryCache(defaultMaxEntries)
+	proxy := httptest.NewServer(NewProxy(target, c))
+	defer proxy.Close()
+
+	get := func() *http.Response {
+		resp, err := http.Get(proxy.URL + "/page")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != "body" {
+			t.Fatalf("got body %q", body)
+		}
+		return resp
+	}
+	resp := get()
+	if resp.Header.Get("Age") != "" || resp.Header.Get("X-Hop") != "" {
+		t.Fatalf("unexpected headers in the response of the origin: %v", resp.Header)
+	}
+	clock = &fakeClock{elapsed: 10 * time.Second}
+	resp = get()
+	if resp.Header.Get(XFromCache) != CacheHit {
+		t.Fatalf("got X-From-Cache %q, want %q", resp.Header.Get(XFromCache), CacheHit)
+	}
+	if age := resp.Header.Get("Age"); age != "10" {
+		t.Fatalf("got Age %q, want 10", age)
+	}
+	if requests != 1 {
+		t.Fatalf("origin contacted %d times, want 1", requests)
+	}
+	b, ok := c.Get(origin.URL + "/page")
+	if !ok {
+		t.Fatal("response not cached")
+	}
+	if strings.Contains(string(b), "X-Hop") {
+		t.Fatalf("hop-by-hop header cached:\n%s", b)
+	}
+}
+
+func TestVia(t *testing.T) {
+	resetTest()
+	var via string
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		via = r.Header.Get("Via")
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Via", "1.1 origin")
+		w.Write([]byte("body"))
+	}))
+	defer origin.Close()
+
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	tp.Via = "cache"
+	for i := 0; i < 2; i++ {
+		resp, err := tp.Client().Get(origin.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if got := strings.Join(resp.Header.Values("Via"), ", "); got != "1.1 origin, 1.1 cache" {
+			t.Fatalf("request %d: got response Via %q", i, got)
+		}
+	}
+	if via != "1.1 cache" {
+		t.Fatalf("got request Via %q", via)
+	}
+}
+
+func TestProxyPurge(t *testing.T) {
+	resetTest()
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("body"))
+	}))
+	defer origin.Close()
+	target, err := url.Parse(origin.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewMemoryCache(defaultMaxEntries)
+	p := NewProxy(target, c)
+	p.AuthorizePurge = func(req *http.Request) bool {
+		return req.Header.Get("Authorization") == "Bearer secret"
+	}
+	proxy := httptest.NewServer(p)
+	defer proxy.Close()
+
+	resp, err := http.Get(proxy.URL + "/page")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	purge := func(authorization string) int {
+		req, err := http.NewRequest("PURGE", proxy.URL+"/page", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", authorization)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := purge("Bearer guess"); code != http.StatusForbidden {
+		t.Fatalf("unauthorized purge: got status %d, want 403", code)
+	}
+	if _, ok := c.Get(origin.URL + "/page"); !ok {
+		t.Fatal("response purged without authorization")
+	}
+	if code := purge("Bearer secret"); code != http.StatusOK {
+		t.Fatalf("got status %d, want 200", code)
+	}
+	if _, ok := c.Get(origin.URL + "/page"); ok {
+		t.Fatal("response not purged")
+	}
+	if code := purge("Bearer secret"); code != http.StatusNotFound {
+		t.Fatalf("purge of a missing response: got status %d, want 404", code)
+	}
+}
+
+func TestProxyCollapsing(t *testing.T) {
+	for _, test := range []struct {
+		cacheControl string
+		requests     int32
+	}{
+		{"max-age=60", 1},
+		{"private", 3},
+	} {
+		resetTest()
+		var requests int32
+		arrived := make(chan struct{}, 3)
+		release := make(chan struct{})
+		origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			arrived <- struct{}{}
+			<-release
+			w.Header().Set("Cache-Control", test.cacheControl)
+			w.Write([]byte("body"))
+		}))
+		target, err := url.Parse(origin.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		proxy := httptest.NewServer(NewProxy(target, NewMemoryCache(defaultMaxEntries)))
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 3)
+		for i := 0; i < 3; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				resp, err := http.Get(proxy.URL)
+				if err != nil {
+					errs <- err
+					return
+				}
+				body, err := ioutil.ReadAll(resp.Body)
+				resp.Body.Close()
+				if err == nil && string(body) != "body" {
+					err = fmt.Errorf("got body %q", body)
+				}
+				errs <- err
+			}()
+		}
+		// Let the other requests join the first one
+		<-arrived
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+		wg.Wait()
+		proxy.Close()
+		origin.Close()
+		close(errs)
+		for err := range errs {
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if n := atomic.LoadInt32(&requests); n != test.requests {
+			t.Fatalf("%s: origin contacted %d times, want %d", test.cacheControl, n, test.requests)
+		}
+	}
+}
+
+func TestProxyCollapsingVary(t *testing.T) {
+	for _, test := range []struct {
+		header   string
+		values   []string
+		requests int32
+	}{
+		{"Accept-Language", []string{"fr", "en"}, 2},
+		{"Cookie", []string{"user=1", "user=1"}, 2},
+	} {
+		resetTest()
+		var requests int32
+		arrived := make(chan struct{}, 2)
+		release := make(chan struct{})
+		origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			arrived <- struct{}{}
+			<-release
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Vary", "Accept-Language")
+			w.Write([]byte(r.Header.Get(test.header)))
+		}))
+		target, err := url.Parse(origin.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The responses are collapsed but never cached, so that the
+		// requests not sharing them go to the origin
+		proxy := httptest.NewServer(NewProxy(target, nopCache{}))
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 2)
+		for i, value := range test.values {
+			wg.Add(1)
+			go func(value string) {
+				defer wg.Done()
+				req, _ := http.NewRequest(http.MethodGet, proxy.URL, nil)
+				req.Header.Set(test.header, value)
+				resp, err := http.DefaultClient.Do(req)
+				if err != nil {
+					errs <- err
+					return
+				}
+				body, err := ioutil.ReadAll(resp.Body)
+				resp.Body.Close()
+				if err == nil && string(body) != value {
+					err = fmt.Errorf("%s: got body %q, want %q", test.header, body, value)
+				}
+				errs <- err
+			}(value)
+			if i == 0 {
+				// Let the other request join the first one
+				<-arrived
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+		wg.Wait()
+		proxy.Close()
+		origin.Close()
+		close(errs)
+		for err := range errs {
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if n := atomic.LoadInt32(&requests); n != test.requests {
+			t.Fatalf("%s: origin contacted %d times, want %d", test.header, n, test.requests)
+		}
+	}
+}
+
+// nopCache is a Cache storing nothing.
+type nopCache struct{}
+
+func (nopCache) Get(key string) ([]byte, bool) { return nil, false }
+func (nopCache) Set(key string, resp []byte)   {}
+func (nopCache) Delete(key string)             {}
+
+func TestProxyVary(t *testing.T) {
+	resetTest()
+	var requests int32
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Language")
+		w.Write([]byte(r.Header.Get("Accept-Language")))
+	}))
+	defer origin.Close()
+	target, err := url.Parse(origin.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := httptest.NewServer(NewProxy(target, NewMemoryCache(defaultMaxEntries)))
+	defer proxy.Close()
+
+	for _, lang := range []string{"fr", "en", "en"} {
+		req, _ := http.NewRequest(http.MethodGet, proxy.URL, nil)
+		req.Header.Set("Accept-Language", lang)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != lang {
+			t.Fatalf("got body %q for Accept-Language %s", body, lang)
+		}
+		for name := range resp.Header {
+			if strings.HasPrefix(name, "X-Httpcache-") {
+				t.Fatalf("got internal header %s", name)
+			}
+		}
+	}
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Fatalf("got %d requests to the origin, want 2", n)
+	}
+}
@@ -0,0 +1,141 @@
+package httpcache
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// ErrCannotPurge is returned when purging entries in bulk from a cache that
+// can neither purge them itself nor list its keys.
+var ErrCannotPurge = errors.New("httpcache: cache can't purge entries in bulk")
+
+// A Purger is a Cache that can remove the entries whose key matches a
+// predicate more efficiently than listing then deleting them.
+type Purger interface {
+	// PurgeMatching removes the entries whose key matches f, and returns
+	// how many were removed.
+	PurgeMatching(f func(key string) bool) int
+}
+
+// A PrefixPurger is a Cache that can efficiently remove the entries whose
+// key starts with a prefix, e.g. with an index ordered by key.
+type PrefixPurger interface {
+	// PurgePrefix removes the entries whose key starts with prefix, and
+	// returns how many were removed.
+	PurgePrefix(prefix string) int
+}
+
+// PurgePrefix removes the cached responses whose key starts with prefix, e.g.
+// all the URLs under "https://api.example.com/v1/users/", for coarse-grained
+// invalidation after a deploy. It returns how many entries were removed.
+func (t *Transport) PurgePrefix(prefix string) (int, error) {
+	n, err := t.purgePrefix(context.Background(), prefix)
+	if err == nil {
+		t.publish(Invalidation{Prefix: prefix})
+	}
+	return n, err
+}
+
+func (t *Transport) purgePrefix(ctx context.Context, prefix string) (int, error) {
+	matches := func(key string) bool { return strings.HasPrefix(key, prefix) }
+	t.forgetMatching(matches)
+	n := 0
+	for _, c := range t.purgedCaches() {
+		if p, ok := c.(PrefixPurger); ok {
+			t.audit(ctx, AuditPurge, prefix, true)
+			n += p.PurgePrefix(prefix)
+			continue
+		}
+		m, err := t.purgeMatchingIn(ctx, c, matches)
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// PurgeRegexp removes the cached responses whose key matches re, and returns
+// how many entries were removed. Like PurgeMatching, it isn't broadcast.
+func (t *Transport) PurgeRegexp(re *regexp.Regexp) (int, error) {
+	return t.PurgeMatching(re.MatchString)
+}
+
+// PurgeMatching removes the cached responses whose key matches f, and returns
+// how many entries were removed. The cache, and the SensitiveCache if any,
+// must implement Purger or KeyLister. As f can't be serialized, the purge
+// isn't broadcast on the InvalidationBus of t.
+func (t *Transport) PurgeMatching(f func(key string) bool) (int, error) {
+	return t.purgeMatching(context.Background(), f)
+}
+
+func (t *Transport) purgeMatching(ctx context.Context, f func(key string) bool) (int, error) {
+	t.forgetMatching(f)
+	n := 0
+	for _, c := range t.purgedCaches() {
+		m, err := t.purgeMatchingIn(ctx, c, f)
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// purgedCaches returns the caches that the bulk purges apply to: the cache
+// of t, then its SensitiveCache if any.
+func (t *Transport) purgedCaches() []Cache {
+	if t.SensitiveCache != nil {
+		return []Cache{t.cache(), t.SensitiveCache}
+	}
+	return []Cache{t.cache()}
+}
+
+// purgeMatchingIn removes the entries of c whose key matches f, and returns
+// how many were removed.
+func (t *Transport) purgeMatchingIn(ctx context.Context, c Cache, f func(key string) bool) (int, error) {
+	if t.Audit != nil {
+		matches := f
+		f = func(key string) bool {
+			if matches(key) {
+				t.audit(ctx, AuditPurge, key, false)
+				return true
+			}
+			return false
+		}
+	}
+	if p, ok := c.(Purger); ok {
+		return p.PurgeMatching(f), nil
+	}
+	lister, ok := c.(KeyLister)
+	if !ok {
+		return 0, ErrCannotPurge
+	}
+	n := 0
+	for _, key := range lister.Keys() {
+		if f(key) {
+			c.Delete(key)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// forgetMatching forgets how they were served and the tags of the keys matching f.
+func (t *Transport) forgetMatching(f func(key string) bool) {
+	st := t.state()
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for key := range st.access {
+		if f(key) {
+			delete(st.access, key)
+		}
+	}
+	for key := range st.keyTags {
+		if f(key) {
+			st.unindexTags(key)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package httpcache
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// listingCache is a Cache that can list its keys, but not purge them.
+type listingCache struct {
+	c *MemoryCache
+}
+
+func (c listingCache) Get(key string) ([]byte, bool) { return c.c.Get(key) }
+func (c listingCache) Set(key string, resp []byte)   { c.c.Set(key, resp) }
+func (c listingCache) Delete(key string)             { c.c.Delete(key) }
+func (c listingCache) Keys() []string                { return c.c.Keys() }
+
+func TestPurge(t *testing.T) {
+	keys := []string{
+		"https://api.example.com/v1/users/1",
+		"https://api.example.com/v1/users/2",
+		"HEAD https://api.example.com/v1/users/2",
+		"https://api.example.com/v1/groups/1",
+	}
+	for _, c := range []Cache{NewMemoryCache(0), listingCache{NewMemoryCache(0)}} {
+		tp := NewTransport(c)
+		for _, key := range keys {
+			c.Set(key, []byte("x"))
+		}
+		if n, err := tp.PurgePrefix("https://api.example.com/v1/users/"); err != nil || n != 2 {
+			t.Fatalf("%T: purged %d entries by prefix (%v), want 2", c, n, err)
+		}
+		if n, err := tp.PurgeRegexp(regexp.MustCompile(`^HEAD `)); err != nil || n != 1 {
+			t.Fatalf("%T: purged %d entries by regexp (%v), want 1", c, n, err)
+		}
+		if keys := c.(KeyLister).Keys(); len(keys) != 1 || keys[0] != "https://api.example.com/v1/groups/1" {
+			t.Fatalf("%T: got keys %q after purging", c, keys)
+		}
+	}
+
+	tp := NewTransport(struct{ Cache }{NewMemoryCache(0)})
+	if _, err := tp.PurgePrefix("https://"); err != ErrCannotPurge {
+		t.Fatalf("got error %v for a cache that can't purge", err)
+	}
+}
+
+func TestPurgeSensitive(t *testing.T) {
+	resetTest()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=3600")
+		io.WriteString(w, "body")
+	}))
+	defer server.Close()
+
+	sensitive := NewMemoryCache(defaultMaxEntries)
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	tp.SensitiveCache = sensitive
+	tp.NeverPersist = func(req *http.Request, resp *http.Response) bool {
+		return strings.HasPrefix(req.URL.Path, "/private/")
+	}
+	for _, path := range []string{"/private/a", "/private/b", "/public"} {
+		resp, err := tp.Client().Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	err := tp.ApplyInvalidation(Invalidation{Prefix: server.URL + "/private/a", Soft: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, ok := sensitive.Get(server.URL + "/private/a"); !ok || !bytes.Contains(b, []byte(xSoftPurged)) {
+		t.Fatal("sensitive response not soft purged by prefix")
+	}
+
+	if n, err := tp.PurgePrefix(server.URL + "/private/a"); err != nil || n != 1 {
+		t.Fatalf("purged %d entries by prefix (%v), want 1", n, err)
+	}
+	if n, err := tp.PurgeRegexp(regexp.MustCompile(`/(private/b|public)$`)); err != nil || n != 2 {
+		t.Fatalf("purged %d entries by regexp (%v), want 2", n, err)
+	}
+	if keys := sensitive.Keys(); len(keys) != 0 {
+		t.Fatalf("got keys %q in the SensitiveCache after purging", keys)
+	}
+}
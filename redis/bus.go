@@ -0,0 +1,125 @@
+package redis
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/cozy/httpcache"
+	"github.com/garyburd/redigo/redis"
+)
+
+// Bus is an httpcache.InvalidationBus over redis pub/sub, so that all the
+// processes sharing a redis server drop invalidated entries from their
+// in-memory caches.
+type Bus struct {
+	dial    func() (redis.Conn, error)
+	channel string
+}
+
+// NewBus returns a Bus publishing invalidations on channel, with connections
+// opened by dial. A subscriber holds a connection of its own.
+func NewBus(dial func() (redis.Conn, error), channel string) *Bus {
+	return &Bus{dial: dial, channel: channel}
+}
+
+// Publish sends inv to the subscribers of the channel.
+func (b *Bus) Publish(inv httpcache.Invalidation) error {
+	msg, err := json.Marshal(inv)
+	if err != nil {
+		return err
+	}
+	conn, err := b.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Do("PUBLISH", b.channel, msg)
+	return err
+}
+
+// Subscribe calls handler with the invalidations published on the channel,
+// until stop is called. The subscription is renewed if the connection is
+// lost, but the invalidations published meanwhile are missed.
+func (b *Bus) Subscribe(handler func(httpcache.Invalidation)) (stop func(), err error) {
+	psc, err := b.subscribe()
+	if err != nil {
+		return nil, err
+	}
+	var mu sync.Mutex
+	current := psc
+	done := make(chan struct{})
+	go func() {
+		for {
+			b.receive(psc, handler)
+			psc.Close()
+			for {
+				select {
+				case <-done:
+					return
+				case <-time.After(time.Second):
+				}
+				next, err := b.subscribe()
+				if err != nil {
+					continue
+				}
+				mu.Lock()
+				select {
+				case <-done:
+					mu.Unlock()
+					next.Close()
+					return
+				default:
+				}
+				current = next
+				mu.Unlock()
+				psc = next
+				break
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			mu.Lock()
+			close(done)
+			c := current
+			mu.Unlock()
+			c.Unsubscribe()
+		})
+	}, nil
+}
+
+// subscribe opens a connection subscribed to the channel.
+func (b *Bus) subscribe() (redis.PubSubConn, error) {
+	conn, err := b.dial()
+	if err != nil {
+		return redis.PubSubConn{}, err
+	}
+	psc := redis.PubSubConn{Conn: conn}
+	if err := psc.Subscribe(b.channel); err != nil {
+		conn.Close()
+		return redis.PubSubConn{}, err
+	}
+	return psc, nil
+}
+
+// receive calls handler with the invalidations received by psc, until it is
+// unsubscribed or fails.
+func (b *Bus) receive(psc redis.PubSubConn, handler func(httpcache.Invalidation)) {
+	for {
+		switch v := psc.Receive().(type) {
+		case redis.Message:
+			var inv httpcache.Invalidation
+			if json.Unmarshal(v.Data, &inv) == nil {
+				handler(inv)
+			}
+		case redis.Subscription:
+			if v.Count == 0 {
+				return
+			}
+		case error:
+			return
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cozy/httpcache"
+	"github.com/garyburd/redigo/redis"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// pooledCache is an implementation of httpcache.Cache that borrows a
+// connection of a pool for each operation, so that it can be used
+// concurrently.
+type pooledCache struct {
+	pool *redis.Pool
+}
+
+// NewWithPool returns a new Cache using the connections of pool, e.g. to
+// share them with the rest of the application.
+func NewWithPool(pool *redis.Pool) httpcache.Cache {
+	return pooledCache{pool}
+}
+
+// Get returns the response corresponding to key if present.
+func (c pooledCache) Get(key string) ([]byte, bool) {
+	conn := c.pool.Get()
+	defer conn.Close()
+	return cache{conn}.Get(key)
+}
+
+// Set saves a response to the cache as key.
+func (c pooledCache) Set(key string, resp []byte) {
+	conn := c.pool.Get()
+	defer conn.Close()
+	cache{conn}.Set(key, resp)
+}
+
+// Delete removes the response with key from the cache.
+func (c pooledCache) Delete(key string) {
+	conn := c.pool.Get()
+	defer conn.Close()
+	cache{conn}.Delete(key)
+}
+
+// Keys returns the keys of the responses in the cache.
+func (c pooledCache) Keys() []string {
+	conn := c.pool.Get()
+	defer conn.Close()
+	return cache{conn}.Keys()
+}
+
+// PurgePrefix removes the responses whose key starts with prefix, and returns
+// how many were removed.
+func (c pooledCache) PurgePrefix(prefix string) int {
+	conn := c.pool.Get()
+	defer conn.Close()
+	return cache{conn}.PurgePrefix(prefix)
+}
+
+// PurgeMatching removes the responses whose key matches f, and returns how
+// many were removed.
+func (c pooledCache) PurgeMatching(f func(key string) bool) int {
+	conn := c.pool.Get()
+	defer conn.Close()
+	return cache{conn}.PurgeMatching(f)
+}
+
+// clientCache is an implementation of httpcache.Cache over a go-redis client,
+// which may be a single node, a sentinel or a cluster client.
+type clientCache struct {
+	client goredis.UniversalClient
+}
+
+// NewWithUniversalClient returns a new Cache using client, an already
+// configured go-redis client, to share its pool, TLS configuration and
+// instrumentation with the rest of the application. The Cache honors the
+// contexts and the TTL hints of the Transport.
+func NewWithUniversalClient(client goredis.UniversalClient) httpcache.Cache {
+	return clientCache{client}
+}
+
+// Get returns the response corresponding to key if present.
+func (c clientCache) Get(key string) ([]byte, bool) {
+	item, ok, _ := c.GetContext(context.Background(), key)
+	return item, ok
+}
+
+// GetContext returns the response corresponding to key if present, unless
+// ctx is done first or redis fails.
+func (c clientCache) GetContext(ctx context.Context, key string) ([]byte, bool, error) {
+	item, err := c.client.Get(ctx, cacheKey(key)).Bytes()
+	if errors.Is(err, goredis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return item, true, nil
+}
+
+// Set saves a response to the cache as key.
+func (c clientCache) Set(key string, resp []byte) {
+	c.client.Set(context.Background(), cacheKey(key), resp, 0)
+}
+
+// SetWithTTL saves a response to the cache as key, expiring after ttl if not
+// zero.
+func (c clientCache) SetWithTTL(key string, resp []byte, ttl time.Duration) {
+	c.client.Set(context.Background(), cacheKey(key), resp, ttl)
+}
+
+// SetContext saves a response to the cache as key, expiring after ttl if not
+// zero, unless ctx is done first.
+func (c clientCache) SetContext(ctx context.Context, key string, resp []byte, ttl time.Duration) error {
+	return c.client.Set(ctx, cacheKey(key), resp, ttl).Err()
+}
+
+// Delete removes the response with key from the cache.
+func (c clientCache) Delete(key string) {
+	c.client.Del(context.Background(), cacheKey(key))
+}
+
+// Keys returns the keys of the responses in the cache, scanning the redis
+// keyspace for them.
+func (c clientCache) Keys() []string {
+	return c.scan("")
+}
+
+// PurgePrefix removes the responses whose key starts with prefix, letting
+// redis filter the keyspace, and returns how many were removed.
+func (c clientCache) PurgePrefix(prefix string) int {
+	return c.del(c.scan(prefix))
+}
+
+// PurgeMatching removes the responses whose key matches f, and returns how
+// many were removed.
+func (c clientCache) PurgeMatching(f func(key string) bool) int {
+	var keys []string
+	for _, key := range c.scan("") {
+		if f(key) {
+			keys = append(keys, key)
+		}
+	}
+	return c.del(keys)
+}
+
+// scan returns the keys of the responses in the cache that start with prefix,
+// on all the master nodes of a cluster.
+func (c clientCache) scan(prefix string) []string {
+	var (
+		mu   sync.Mutex
+		keys []string
+	)
+	scanNode := func(ctx context.Context, client goredis.Cmdable) error {
+		iter := client.Scan(ctx, 0, cacheKey(globEscaper.Replace(prefix))+"*", 100).Iterator()
+		for iter.Next(ctx) {
+			mu.Lock()
+			keys = append(keys, strings.TrimPrefix(iter.Val(), cacheKey("")))
+			mu.Unlock()
+		}
+		return iter.Err()
+	}
+	if cluster, ok := c.client.(*goredis.ClusterClient); ok {
+		cluster.ForEachMaster(context.Background(), func(ctx context.Context, node *goredis.Client) error {
+			return scanNode(ctx, node)
+		})
+	} else {
+		scanNode(context.Background(), c.client)
+	}
+	return keys
+}
+
+// del deletes the responses at keys, and returns how many were deleted.
+func (c clientCache) del(keys []string) int {
+	n := 0
+	for _, key := range keys {
+		// The keys may be spread over the nodes of a cluster
+		deleted, _ := c.client.Del(context.Background(), cacheKey(key)).Result()
+		n += int(deleted)
+	}
+	return n
+}
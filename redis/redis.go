@@ -2,6 +2,8 @@
 package redis
 
 import (
+	"strings"
+
 	"github.com/cozy/httpcache"
 	"github.com/garyburd/redigo/redis"
 )
@@ -37,6 +39,66 @@ func (c cache) Delete(key string) {
 	c.Do("DEL", cacheKey(key))
 }
 
+// Keys returns the keys of the responses in the cache, scanning the redis
+// keyspace for them.
+func (c cache) Keys() []string {
+	return c.scan("")
+}
+
+// PurgePrefix removes the responses whose key starts with prefix, letting
+// redis filter the keyspace, and returns how many were removed.
+func (c cache) PurgePrefix(prefix string) int {
+	return c.del(c.scan(prefix))
+}
+
+// PurgeMatching removes the responses whose key matches f, and returns how
+// many were removed.
+func (c cache) PurgeMatching(f func(key string) bool) int {
+	var keys []string
+	for _, key := range c.scan("") {
+		if f(key) {
+			keys = append(keys, key)
+		}
+	}
+	return c.del(keys)
+}
+
+// scan returns the keys of the responses in the cache that start with prefix.
+func (c cache) scan(prefix string) []string {
+	var keys []string
+	cursor := "0"
+	for {
+		values, err := redis.Values(c.Do("SCAN", cursor, "MATCH", cacheKey(globEscaper.Replace(prefix))+"*", "COUNT", 100))
+		if err != nil || len(values) != 2 {
+			return keys
+		}
+		cursor, _ = redis.String(values[0], nil)
+		batch, _ := redis.Strings(values[1], nil)
+		for _, key := range batch {
+			keys = append(keys, strings.TrimPrefix(key, cacheKey("")))
+		}
+		if cursor == "0" {
+			return keys
+		}
+	}
+}
+
+// del deletes the responses at keys, and returns how many were deleted.
+func (c cache) del(keys []string) int {
+	if len(keys) == 0 {
+		return 0
+	}
+	args := make([]interface{}, len(keys))
+	for i, key := range keys {
+		args[i] = cacheKey(key)
+	}
+	n, _ := redis.Int(c.Do("DEL", args...))
+	return n
+}
+
+// globEscaper escapes the special characters of redis glob-style patterns.
+var globEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
 // NewWithClient returns a new Cache with the given redis connection.
 func NewWithClient(client redis.Conn) httpcache.Cache {
 	return cache{client}
@@ -2,9 +2,13 @@ package redis
 
 import (
 	"bytes"
+	"context"
 	"testing"
+	"time"
 
+	"github.com/cozy/httpcache"
 	"github.com/garyburd/redigo/redis"
+	goredis "github.com/redis/go-redis/v9"
 )
 
 func TestRedisCache(t *testing.T) {
@@ -34,6 +38,20 @@ func TestRedisCache(t *testing.T) {
 		t.Fatal("retrieved a different value than what we put in")
 	}
 
+	if keys := cache.(httpcache.KeyLister).Keys(); len(keys) != 1 || keys[0] != key {
+		t.Fatalf("got keys %q", keys)
+	}
+
+	cache.Set("prefix*/a", val)
+	cache.Set("prefix*/b", val)
+	cache.Set("prefixes", val)
+	if n := cache.(httpcache.PrefixPurger).PurgePrefix("prefix*/"); n != 2 {
+		t.Fatalf("purged %d entries by prefix, want 2", n)
+	}
+	if n := cache.(httpcache.Purger).PurgeMatching(func(key string) bool { return key == "prefixes" }); n != 1 {
+		t.Fatalf("purged %d matching entries, want 1", n)
+	}
+
 	cache.Delete(key)
 
 	_, ok = cache.Get(key)
@@ -41,3 +59,78 @@ func TestRedisCache(t *testing.T) {
 		t.Fatal("deleted key still present")
 	}
 }
+
+func TestBus(t *testing.T) {
+	dial := func() (redis.Conn, error) { return redis.Dial("tcp", "localhost:6379") }
+	if _, err := dial(); err != nil {
+		t.Skipf("skipping test; no server running at localhost:6379")
+	}
+	bus := NewBus(dial, "httpcache-test")
+	received := make(chan httpcache.Invalidation, 1)
+	stop, err := bus.Subscribe(func(inv httpcache.Invalidation) { received <- inv })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	want := httpcache.Invalidation{Tag: "users", Soft: true}
+	if err := bus.Publish(want); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-received:
+		if got != want {
+			t.Fatalf("got %+v, want %+v", got, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("invalidation not received")
+	}
+}
+
+func TestUniversalClientCache(t *testing.T) {
+	client := goredis.NewUniversalClient(&goredis.UniversalOptions{Addrs: []string{"localhost:6379"}})
+	defer client.Close()
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		t.Skipf("skipping test; no server running at localhost:6379")
+	}
+	client.FlushAll(context.Background())
+
+	cache := NewWithUniversalClient(client)
+	val := []byte("some bytes")
+	cache.(httpcache.ContextCache).SetContext(context.Background(), "testKey", val, time.Minute)
+	if retVal, ok := cache.Get("testKey"); !ok || !bytes.Equal(retVal, val) {
+		t.Fatalf("got %q, %v", retVal, ok)
+	}
+	if ttl := client.TTL(context.Background(), cacheKey("testKey")).Val(); ttl <= 0 {
+		t.Fatalf("got TTL %v", ttl)
+	}
+	cache.Set("prefix/a", val)
+	if n := cache.(httpcache.PrefixPurger).PurgePrefix("prefix/"); n != 1 {
+		t.Fatalf("purged %d entries by prefix, want 1", n)
+	}
+	cache.Delete("testKey")
+	if _, ok := cache.Get("testKey"); ok {
+		t.Fatal("deleted key still present")
+	}
+}
+
+func TestPoolCache(t *testing.T) {
+	pool := &redis.Pool{Dial: func() (redis.Conn, error) { return redis.Dial("tcp", "localhost:6379") }}
+	defer pool.Close()
+	conn := pool.Get()
+	if _, err := conn.Do("FLUSHALL"); err != nil {
+		t.Skipf("skipping test; no server running at localhost:6379")
+	}
+	conn.Close()
+
+	cache := NewWithPool(pool)
+	val := []byte("some bytes")
+	cache.Set("testKey", val)
+	if retVal, ok := cache.Get("testKey"); !ok || !bytes.Equal(retVal, val) {
+		t.Fatalf("got %q, %v", retVal, ok)
+	}
+	cache.Delete("testKey")
+	if _, ok := cache.Get("testKey"); ok {
+		t.Fatal("deleted key still present")
+	}
+}
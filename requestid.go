@@ -0,0 +1,37 @@
+package httpcache
+
+import (
+	"context"
+	"math/rand"
+	"strconv"
+)
+
+type requestIDKey struct{}
+
+// WithRequestID returns a new context based on ctx, carrying id as the ID of
+// the requests made with it. The Transport reports it in its hooks, as
+// Event.RequestID, and in its logs, including those of the background work
+// triggered by the requests, such as prefetches and shadow requests. The
+// requests without an ID are given a random one.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID carried by ctx, or an empty string if
+// there is none.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// newRequestID returns a random request ID.
+func newRequestID() string {
+	return strconv.FormatUint(rand.Uint64(), 16)
+}
+
+// detach returns a context for the background work triggered by a request
+// with the context ctx: it carries the request ID of ctx, but isn't canceled
+// with it.
+func detach(ctx context.Context) context.Context {
+	return WithRequestID(context.Background(), RequestID(ctx))
+}
@@ -0,0 +1,48 @@
+package httpcache
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	resetTest()
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	var buf bytes.Buffer
+	tp.Logger = slog.New(slog.NewTextHandler(&buf, nil))
+	var ids []string
+	record := func(req *http.Request, ev Event) {
+		ids = append(ids, ev.RequestID)
+	}
+	tp.OnMiss = record
+	tp.OnHit = record
+
+	for _, id := range []string{"", "abc"} {
+		ctx := context.Background()
+		if id != "" {
+			ctx = WithRequestID(ctx, id)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.server.URL+"/method", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := tp.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	if len(ids) != 2 || ids[0] == "" || ids[1] != "abc" {
+		t.Fatalf("got request IDs %q", ids)
+	}
+	if !strings.Contains(buf.String(), "request_id="+ids[0]) || !strings.Contains(buf.String(), "request_id=abc") {
+		t.Fatalf("request IDs not logged: %s", buf.String())
+	}
+}
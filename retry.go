@@ -0,0 +1,189 @@
+package httpcache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// RetryCache is a Cache retrying the reads and writes of the underlying
+// Cache that fail with transient errors, such as timeouts and connection
+// resets, with a jittered exponential backoff, to smooth over the brief
+// outages of a remote backend before the Transport falls back to the server.
+// Only the errors reported by the underlying Cache can be retried: those of
+// GetContext if it is a ContextGetter, and of SetContext if it is a
+// ContextCache.
+type RetryCache struct {
+	// Cache is the underlying cache
+	Cache Cache
+	// Attempts is the maximum number of attempts of each operation,
+	// defaulting to 3
+	Attempts int
+	// Backoff is the delay before the first retry, doubled for each next
+	// one, and randomized, defaulting to 10ms
+	Backoff time.Duration
+	// Transient, if set, reports whether an operation failing with err can
+	// be retried, instead of IsTransient
+	Transient func(err error) bool
+}
+
+// NewRetryCache returns a RetryCache retrying the operations on c with the
+// default settings.
+func NewRetryCache(c Cache) *RetryCache {
+	return &RetryCache{Cache: c}
+}
+
+// IsTransient reports whether err is a transient error of the network, such
+// as a timeout or a connection reset, that may not happen again if the
+// operation is retried. The errors of the contexts aren't.
+func IsTransient(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
+
+// Get returns the response stored at key if present.
+func (c *RetryCache) Get(key string) ([]byte, bool) {
+	b, ok, _ := c.GetContext(context.Background(), key)
+	return b, ok
+}
+
+// GetContext returns the response stored at key if present, retrying the
+// reads failing with transient errors until ctx is done.
+func (c *RetryCache) GetContext(ctx context.Context, key string) (resp []byte, ok bool, err error) {
+	cg, isGetter := c.Cache.(ContextGetter)
+	if !isGetter {
+		resp, ok = c.Cache.Get(key)
+		return resp, ok, nil
+	}
+	err = c.retry(ctx, func() error {
+		resp, ok, err = cg.GetContext(ctx, key)
+		return err
+	})
+	return resp, ok, err
+}
+
+// Set saves resp at key.
+func (c *RetryCache) Set(key string, resp []byte) {
+	c.SetContext(context.Background(), key, resp, 0)
+}
+
+// SetWithTTL saves resp at key, passing ttl on to the underlying cache if it
+// supports it.
+func (c *RetryCache) SetWithTTL(key string, resp []byte, ttl time.Duration) {
+	c.SetContext(context.Background(), key, resp, ttl)
+}
+
+// SetContext saves resp at key, passing ttl on to the underlying cache if it
+// supports it, retrying the writes failing with transient errors until ctx
+// is done.
+func (c *RetryCache) SetContext(ctx context.Context, key string, resp []byte, ttl time.Duration) error {
+	switch cc := c.Cache.(type) {
+	case ContextCache:
+		return c.retry(ctx, func() error {
+			return cc.SetContext(ctx, key, resp, ttl)
+		})
+	case TTLCache:
+		cc.SetWithTTL(key, resp, ttl)
+	default:
+		c.Cache.Set(key, resp)
+	}
+	return nil
+}
+
+// Delete removes the response stored at key.
+func (c *RetryCache) Delete(key string) {
+	c.Cache.Delete(key)
+}
+
+// Peek returns the response stored at key if present, without perturbing the
+// underlying cache if it is a Peeker.
+func (c *RetryCache) Peek(key string) (resp []byte, ok bool) {
+	return peek(c.Cache, key)
+}
+
+// Keys returns the keys of the underlying cache, or none if it isn't a
+// KeyLister.
+func (c *RetryCache) Keys() []string {
+	if lister, ok := c.Cache.(KeyLister); ok {
+		return lister.Keys()
+	}
+	return nil
+}
+
+// PurgeMatching removes the entries whose key matches f from the underlying
+// cache, if it is a Purger or a KeyLister, and returns how many were removed.
+func (c *RetryCache) PurgeMatching(f func(key string) bool) int {
+	if purger, ok := c.Cache.(Purger); ok {
+		return purger.PurgeMatching(f)
+	}
+	n := 0
+	for _, key := range c.Keys() {
+		if f(key) {
+			c.Cache.Delete(key)
+			n++
+		}
+	}
+	return n
+}
+
+// PurgePrefix removes the entries whose key starts with prefix from the
+// underlying cache, if it is a PrefixPurger, or else like PurgeMatching, and
+// returns how many were removed.
+func (c *RetryCache) PurgePrefix(prefix string) int {
+	if purger, ok := c.Cache.(PrefixPurger); ok {
+		return purger.PurgePrefix(prefix)
+	}
+	return c.PurgeMatching(func(key string) bool { return strings.HasPrefix(key, prefix) })
+}
+
+// NotifyEvictions makes the underlying cache call f with the entries leaving
+// it, if it is an EvictionNotifier.
+func (c *RetryCache) NotifyEvictions(f func(Eviction)) {
+	if notifier, ok := c.Cache.(EvictionNotifier); ok {
+		notifier.NotifyEvictions(f)
+	}
+}
+
+// retry calls op until it succeeds, fails with an error that isn't
+// transient, the attempts are exhausted or ctx is done, and returns its last
+// error.
+func (c *RetryCache) retry(ctx context.Context, op func() error) error {
+	attempts, backoff, transient := c.Attempts, c.Backoff, c.Transient
+	if attempts <= 0 {
+		attempts = 3
+	}
+	if backoff <= 0 {
+		backoff = 10 * time.Millisecond
+	}
+	if transient == nil {
+		transient = IsTransient
+	}
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || attempt >= attempts || !transient(err) {
+			return err
+		}
+		// Full jitter, so that the clients of a failing backend don't
+		// retry in lockstep
+		timer := time.NewTimer(time.Duration(rand.Int63n(int64(backoff)) + 1))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+		backoff *= 2
+	}
+}
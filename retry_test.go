@@ -0,0 +1,154 @@
+package httpcache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// flakyCache is a ContextGetter and ContextCache failing its first reads and
+// writes with err.
+type flakyCache struct {
+	*MemoryCache
+	failures int
+	err      error
+	calls    int
+}
+
+func (c *flakyCache) fail() error {
+	c.calls++
+	if c.failures > 0 {
+		c.failures--
+		return c.err
+	}
+	return nil
+}
+
+func (c *flakyCache) GetContext(ctx context.Context, key string) ([]byte, bool, error) {
+	if err := c.fail(); err != nil {
+		return nil, false, err
+	}
+	b, ok := c.MemoryCache.Get(key)
+	return b, ok, nil
+}
+
+func (c *flakyCache) SetContext(ctx context.Context, key string, b []byte, ttl time.Duration) error {
+	if err := c.fail(); err != nil {
+		return err
+	}
+	c.MemoryCache.Set(key, b)
+	return nil
+}
+
+func TestRetryCache(t *testing.T) {
+	flaky := &flakyCache{MemoryCache: NewMemoryCache(defaultMaxEntries), failures: 2, err: syscall.ECONNRESET}
+	c := &RetryCache{Cache: flaky, Backoff: time.Millisecond}
+	if err := c.SetContext(context.Background(), "key", []byte("value"), 0); err != nil || flaky.calls != 3 {
+		t.Fatalf("got error %v after %d calls, want a success after 3", err, flaky.calls)
+	}
+	flaky.failures, flaky.calls = 2, 0
+	if b, ok, err := c.GetContext(context.Background(), "key"); err != nil || !ok || string(b) != "value" || flaky.calls != 3 {
+		t.Fatalf("got %q, %v, %v after %d calls, want the value after 3", b, ok, err, flaky.calls)
+	}
+
+	// The attempts are bounded
+	flaky.failures, flaky.calls = 5, 0
+	if _, _, err := c.GetContext(context.Background(), "key"); !errors.Is(err, syscall.ECONNRESET) || flaky.calls != 3 {
+		t.Fatalf("got error %v after %d calls, want ECONNRESET after 3", err, flaky.calls)
+	}
+
+	// The errors that aren't transient aren't retried
+	flaky.failures, flaky.calls, flaky.err = 1, 0, errors.New("WRONGTYPE")
+	if _, _, err := c.GetContext(context.Background(), "key"); err == nil || flaky.calls != 1 {
+		t.Fatalf("got error %v after %d calls, want a failure after 1", err, flaky.calls)
+	}
+}
+
+func TestRetryCacheForwards(t *testing.T) {
+	resetTest()
+	mem := NewMemoryCache(1)
+	c := NewRetryCache(mem)
+	tp := NewTransport(c)
+	var evictions []Eviction
+	if _, err := tp.SubscribeEvictions(func(ev Eviction) { evictions = append(evictions, ev) }); err != nil {
+		t.Fatal(err)
+	}
+	c.Set("https://example.com/a", []byte("a"))
+	if b, ok := c.Peek("https://example.com/a"); !ok || string(b) != "a" {
+		t.Fatalf("peeked %q, %v, want a", b, ok)
+	}
+	if n, err := tp.PurgePrefix("https://example.com/"); err != nil || n != 1 {
+		t.Fatalf("purged %d entries by prefix (%v), want 1", n, err)
+	}
+	c.Set("https://example.com/b", []byte("b"))
+	if n, err := tp.PurgeMatching(func(key string) bool { return strings.HasSuffix(key, "/b") }); err != nil || n != 1 {
+		t.Fatalf("purged %d entries (%v), want 1", n, err)
+	}
+	if keys := c.Keys(); len(keys) != 0 {
+		t.Fatalf("got keys %q after purging", keys)
+	}
+	if len(evictions) != 2 || evictions[0].Reason != EvictPurge {
+		t.Fatalf("got evictions %v, want the 2 purges", evictions)
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{syscall.ECONNRESET, true},
+		{&timeoutError{}, true},
+		{context.DeadlineExceeded, false},
+		{errors.New("WRONGTYPE"), false},
+	} {
+		if got := IsTransient(tc.err); got != tc.want {
+			t.Errorf("IsTransient(%v) = %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
+
+type timeoutError struct{}
+
+func (*timeoutError) Error() string   { return "i/o timeout" }
+func (*timeoutError) Timeout() bool   { return true }
+func (*timeoutError) Temporary() bool { return true }
+
+func TestCacheError(t *testing.T) {
+	resetTest()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=3600")
+		io.WriteString(w, "body")
+	}))
+	defer server.Close()
+
+	flaky := &flakyCache{MemoryCache: NewMemoryCache(defaultMaxEntries), failures: 1, err: errors.New("connection pool exhausted")}
+	tp := NewTransport(flaky)
+	tp.Debug = true
+	var events []Event
+	tp.OnCacheError = func(req *http.Request, ev Event) {
+		events = append(events, ev)
+	}
+	resp, err := tp.Client().Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(resp.Header.Get(XHttpcacheDebug), `cache-error="connection pool exhausted"`) {
+		t.Errorf("got debug header %q, want the cache error", resp.Header.Get(XHttpcacheDebug))
+	}
+	if len(events) != 1 || events[0].Err != flaky.err {
+		t.Errorf("got events %+v, want the cache error", events)
+	}
+	if stats := tp.Stats(); stats.CacheErrors != 1 || stats.Fetched != 1 {
+		t.Errorf("got stats %+v, want 1 cache error and 1 fetched response", stats)
+	}
+}
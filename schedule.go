@@ -0,0 +1,155 @@
+package httpcache
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A Scheduler keeps resources fresh in the cache of a Transport, refreshing
+// each of them at its own interval in the background, so that the cache acts
+// as a managed local mirror of these resources. The refreshes revalidate the
+// cached responses, see Transport.Revalidate.
+type Scheduler struct {
+	// Transport is the Transport whose cache is refreshed
+	Transport *Transport
+	// Jitter is the maximum random duration added to each interval, so that
+	// the resources registered together aren't refreshed together
+	Jitter time.Duration
+	// OnRefresh, if set, is called after each successful refresh of the
+	// resource at url, telling whether it changed
+	OnRefresh func(url string, changed bool)
+	// OnError, if set, is called with the error that made a refresh of the
+	// resource at url fail. The resource is refreshed again at the next
+	// interval.
+	OnError func(url string, err error)
+
+	mu   sync.Mutex
+	jobs map[string]scheduledJob // by URL
+	wg   sync.WaitGroup          // running jobs
+}
+
+// A scheduledJob is the goroutine refreshing a resource.
+type scheduledJob struct {
+	cancel context.CancelFunc
+	done   chan struct{} // closed when the goroutine returns
+}
+
+// NewScheduler returns a Scheduler refreshing the cache of t.
+func NewScheduler(t *Transport) *Scheduler {
+	return &Scheduler{Transport: t}
+}
+
+// Add registers the resource at rawurl to be refreshed right away, and then
+// every interval until it is removed. Adding a resource already registered
+// replaces its interval. The interval must be positive.
+func (s *Scheduler) Add(rawurl string, interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("httpcache: invalid refresh interval %v for %s", interval, rawurl)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	job := scheduledJob{cancel: cancel, done: make(chan struct{})}
+	s.mu.Lock()
+	if previous, ok := s.jobs[rawurl]; ok {
+		previous.cancel()
+	}
+	if s.jobs == nil {
+		s.jobs = make(map[string]scheduledJob)
+	}
+	s.jobs[rawurl] = job
+	s.wg.Add(1)
+	s.mu.Unlock()
+	go func() {
+		defer s.wg.Done()
+		defer close(job.done)
+		s.run(ctx, rawurl, interval)
+	}()
+	return nil
+}
+
+// Remove stops refreshing the resource at rawurl, and waits for its refresh
+// in flight, if any, to be canceled, so that OnRefresh and OnError aren't
+// called for it anymore once Remove returns. Its cached response is left in
+// the cache. It mustn't be called from OnRefresh or OnError.
+func (s *Scheduler) Remove(rawurl string) {
+	s.mu.Lock()
+	job, ok := s.jobs[rawurl]
+	if ok {
+		job.cancel()
+		delete(s.jobs, rawurl)
+	}
+	s.mu.Unlock()
+	if ok {
+		<-job.done
+	}
+}
+
+// URLs returns the URLs of the resources registered, in lexical order.
+func (s *Scheduler) URLs() []string {
+	s.mu.Lock()
+	urls := make([]string, 0, len(s.jobs))
+	for rawurl := range s.jobs {
+		urls = append(urls, rawurl)
+	}
+	s.mu.Unlock()
+	sort.Strings(urls)
+	return urls
+}
+
+// Stop stops refreshing all the resources, canceling the refreshes in
+// flight, and waits for them to return, so that the Transport can be
+// dropped safely. It mustn't be called from OnRefresh or OnError.
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	for rawurl, job := range s.jobs {
+		job.cancel()
+		delete(s.jobs, rawurl)
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// run refreshes the resource at rawurl every interval, until ctx is done.
+func (s *Scheduler) run(ctx context.Context, rawurl string, interval time.Duration) {
+	for {
+		s.refresh(ctx, rawurl)
+		wait := interval
+		if s.Jitter > 0 {
+			wait += time.Duration(rand.Int63n(int64(s.Jitter)))
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// refresh revalidates the resource at rawurl, and reports the outcome. The
+// error statuses of the server are reported as failures.
+func (s *Scheduler) refresh(ctx context.Context, rawurl string) {
+	changed, resp, err := s.Transport.revalidate(ctx, rawurl)
+	if err == nil && (resp.StatusCode < 200 || resp.StatusCode >= 300) && resp.StatusCode != http.StatusNotModified {
+		err = fmt.Errorf("httpcache: refreshing %s: %s", rawurl, resp.Status)
+	}
+	if ctx.Err() != nil {
+		// Removed while refreshing
+		return
+	}
+	if err != nil {
+		s.Transport.logError(ctx, "scheduled refresh", err)
+		if s.OnError != nil {
+			s.OnError(rawurl, err)
+		}
+		return
+	}
+	if s.OnRefresh != nil {
+		s.OnRefresh(rawurl, changed)
+	}
+}
@@ -0,0 +1,143 @@
+package httpcache
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestScheduler(t *testing.T) {
+	resetTest()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Header().Set("ETag", `"1"`)
+		if r.Header.Get("If-None-Match") == `"1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		io.WriteString(w, "body")
+	}))
+	defer server.Close()
+
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	s := NewScheduler(tp)
+	defer s.Stop()
+	var mu sync.Mutex
+	var refreshes []bool
+	failed := make(chan error, 1)
+	s.OnRefresh = func(url string, changed bool) {
+		mu.Lock()
+		refreshes = append(refreshes, changed)
+		mu.Unlock()
+	}
+	s.OnError = func(url string, err error) {
+		select {
+		case failed <- err:
+		default:
+		}
+	}
+	if err := s.Add(server.URL, 0); err == nil {
+		t.Fatal("non-positive interval accepted")
+	}
+	s.Add(server.URL, 10*time.Millisecond)
+	s.Add("unsupported://example.com", time.Hour)
+	if urls := s.URLs(); len(urls) != 2 {
+		t.Fatalf("got URLs %v, want 2", urls)
+	}
+	select {
+	case <-failed:
+	case <-time.After(time.Second):
+		t.Fatal("failed refresh not reported")
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		mu.Lock()
+		n := len(refreshes)
+		mu.Unlock()
+		if n >= 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d refreshes, want at least 3", n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	s.Remove(server.URL)
+	mu.Lock()
+	defer mu.Unlock()
+	if !refreshes[0] || refreshes[1] || refreshes[2] {
+		t.Fatalf("got changes %v, want the first refresh only", refreshes)
+	}
+	if _, ok := tp.Cache.Get(server.URL); !ok {
+		t.Fatal("refreshed response not cached")
+	}
+}
+
+func TestSchedulerErrorStatus(t *testing.T) {
+	resetTest()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	s := NewScheduler(NewMemoryCacheTransport(defaultMaxEntries))
+	defer s.Stop()
+	failed := make(chan error, 1)
+	s.OnRefresh = func(url string, changed bool) {
+		t.Errorf("failed refresh of %s reported as successful", url)
+	}
+	s.OnError = func(url string, err error) {
+		select {
+		case failed <- err:
+		default:
+		}
+	}
+	if err := s.Add(server.URL, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-failed:
+		if !strings.Contains(err.Error(), "503") {
+			t.Fatalf("got error %v, want the status", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("error status not reported")
+	}
+}
+
+func TestSchedulerStopWaits(t *testing.T) {
+	resetTest()
+	started := make(chan struct{}, 2)
+	var returned atomic.Int32
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	tp.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		started <- struct{}{}
+		<-req.Context().Done()
+		time.Sleep(50 * time.Millisecond)
+		returned.Add(1)
+		return nil, req.Context().Err()
+	})
+	s := NewScheduler(tp)
+	for _, rawurl := range []string{"http://example.com/a", "http://example.com/b"} {
+		if err := s.Add(rawurl, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-started
+	<-started
+
+	s.Remove("http://example.com/a")
+	if n := returned.Load(); n != 1 {
+		t.Fatalf("Remove returned with %d refreshes done, want 1", n)
+	}
+	s.Stop()
+	if n := returned.Load(); n != 2 {
+		t.Fatalf("Stop returned with %d refreshes done, want 2", n)
+	}
+}
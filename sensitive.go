@@ -0,0 +1,57 @@
+package httpcache
+
+import (
+	"context"
+	"net/http"
+)
+
+type neverPersistKey struct{}
+
+// WithNeverPersist returns a new context based on ctx, for requests whose
+// responses must never be persisted, whatever their headers allow: they are
+// only stored in the SensitiveCache of the Transport, if any.
+func WithNeverPersist(ctx context.Context) context.Context {
+	return context.WithValue(ctx, neverPersistKey{}, true)
+}
+
+// isNeverPersist reports whether ctx is marked by WithNeverPersist.
+func isNeverPersist(ctx context.Context) bool {
+	return ctx.Value(neverPersistKey{}) != nil
+}
+
+// neverPersist reports whether resp, the response to req, must be kept out
+// of the cache of t.
+func (t *Transport) neverPersist(req *http.Request, resp *http.Response) bool {
+	return isNeverPersist(req.Context()) || (t.NeverPersist != nil && t.NeverPersist(req, resp))
+}
+
+// lookup returns the entry stored at key in the cache of t, or else in its
+// SensitiveCache, and the error of the cache if it failed.
+func (t *Transport) lookup(ctx context.Context, key string) ([]byte, bool, error) {
+	if isPeek(ctx) {
+		if b, ok := peek(t.cache(), key); ok {
+			return b, true, nil
+		}
+		if t.SensitiveCache != nil {
+			if b, ok := peek(t.SensitiveCache, key); ok {
+				return b, true, nil
+			}
+		}
+		return nil, false, nil
+	}
+	var err error
+	if cg, ok := t.cache().(ContextGetter); ok {
+		var b []byte
+		if b, ok, err = cg.GetContext(ctx, key); ok {
+			return b, true, nil
+		}
+	} else if b, ok := t.cache().Get(key); ok {
+		return b, true, nil
+	}
+	if t.SensitiveCache != nil {
+		if b, ok := t.SensitiveCache.Get(key); ok {
+			return b, true, nil
+		}
+	}
+	return nil, false, err
+}
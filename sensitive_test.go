@@ -0,0 +1,76 @@
+package httpcache
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestNeverPersist(t *testing.T) {
+	resetTest()
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Cache-Control", "max-age=3600")
+		io.WriteString(w, "body")
+	}))
+	defer server.Close()
+
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	get := func(ctx context.Context, path string) *http.Response {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := tp.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		return resp
+	}
+
+	// Without a SensitiveCache, the marked responses aren't stored
+	get(WithNeverPersist(context.Background()), "/marked")
+	get(WithNeverPersist(context.Background()), "/marked")
+	if n := requests.Load(); n != 2 {
+		t.Fatalf("got %d requests, want 2", n)
+	}
+	if _, ok := tp.Cache.Get(server.URL + "/marked"); ok {
+		t.Fatal("marked response persisted")
+	}
+
+	// With one, they are only stored in it
+	sensitive := NewMemoryCache(defaultMaxEntries)
+	tp.SensitiveCache = sensitive
+	tp.NeverPersist = func(req *http.Request, resp *http.Response) bool {
+		return strings.HasPrefix(req.URL.Path, "/private/")
+	}
+	requests.Store(0)
+	get(context.Background(), "/private/a")
+	resp := get(context.Background(), "/private/a")
+	if n := requests.Load(); resp.Header.Get(XFromCache) != CacheHit || n != 1 {
+		t.Fatalf("got X-From-Cache %q after %d requests, want a hit", resp.Header.Get(XFromCache), n)
+	}
+	if _, ok := tp.Cache.Get(server.URL + "/private/a"); ok {
+		t.Fatal("sensitive response persisted")
+	}
+	if _, ok := sensitive.Get(server.URL + "/private/a"); !ok {
+		t.Fatal("sensitive response not in the SensitiveCache")
+	}
+	get(context.Background(), "/public")
+	if _, ok := tp.Cache.Get(server.URL + "/public"); !ok {
+		t.Fatal("public response not persisted")
+	}
+
+	tp.InvalidateURL(server.URL + "/private/a")
+	if _, ok := sensitive.Get(server.URL + "/private/a"); ok {
+		t.Fatal("sensitive response not invalidated")
+	}
+}
@@ -0,0 +1,78 @@
+package httpcache
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// shadowHeaders are the headers compared between the cached response and the
+// one of the server by shadow requests.
+var shadowHeaders = []string{"Content-Type", "Content-Encoding", "Content-Language", "Location", "Vary"}
+
+// shadow sends req to the server through transport, and reports to
+// OnDivergence whether its response differs from the one cached at key. The
+// cached entry is peeked, so that the shadow requests don't keep it in the
+// cache.
+func (t *Transport) shadow(req *http.Request, key string, transport http.RoundTripper) {
+	b, ok := peek(t.cache(), key)
+	if !ok {
+		return
+	}
+	cached, err := readEntry(b, req)
+	if err != nil {
+		return
+	}
+	cachedBody, err := ioutil.ReadAll(cached.Body)
+	cached.Body.Close()
+	if err != nil {
+		return
+	}
+
+	// The request of the client may be canceled as soon as it is done
+	timeout := t.ShadowTimeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(detach(req.Context()), timeout)
+	defer cancel()
+	outReq := req.Clone(ctx)
+	resp, err := transport.RoundTrip(outReq)
+	if err != nil {
+		t.logError(outReq.Context(), "shadow request", err)
+		return
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.logError(outReq.Context(), "shadow request", err)
+		return
+	}
+	if divergence := compareResponses(cached, cachedBody, resp, body); divergence != "" {
+		t.logDecision(req, key, "divergence", divergence)
+		hook(t.OnDivergence, req, Event{Key: key, Response: resp, Reason: divergence})
+	}
+}
+
+// compareResponses describes the differences between the status, the
+// significant headers and the body of two responses, or returns an empty
+// string if they are equivalent.
+func compareResponses(a *http.Response, aBody []byte, b *http.Response, bBody []byte) string {
+	var diffs []string
+	if a.StatusCode != b.StatusCode {
+		diffs = append(diffs, fmt.Sprintf("status %d != %d", a.StatusCode, b.StatusCode))
+	}
+	for _, name := range shadowHeaders {
+		if av, bv := a.Header.Get(name), b.Header.Get(name); av != bv {
+			diffs = append(diffs, fmt.Sprintf("%s %q != %q", name, av, bv))
+		}
+	}
+	if sha256.Sum256(aBody) != sha256.Sum256(bBody) {
+		diffs = append(diffs, "body")
+	}
+	return strings.Join(diffs, ", ")
+}
@@ -0,0 +1,90 @@
+package httpcache
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestShadow(t *testing.T) {
+	var version atomic.Value
+	version.Store("v1")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, version.Load().(string))
+	}))
+	defer server.Close()
+
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	tp.ShadowSampleRate = 1
+	divergences := make(chan string, 1)
+	tp.OnDivergence = func(req *http.Request, ev Event) { divergences <- ev.Reason }
+	get := func() {
+		resp, err := tp.Client().Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	get()
+	get()
+	select {
+	case reason := <-divergences:
+		t.Fatalf("got divergence %q for an identical response", reason)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	version.Store("v2")
+	get()
+	select {
+	case reason := <-divergences:
+		if reason != "body" {
+			t.Fatalf("got divergence %q, want body", reason)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("divergence not reported")
+	}
+}
+
+func TestShadowTimeout(t *testing.T) {
+	var requests atomic.Int32
+	canceled := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) > 1 {
+			// The shadow request hangs until it times out
+			select {
+			case <-r.Context().Done():
+				close(canceled)
+			case <-time.After(5 * time.Second):
+			}
+			return
+		}
+		w.Header().Set("Cache-Control", "max-age=3600")
+		io.WriteString(w, "body")
+	}))
+	defer server.Close()
+
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	tp.ShadowSampleRate = 1
+	tp.ShadowTimeout = 50 * time.Millisecond
+	for i := 0; i < 2; i++ {
+		resp, err := tp.Client().Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("shadow request not timed out")
+	}
+}
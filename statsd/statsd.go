@@ -0,0 +1,96 @@
+// Package statsd pushes the activity of an httpcache.Transport to a StatsD
+// server, for teams not running Prometheus. Tags are sent with the DogStatsD
+// extension understood by Datadog agents.
+package statsd
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/cozy/httpcache"
+)
+
+// Exporter sends metrics to a StatsD server over UDP. Sending is best-effort:
+// errors are ignored so that metrics never slow requests down.
+type Exporter struct {
+	conn   net.Conn
+	prefix string
+	tags   string
+}
+
+// New returns an Exporter sending metrics to the StatsD server at the UDP
+// address addr. Metric names are prefixed with prefix and a dot if prefix
+// isn't empty, and tags, in the "key:value" form, are attached to every
+// metric.
+func New(addr, prefix string, tags ...string) (*Exporter, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if prefix != "" {
+		prefix += "."
+	}
+	e := &Exporter{conn: conn, prefix: prefix}
+	if len(tags) > 0 {
+		e.tags = "|#" + strings.Join(tags, ",")
+	}
+	return e, nil
+}
+
+// Instrument makes t report its decisions through e, as the following
+// metrics:
+//
+//   - hit, miss, revalidated, modified and bypass: counters of the requests
+//     handled, by outcome
+//   - store: counter of the responses written to the cache
+//   - cache_error: counter of the failed reads and writes of the cache
+//   - lookup: timer of the time spent looking requests up in the cache
+//
+// The hooks already set on t are still called. Instrument must be called
+// before t is used.
+func (e *Exporter) Instrument(t *httpcache.Transport) {
+	t.OnHit = chain(t.OnHit, e.count("hit"))
+	t.OnMiss = chain(t.OnMiss, e.count("miss"))
+	t.OnRevalidate = chain(t.OnRevalidate, e.count("revalidated"))
+	t.OnBypass = chain(t.OnBypass, e.count("bypass"))
+	t.OnStore = chain(t.OnStore, func(*http.Request, httpcache.Event) {
+		e.send("store", "1", "c")
+	})
+	t.OnCacheError = chain(t.OnCacheError, func(*http.Request, httpcache.Event) {
+		e.send("cache_error", "1", "c")
+	})
+}
+
+// Close closes the connection to the StatsD server.
+func (e *Exporter) Close() error {
+	return e.conn.Close()
+}
+
+func (e *Exporter) count(name string) func(*http.Request, httpcache.Event) {
+	return func(req *http.Request, ev httpcache.Event) {
+		if ev.Modified {
+			e.send("modified", "1", "c")
+		} else {
+			e.send(name, "1", "c")
+		}
+		ms := float64(ev.Lookup) / 1e6
+		e.send("lookup", strconv.FormatFloat(ms, 'f', -1, 64), "ms")
+	}
+}
+
+func (e *Exporter) send(name, value, kind string) {
+	e.conn.Write([]byte(e.prefix + name + ":" + value + "|" + kind + e.tags))
+}
+
+// chain returns a hook calling first and then second.
+func chain(first, second func(*http.Request, httpcache.Event)) func(*http.Request, httpcache.Event) {
+	if first == nil {
+		return second
+	}
+	return func(req *http.Request, ev httpcache.Event) {
+		first(req, ev)
+		second(req, ev)
+	}
+}
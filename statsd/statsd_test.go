@@ -0,0 +1,61 @@
+package statsd
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cozy/httpcache"
+)
+
+func TestExporter(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=3600")
+	}))
+	defer server.Close()
+
+	e, err := New(conn.LocalAddr().String(), "myapp", "env:test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	tp := httpcache.NewMemoryCacheTransport(10)
+	e.Instrument(tp)
+	resp, err := tp.Client().Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	var received []string
+	buf := make([]byte, 512)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	for len(received) < 3 {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("got %q before error: %v", received, err)
+		}
+		received = append(received, string(buf[:n]))
+	}
+	if received[0] != "myapp.miss:1|c|#env:test" {
+		t.Fatalf("unexpected first metric: %q", received[0])
+	}
+	if !strings.HasPrefix(received[1], "myapp.lookup:") || !strings.HasSuffix(received[1], "|ms|#env:test") {
+		t.Fatalf("unexpected lookup timer: %q", received[1])
+	}
+	if received[2] != "myapp.store:1|c|#env:test" {
+		t.Fatalf("unexpected store metric: %q", received[2])
+	}
+}
@@ -0,0 +1,90 @@
+package httpcache
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// PurgeTag removes the cached responses tagged with tag by their
+// Surrogate-Key or Cache-Tag header, CDN-style, and returns how many entries
+// were removed.
+//
+// The index of tags is kept in memory by the Transport: it only knows the
+// responses that it stored itself, and forgets those evicted from its cache
+// if the cache is an EvictionNotifier.
+func (t *Transport) PurgeTag(tag string) int {
+	keys := t.taggedKeys(tag)
+	for _, key := range keys {
+		t.delete(context.Background(), key)
+	}
+	t.publish(Invalidation{Tag: tag})
+	return len(keys)
+}
+
+// taggedKeys returns the keys of the responses tagged with tag.
+func (t *Transport) taggedKeys(tag string) []string {
+	st := t.state()
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	keys := make([]string, 0, len(st.tags[tag]))
+	for key := range st.tags[tag] {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// indexTags records the tags of the response stored at key, replacing those
+// of the response it replaced.
+func (t *Transport) indexTags(key string, respHeaders http.Header) {
+	tags := responseTags(respHeaders)
+	t.watchEvictions()
+	st := t.state()
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.unindexTags(key)
+	if len(tags) == 0 {
+		return
+	}
+	if st.tags == nil {
+		st.tags = make(map[string]map[string]struct{})
+		st.keyTags = make(map[string][]string)
+	}
+	for _, tag := range tags {
+		if st.tags[tag] == nil {
+			st.tags[tag] = make(map[string]struct{})
+		}
+		st.tags[tag][key] = struct{}{}
+	}
+	st.keyTags[key] = tags
+}
+
+// unindexTags forgets the tags of the response stored at key. st.mu must be
+// held.
+func (st *transportState) unindexTags(key string) {
+	for _, tag := range st.keyTags[key] {
+		delete(st.tags[tag], key)
+		if len(st.tags[tag]) == 0 {
+			delete(st.tags, tag)
+		}
+	}
+	delete(st.keyTags, key)
+}
+
+// responseTags returns the tags of a response: the space-separated keys of
+// its Surrogate-Key headers, and the comma-separated tags of its Cache-Tag
+// headers.
+func responseTags(respHeaders http.Header) []string {
+	var tags []string
+	for _, value := range respHeaders.Values("Surrogate-Key") {
+		tags = append(tags, strings.Fields(value)...)
+	}
+	for _, value := range respHeaders.Values("Cache-Tag") {
+		for _, tag := range strings.Split(value, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
+}
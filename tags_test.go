@@ -0,0 +1,45 @@
+package httpcache
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPurgeTag(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=3600")
+		switch r.URL.Path {
+		case "/users/1":
+			w.Header().Set("Surrogate-Key", "users user-1")
+		case "/users/2":
+			w.Header().Set("Cache-Tag", "users, user-2")
+		}
+	}))
+	defer server.Close()
+
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	for _, path := range []string{"/users/1", "/users/2", "/groups"} {
+		resp, err := tp.Client().Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	if n := tp.PurgeTag("user-1"); n != 1 {
+		t.Fatalf("purged %d entries tagged user-1, want 1", n)
+	}
+	if n := tp.PurgeTag("users"); n != 1 {
+		t.Fatalf("purged %d entries tagged users, want 1", n)
+	}
+	if keys := tp.Cache.(KeyLister).Keys(); len(keys) != 1 || keys[0] != server.URL+"/groups" {
+		t.Fatalf("got keys %q after purging", keys)
+	}
+	if n := tp.PurgeTag("user-2"); n != 0 {
+		t.Fatalf("purged %d entries for a tag already purged", n)
+	}
+}
@@ -0,0 +1,53 @@
+package httpcache
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Touch extends the freshness of the responses cached for the resource
+// requested by req without contacting the server, as if it had just
+// validated them, e.g. after learning out of band that the resource hasn't
+// changed. If lifetime isn't zero, they are then fresh for lifetime, whatever
+// their headers say, see WithLifetime.
+func (t *Transport) Touch(req *http.Request, lifetime time.Duration) {
+	t.touch(req.Context(), req.URL, lifetime)
+}
+
+// TouchURL extends the freshness of the responses cached for the resource at
+// rawurl, like Touch.
+func (t *Transport) TouchURL(rawurl string, lifetime time.Duration) error {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return err
+	}
+	t.touch(context.Background(), u, lifetime)
+	return nil
+}
+
+// touch extends the freshness of the responses cached for the resource at u,
+// on behalf of the principal of ctx.
+func (t *Transport) touch(ctx context.Context, u *url.URL, lifetime time.Duration) {
+	now := t.now().UTC().Truncate(time.Second)
+	for _, r := range resourceRequests(u) {
+		key := cacheKey(r)
+		touched := t.rewrite(key, r, func(resp *http.Response) {
+			// Expires is shifted along with Date to keep the lifetime
+			if expires, err := http.ParseTime(resp.Header.Get("Expires")); err == nil {
+				if date, ok := parseDate(resp.Header); ok {
+					resp.Header.Set("Expires", expires.Add(now.Sub(date)).Format(http.TimeFormat))
+				}
+			}
+			resp.Header.Set("Date", now.Format(http.TimeFormat))
+			resp.Header.Del(xSoftPurged)
+			if lifetime > 0 {
+				resp.Header.Set(xLifetime, lifetimeMark(lifetime))
+			}
+		})
+		if touched {
+			t.audit(ctx, AuditWrite, key, false)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package httpcache
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTouch(t *testing.T) {
+	for name, setHeaders := range map[string]func(http.Header){
+		"max-age": func(h http.Header) {
+			h.Set("Cache-Control", "max-age=60, must-revalidate")
+		},
+		"expires": func(h http.Header) {
+			h.Set("Cache-Control", "must-revalidate")
+			h.Set("Expires", time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			resetTest()
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				setHeaders(w.Header())
+				io.WriteString(w, "body")
+			}))
+			defer server.Close()
+
+			c := &ttlRecordingCache{MemoryCache: NewMemoryCache(defaultMaxEntries), ttls: map[string]time.Duration{}}
+			tp := NewTransport(c)
+			now := time.Now()
+			tp.Now = func() time.Time { return now }
+			get := func() string {
+				resp, err := tp.Client().Get(server.URL)
+				if err != nil {
+					t.Fatal(err)
+				}
+				io.Copy(ioutil.Discard, resp.Body)
+				resp.Body.Close()
+				return resp.Header.Get(XFromCache)
+			}
+			ttl := func() time.Duration {
+				c.mu.Lock()
+				defer c.mu.Unlock()
+				return c.ttls[server.URL]
+			}
+			get()
+			now = now.Add(2 * time.Minute)
+			if err := tp.TouchURL(server.URL, 0); err != nil {
+				t.Fatal(err)
+			}
+			if get() != CacheHit {
+				t.Fatal("touched response not fresh again")
+			}
+			if ttl := ttl(); ttl < 58*time.Second || ttl > time.Minute {
+				t.Fatalf("got TTL %v for the touched response, want about a minute", ttl)
+			}
+
+			req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+			tp.Touch(req, time.Hour)
+			if ttl := ttl(); ttl < 58*time.Minute || ttl > time.Hour {
+				t.Fatalf("got TTL %v for the response touched for an hour, want about an hour", ttl)
+			}
+			now = now.Add(30 * time.Minute)
+			if get() != CacheHit {
+				t.Fatal("touched response not fresh for the given lifetime")
+			}
+			if requests != 1 {
+				t.Fatalf("got %d requests, want 1", requests)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package httpcache
+
+import "context"
+
+// ClientTrace is a set of hooks run at various stages of the handling of a
+// request by a Transport. Like httptrace.ClientTrace, it is attached to the
+// context of a request, and only instruments that request. Any hook may be
+// nil.
+type ClientTrace struct {
+	// GotCacheLookup is called after the request has been looked up in the
+	// cache, found reporting whether a response was cached
+	GotCacheLookup func(found bool)
+	// CacheHit is called when a fresh response is served from the cache
+	CacheHit func()
+	// RevalidationStart is called before a conditional request is sent to
+	// validate a stale cached response
+	RevalidationStart func()
+	// RevalidationDone is called when the conditional request is done,
+	// modified reporting whether the server sent a new response
+	RevalidationDone func(modified bool, err error)
+	// StoredEntry is called when a response has been written to the cache
+	StoredEntry func(key string)
+}
+
+type clientTraceKey struct{}
+
+// WithClientTrace returns a new context based on ctx, for requests that will
+// run the hooks of trace. If ctx already has a trace, the hooks of both are
+// called, those of trace first.
+func WithClientTrace(ctx context.Context, trace *ClientTrace) context.Context {
+	if old := ContextClientTrace(ctx); old != nil {
+		trace = trace.compose(old)
+	}
+	return context.WithValue(ctx, clientTraceKey{}, trace)
+}
+
+// ContextClientTrace returns the ClientTrace associated with ctx, or nil if
+// there is none.
+func ContextClientTrace(ctx context.Context) *ClientTrace {
+	trace, _ := ctx.Value(clientTraceKey{}).(*ClientTrace)
+	return trace
+}
+
+// compose returns a trace calling the hooks of t, then those of old.
+func (t *ClientTrace) compose(old *ClientTrace) *ClientTrace {
+	return &ClientTrace{
+		GotCacheLookup: func(found bool) {
+			if t.GotCacheLookup != nil {
+				t.GotCacheLookup(found)
+			}
+			if old.GotCacheLookup != nil {
+				old.GotCacheLookup(found)
+			}
+		},
+		CacheHit: func() {
+			if t.CacheHit != nil {
+				t.CacheHit()
+			}
+			if old.CacheHit != nil {
+				old.CacheHit()
+			}
+		},
+		RevalidationStart: func() {
+			if t.RevalidationStart != nil {
+				t.RevalidationStart()
+			}
+			if old.RevalidationStart != nil {
+				old.RevalidationStart()
+			}
+		},
+		RevalidationDone: func(modified bool, err error) {
+			if t.RevalidationDone != nil {
+				t.RevalidationDone(modified, err)
+			}
+			if old.RevalidationDone != nil {
+				old.RevalidationDone(modified, err)
+			}
+		},
+		StoredEntry: func(key string) {
+			if t.StoredEntry != nil {
+				t.StoredEntry(key)
+			}
+			if old.StoredEntry != nil {
+				old.StoredEntry(key)
+			}
+		},
+	}
+}
@@ -0,0 +1,60 @@
+package httpcache
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestClientTrace(t *testing.T) {
+	resetTest()
+	var events []string
+	trace := &ClientTrace{
+		GotCacheLookup: func(found bool) {
+			if found {
+				events = append(events, "found")
+			} else {
+				events = append(events, "not found")
+			}
+		},
+		CacheHit:          func() { events = append(events, "hit") },
+		RevalidationStart: func() { events = append(events, "revalidation start") },
+		RevalidationDone: func(modified bool, err error) {
+			if modified {
+				events = append(events, "modified")
+			} else {
+				events = append(events, "not modified")
+			}
+		},
+		StoredEntry: func(key string) { events = append(events, "stored") },
+	}
+	other := &ClientTrace{CacheHit: func() { events = append(events, "other hit") }}
+	ctx := WithClientTrace(WithClientTrace(context.Background(), other), trace)
+
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	for _, path := range []string{"/method", "/method", "/etag", "/etag"} {
+		req, err := http.NewRequestWithContext(ctx, "GET", s.server.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := tp.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	want := []string{
+		"not found", "stored",
+		"found", "hit", "other hit",
+		"not found", "stored",
+		"found", "revalidation start", "not modified", "stored",
+	}
+	if strings.Join(events, ", ") != strings.Join(want, ", ") {
+		t.Fatalf("got events %q, want %q", events, want)
+	}
+}
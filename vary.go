@@ -0,0 +1,56 @@
+package httpcache
+
+import (
+	"net/http"
+	"strings"
+)
+
+// xVaried prefixes the headers recording, in a shared cache, the values of
+// the request headers named by the Vary header of a cached response, to only
+// serve it to the requests with the same values. They are only stored in the
+// cache, never served.
+const xVaried = "X-Httpcache-Varied-"
+
+// varyNames returns the names of the request headers listed by the Vary
+// headers of respHeaders.
+func varyNames(respHeaders http.Header) []string {
+	var names []string
+	for _, vary := range respHeaders.Values("Vary") {
+		for _, name := range strings.Split(vary, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// withVaried returns marks with, in a shared cache, the values of the headers
+// of req named by the Vary header of respHeaders, the headers of the response
+// to req.
+func (p Policy) withVaried(marks http.Header, req *http.Request, respHeaders http.Header) http.Header {
+	if !p.Shared {
+		return marks
+	}
+	for _, name := range varyNames(respHeaders) {
+		if name == "*" {
+			continue
+		}
+		if marks == nil {
+			marks = http.Header{}
+		}
+		marks.Set(xVaried+name, strings.Join(req.Header.Values(name), ", "))
+	}
+	return marks
+}
+
+// variedMatches reports whether the cached response with the headers
+// respHeaders, marked by withVaried, is the variant to serve to req.
+func variedMatches(respHeaders http.Header, req *http.Request) bool {
+	for _, name := range varyNames(respHeaders) {
+		if name == "*" || respHeaders.Get(xVaried+name) != strings.Join(req.Header.Values(name), ", ") {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,153 @@
+// Package vcr turns an httpcache.Cache into a store of test fixtures for API
+// clients: a Recorder records the interactions with a server, then replays
+// them without any network access.
+package vcr
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"sort"
+	"strings"
+
+	"github.com/cozy/httpcache"
+)
+
+// Mode is the mode of operation of a Recorder.
+type Mode int
+
+const (
+	// Record sends the requests to the server and stores all the responses,
+	// whether they are cacheable or not
+	Record Mode = iota
+	// Replay serves the requests from the fixtures only, and fails those
+	// that have not been recorded
+	Replay
+)
+
+// ErrUnexpectedRequest is returned in Replay mode for a request that has not
+// been recorded.
+var ErrUnexpectedRequest = errors.New("vcr: unexpected request")
+
+// Recorder is an http.RoundTripper recording responses to, or replaying them
+// from, a fixture backend.
+type Recorder struct {
+	// Mode is the mode of operation of the recorder
+	Mode Mode
+	// Fixtures stores the recorded responses
+	Fixtures httpcache.Cache
+	// Transport is used to send the requests in Record mode. If nil,
+	// http.DefaultTransport is used.
+	Transport http.RoundTripper
+	// Matcher selects the parts of a request that identify its fixture. If
+	// nil, DefaultMatcher is used.
+	Matcher *Matcher
+}
+
+// Matcher selects the parts of a request that identify its fixture, so that
+// the fixtures tolerate volatile parts such as dates or request IDs. The
+// same Matcher must be used to record and to replay the fixtures.
+type Matcher struct {
+	// Method makes requests match only with the same method
+	Method bool
+	// Host makes requests match only with the same scheme and host
+	Host bool
+	// Path makes requests match only with the same path
+	Path bool
+	// Query makes requests match only with the same query string
+	Query bool
+	// Headers lists the headers whose values must be the same for requests
+	// to match
+	Headers []string
+	// Body makes requests match only with the same body, compared by hash
+	Body bool
+}
+
+// DefaultMatcher matches the requests with the same method and URL.
+var DefaultMatcher = Matcher{Method: true, Host: true, Path: true, Query: true}
+
+// Key returns the key identifying the fixture of req. Reading the body to
+// hash it, if m.Body is set, leaves an equivalent body on req.
+func (m Matcher) Key(req *http.Request) (string, error) {
+	var key strings.Builder
+	if m.Method {
+		key.WriteString(req.Method)
+		key.WriteByte(' ')
+	}
+	if m.Host {
+		key.WriteString(req.URL.Scheme + "://" + req.URL.Host)
+	}
+	if m.Path {
+		key.WriteString(req.URL.EscapedPath())
+	}
+	if m.Query && req.URL.RawQuery != "" {
+		key.WriteString("?" + req.URL.RawQuery)
+	}
+	headers := append([]string(nil), m.Headers...)
+	sort.Strings(headers)
+	for _, name := range headers {
+		fmt.Fprintf(&key, " %s=%q", http.CanonicalHeaderKey(name), strings.Join(req.Header.Values(name), ","))
+	}
+	if m.Body && req.Body != nil && req.Body != http.NoBody {
+		body, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return "", err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		fmt.Fprintf(&key, " body=%x", sha256.Sum256(body))
+	}
+	return key.String(), nil
+}
+
+// New returns a new Recorder operating in mode on fixtures.
+func New(mode Mode, fixtures httpcache.Cache) *Recorder {
+	return &Recorder{Mode: mode, Fixtures: fixtures}
+}
+
+// Client returns an *http.Client that records or replays requests.
+func (r *Recorder) Client() *http.Client {
+	return &http.Client{Transport: r}
+}
+
+// RoundTrip records the response to req, or replays it, depending on the mode
+// of r.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	matcher := r.Matcher
+	if matcher == nil {
+		matcher = &DefaultMatcher
+	}
+	key, err := matcher.Key(req)
+	if err != nil {
+		return nil, err
+	}
+	if r.Mode == Replay {
+		b, ok := r.Fixtures.Get(key)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s %s", ErrUnexpectedRequest, req.Method, req.URL)
+		}
+		return http.ReadResponse(bufio.NewReader(bytes.NewReader(b)), req)
+	}
+
+	transport := r.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	// DumpResponse reads the body, and replaces it with an equivalent one
+	b, err := httputil.DumpResponse(resp, true)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	r.Fixtures.Set(key, b)
+	return resp, nil
+}
@@ -0,0 +1,93 @@
+package vcr
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cozy/httpcache"
+)
+
+func TestRecordReplay(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Cache-Control", "no-store")
+		fmt.Fprintf(w, "%s %s", r.Method, r.URL.Path)
+	}))
+	fixtures := httpcache.NewMemoryCache(0)
+
+	recorder := New(Record, fixtures)
+	get(t, recorder.Client(), server.URL+"/a", "GET /a")
+	server.Close()
+
+	recorder.Mode = Replay
+	get(t, recorder.Client(), server.URL+"/a", "GET /a")
+	if calls != 1 {
+		t.Fatalf("server called %d times, want 1", calls)
+	}
+	_, err := recorder.Client().Get(server.URL + "/b")
+	if !errors.Is(err, ErrUnexpectedRequest) {
+		t.Fatalf("got error %v for an unexpected request", err)
+	}
+}
+
+func get(t *testing.T, client *http.Client, url, want string) {
+	t.Helper()
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != want {
+		t.Fatalf("got body %q, want %q", body, want)
+	}
+}
+
+func TestMatcher(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		fmt.Fprintf(w, "%s %s", r.Header.Get("X-Tenant"), body)
+	}))
+	defer server.Close()
+	recorder := New(Record, httpcache.NewMemoryCache(0))
+	recorder.Matcher = &Matcher{Method: true, Path: true, Headers: []string{"x-tenant"}, Body: true}
+
+	do := func(tenant, requestID, body string) (string, error) {
+		req, err := http.NewRequest(http.MethodPost, server.URL+"/items?ts="+requestID, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Tenant", tenant)
+		req.Header.Set("X-Request-Id", requestID)
+		resp, err := recorder.RoundTrip(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		return string(b), err
+	}
+	if got, err := do("a", "1", "x"); err != nil || got != "a x" {
+		t.Fatalf("got %q, %v when recording", got, err)
+	}
+
+	recorder.Mode = Replay
+	if got, err := do("a", "2", "x"); err != nil || got != "a x" {
+		t.Fatalf("got %q, %v with a different request ID", got, err)
+	}
+	if _, err := do("b", "1", "x"); !errors.Is(err, ErrUnexpectedRequest) {
+		t.Fatalf("got error %v with a different tenant", err)
+	}
+	if _, err := do("a", "1", "y"); !errors.Is(err, ErrUnexpectedRequest) {
+		t.Fatalf("got error %v with a different body", err)
+	}
+}
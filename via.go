@@ -0,0 +1,41 @@
+package httpcache
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// viaValue returns the Via header value added by a Transport named via, for
+// a message of the given protocol version.
+func viaValue(major, minor int, via string) string {
+	if major == 0 {
+		major, minor = 1, 1
+	}
+	if major >= 2 {
+		return strconv.Itoa(major) + " " + via
+	}
+	return strconv.Itoa(major) + "." + strconv.Itoa(minor) + " " + via
+}
+
+// addVia appends the Via header value of a Transport named via to header.
+func addVia(header http.Header, major, minor int, via string) {
+	header.Add("Via", viaValue(major, minor, via))
+}
+
+// removeVia removes the Via header value of a Transport named via from
+// header.
+func removeVia(header http.Header, major, minor int, via string) {
+	value := viaValue(major, minor, via)
+	values := header.Values("Via")
+	for i := len(values) - 1; i >= 0; i-- {
+		if values[i] == value {
+			values = append(values[:i:i], values[i+1:]...)
+			break
+		}
+	}
+	if len(values) == 0 {
+		header.Del("Via")
+	} else {
+		header["Via"] = values
+	}
+}
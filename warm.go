@@ -0,0 +1,225 @@
+package httpcache
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Warming outcomes, reported in WarmResult.
+const (
+	// WarmFetched means the server sent a full response
+	WarmFetched = "fetched"
+	// WarmHit means a fresh response was already cached
+	WarmHit = "hit"
+	// WarmRevalidated means the cached response was revalidated with the
+	// server
+	WarmRevalidated = "revalidated"
+)
+
+// WarmResult is the result of warming the cache for a URL.
+type WarmResult struct {
+	URL string
+	// Status is the status code of the response
+	Status int
+	// Outcome tells how the response was obtained: WarmFetched, WarmHit or
+	// WarmRevalidated
+	Outcome string
+	// Stored reports whether the response was written to the cache
+	Stored bool
+	// Err is the error that prevented warming the URL, if any
+	Err error
+}
+
+// WarmOptions configures the warming of a cache.
+type WarmOptions struct {
+	// Concurrency is the maximum number of URLs fetched at once. It
+	// defaults to one.
+	Concurrency int
+	// Interval is the minimum time between the start of two fetches, to
+	// rate limit the warming. Zero means no limit.
+	Interval time.Duration
+	// Progress, if set, is called after each URL is warmed, with the number
+	// of URLs warmed so far and the total. Calls are serialized.
+	Progress func(done, total int, result WarmResult)
+}
+
+// Warm fetches the given URLs through the caching pipeline of t, at most
+// concurrency at a time, so that fresh responses are stored or revalidated
+// before they are needed, e.g. at startup. It returns the results in the order
+// of urls.
+func (t *Transport) Warm(ctx context.Context, urls []string, concurrency int) []WarmResult {
+	return t.WarmWithOptions(ctx, urls, WarmOptions{Concurrency: concurrency})
+}
+
+// WarmWithOptions is like Warm, with the fetches configured by opts.
+func (t *Transport) WarmWithOptions(ctx context.Context, urls []string, opts WarmOptions) []WarmResult {
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	var tick <-chan time.Time
+	if opts.Interval > 0 {
+		ticker := time.NewTicker(opts.Interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	results := make([]WarmResult, len(urls))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	done := 0
+	for i, url := range urls {
+		if tick != nil && i > 0 {
+			select {
+			case <-tick:
+			case <-ctx.Done():
+			}
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int, url string) {
+			defer wg.Done()
+			results[i] = t.warm(ctx, url)
+			<-sem
+			if opts.Progress != nil {
+				mu.Lock()
+				done++
+				opts.Progress(done, len(urls), results[i])
+				mu.Unlock()
+			}
+		}(i, url)
+	}
+	wg.Wait()
+	return results
+}
+
+// WarmList warms the cache with the URLs listed in r, one per line. Blank
+// lines and lines starting with # are ignored.
+func (t *Transport) WarmList(ctx context.Context, r io.Reader, opts WarmOptions) ([]WarmResult, error) {
+	var urls []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		urls = append(urls, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return t.WarmWithOptions(ctx, urls, opts), nil
+}
+
+// sitemap holds the locations listed in a sitemap or a sitemap index.
+type sitemap struct {
+	URLs     []string `xml:"url>loc"`
+	Sitemaps []string `xml:"sitemap>loc"`
+}
+
+// WarmSitemap warms the cache with the URLs listed in the sitemap at
+// sitemapURL, following the sitemaps of a sitemap index. Gzipped sitemaps are
+// supported, when their URL ends with .gz.
+func (t *Transport) WarmSitemap(ctx context.Context, sitemapURL string, opts WarmOptions) ([]WarmResult, error) {
+	urls, err := t.sitemapURLs(ctx, sitemapURL, true)
+	if err != nil {
+		return nil, err
+	}
+	return t.WarmWithOptions(ctx, urls, opts), nil
+}
+
+// sitemapURLs returns the URLs listed in the sitemap at sitemapURL, following
+// the sitemaps it lists if index is true.
+func (t *Transport) sitemapURLs(ctx context.Context, sitemapURL string, index bool) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sitemapURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("httpcache: fetching sitemap %s: %s", sitemapURL, resp.Status)
+	}
+	var body io.Reader = resp.Body
+	if strings.HasSuffix(req.URL.Path, ".gz") {
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		body = gz
+	}
+	var sm sitemap
+	if err := xml.NewDecoder(body).Decode(&sm); err != nil {
+		return nil, fmt.Errorf("httpcache: parsing sitemap %s: %v", sitemapURL, err)
+	}
+	// Read what's left so that the sitemap itself gets cached
+	io.Copy(ioutil.Discard, resp.Body)
+	urls := trimAll(sm.URLs)
+	if index {
+		for _, loc := range trimAll(sm.Sitemaps) {
+			more, err := t.sitemapURLs(ctx, loc, false)
+			if err != nil {
+				return nil, err
+			}
+			urls = append(urls, more...)
+		}
+	}
+	return urls, nil
+}
+
+// trimAll trims the spaces around each of strs, in place.
+func trimAll(strs []string) []string {
+	for i, s := range strs {
+		strs[i] = strings.TrimSpace(s)
+	}
+	return strs
+}
+
+// warm fetches url through t, reading the whole body so that the response
+// gets stored.
+func (t *Transport) warm(ctx context.Context, url string) WarmResult {
+	result := WarmResult{URL: url, Outcome: WarmFetched}
+	if err := ctx.Err(); err != nil {
+		result.Err = err
+		return result
+	}
+	trace := &ClientTrace{
+		CacheHit: func() { result.Outcome = WarmHit },
+		RevalidationDone: func(modified bool, err error) {
+			if !modified && err == nil {
+				result.Outcome = WarmRevalidated
+			}
+		},
+		StoredEntry: func(key string) { result.Stored = true },
+	}
+	req, err := http.NewRequestWithContext(withBackground(WithClientTrace(ctx, trace)), http.MethodGet, url, nil)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	resp, err := t.RoundTrip(req)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	result.Status = resp.StatusCode
+	_, err = io.Copy(ioutil.Discard, resp.Body)
+	if cerr := resp.Body.Close(); err == nil {
+		err = cerr
+	}
+	result.Err = err
+	return result
+}
@@ -0,0 +1,86 @@
+package httpcache
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWarm(t *testing.T) {
+	resetTest()
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	urls := []string{s.server.URL + "/method", s.server.URL + "/etag", s.server.URL + "/nostore", "://invalid"}
+
+	results := tp.Warm(context.Background(), urls, 2)
+	for i, want := range []WarmResult{
+		{Status: 200, Outcome: WarmFetched, Stored: true},
+		{Status: 200, Outcome: WarmFetched, Stored: true},
+		{Status: 200, Outcome: WarmFetched},
+	} {
+		got := results[i]
+		if got.URL != urls[i] || got.Status != want.Status || got.Outcome != want.Outcome || got.Stored != want.Stored || got.Err != nil {
+			t.Errorf("got %+v for %s, want %+v", got, urls[i], want)
+		}
+	}
+	if results[3].Err == nil {
+		t.Error("no error for an invalid URL")
+	}
+
+	results = tp.Warm(context.Background(), urls[:2], 0)
+	if results[0].Outcome != WarmHit || results[1].Outcome != WarmRevalidated {
+		t.Errorf("got %+v when warming again", results)
+	}
+}
+
+func TestWarmSitemap(t *testing.T) {
+	resetTest()
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	mux.HandleFunc("/sitemap.xml", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>%s/pages.xml</loc></sitemap>
+</sitemapindex>`, server.URL)
+	})
+	mux.HandleFunc("/pages.xml", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>%s/a</loc></url>
+  <url><loc> %s/b </loc></url>
+</urlset>`, server.URL, server.URL)
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=3600")
+	})
+
+	tp := NewMemoryCacheTransport(defaultMaxEntries)
+	var progress []int
+	opts := WarmOptions{
+		Concurrency: 2,
+		Interval:    time.Millisecond,
+		Progress:    func(done, total int, result WarmResult) { progress = append(progress, done, total) },
+	}
+	results, err := tp.WarmSitemap(context.Background(), server.URL+"/sitemap.xml", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].URL != server.URL+"/a" || results[1].URL != server.URL+"/b" || !results[0].Stored {
+		t.Fatalf("got results %+v", results)
+	}
+	if fmt.Sprint(progress) != "[1 2 2 2]" {
+		t.Fatalf("got progress %v", progress)
+	}
+
+	list := "# pages\n" + server.URL + "/a\n\n" + server.URL + "/c\n"
+	results, err = tp.WarmList(context.Background(), strings.NewReader(list), WarmOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Outcome != WarmHit || results[1].Outcome != WarmFetched {
+		t.Fatalf("got results %+v", results)
+	}
+}